@@ -0,0 +1,150 @@
+/*
+Copyright 2024 extreme.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+type ClusterInplaceUpdateContainer struct {
+	// Name selects containers by name
+	// +optional
+	Name string `json:"name,omitempty"`
+	// ImagePattern selects containers whose current image matches the glob pattern, e.g. envoyproxy/envoy:*
+	// The wildcards also match "/", so *envoy* matches docker.io/envoyproxy/envoy:v1.28.0
+	// +optional
+	ImagePattern string `json:"imagePattern,omitempty"`
+	// Image is the new image of the selected containers
+	Image string `json:"image"`
+}
+
+// ClusterInplaceUpdateSpec defines the desired state of ClusterInplaceUpdate
+type ClusterInplaceUpdateSpec struct {
+	// NamespaceSelector selects the namespaces to look for workloads in
+	// nil selects all namespaces
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// WorkloadSelector selects workloads by label
+	// nil selects all workloads
+	// +optional
+	WorkloadSelector *metav1.LabelSelector `json:"workloadSelector,omitempty"`
+	// Containers defines the containers to be updated
+	// a workload is selected only if at least one of its containers matches
+	Containers []ClusterInplaceUpdateContainer `json:"containers"`
+	// MaxConcurrency is the maximum number of child InplaceUpdates running at the same time
+	// default is 1
+	// +optional
+	MaxConcurrency *int32 `json:"maxConcurrency,omitempty"`
+	// FailureBudget is the number of failed child InplaceUpdates tolerated
+	// before no more children are created
+	// default is 0
+	// +optional
+	FailureBudget *int32 `json:"failureBudget,omitempty"`
+	// MaxUnavailable is copied to every child InplaceUpdate
+	// +optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+	// MaxSurge is copied to every child InplaceUpdate
+	// +optional
+	MaxSurge *intstr.IntOrString `json:"maxSurge,omitempty"`
+	// Ordering is copied to every child InplaceUpdate
+	// +optional
+	Ordering *WaveOrdering `json:"ordering,omitempty"`
+	// UpdateStrategy is copied to every child InplaceUpdate
+	// +optional
+	UpdateStrategy UpdateStrategyType `json:"updateStrategy,omitempty"`
+	// Atomic is copied to every child InplaceUpdate
+	// +optional
+	Atomic bool `json:"atomic,omitempty"`
+	// Autoscalers is copied to every child InplaceUpdate
+	// +optional
+	Autoscalers *AutoscalerCoordination `json:"autoscalers,omitempty"`
+	// Delay is copied to every child InplaceUpdate
+	// +optional
+	Delay *int32 `json:"delay,omitempty"`
+	// FailurePolicy is copied to every child InplaceUpdate
+	// +optional
+	FailurePolicy FailurePolicyType `json:"failurePolicy,omitempty"`
+}
+
+type ClusterInplaceUpdateChildStatus struct {
+	// Namespace of the child InplaceUpdate and its workload
+	Namespace string `json:"namespace"`
+	// Name of the child InplaceUpdate
+	Name string `json:"name"`
+	// Target is the name of the workload
+	Target string `json:"target"`
+	// Phase of the child InplaceUpdate
+	Phase InplaceUpdatePhase `json:"phase,omitempty"`
+}
+
+// ClusterInplaceUpdateStatus defines the observed state of ClusterInplaceUpdate
+type ClusterInplaceUpdateStatus struct {
+	// Workloads is the number of workloads selected
+	Workloads int32 `json:"workloads"`
+	// Pending is the number of workloads not started yet
+	Pending int32 `json:"pending"`
+	// Running is the number of child InplaceUpdates running
+	Running int32 `json:"running"`
+	// Finished is the number of child InplaceUpdates finished
+	Finished int32 `json:"finished"`
+	// Failed is the number of child InplaceUpdates failed
+	Failed int32 `json:"failed"`
+	// Children is the summary of the child InplaceUpdates
+	Children []ClusterInplaceUpdateChildStatus `json:"children,omitempty"`
+	// ObservedGeneration is the most recent generation observed by the controller
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions represent the latest available observations of the fleet update
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions     []metav1.Condition `json:"conditions,omitempty"`
+	StartTime      *metav1.Time       `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time       `json:"completionTime,omitempty"`
+	Phase          InplaceUpdatePhase `json:"phase,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Workloads",type=integer,JSONPath=`.status.workloads`
+//+kubebuilder:printcolumn:name="Finished",type=integer,JSONPath=`.status.finished`
+//+kubebuilder:printcolumn:name="Failed",type=integer,JSONPath=`.status.failed`
+
+// ClusterInplaceUpdate is the Schema for the clusterinplaceupdates API
+type ClusterInplaceUpdate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterInplaceUpdateSpec   `json:"spec,omitempty"`
+	Status ClusterInplaceUpdateStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ClusterInplaceUpdateList contains a list of ClusterInplaceUpdate
+type ClusterInplaceUpdateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterInplaceUpdate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterInplaceUpdate{}, &ClusterInplaceUpdateList{})
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2024 extreme.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// log is for logging in this package.
+var clusterinplaceupdatelog = logf.Log.WithName("clusterinplaceupdate-resource")
+
+// SetupWebhookWithManager will setup the manager to manage the webhooks
+func (r *ClusterInplaceUpdate) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithValidator(&ClusterInplaceUpdateValidator{}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-apps-demo-cyisme-top-v1-clusterinplaceupdate,mutating=false,failurePolicy=fail,sideEffects=None,groups=apps.demo.cyisme.top,resources=clusterinplaceupdates,verbs=create;update,versions=v1,name=vclusterinplaceupdate.kb.io,admissionReviewVersions=v1
+
+// ClusterInplaceUpdateValidator validates ClusterInplaceUpdates
+// +kubebuilder:object:generate=false
+type ClusterInplaceUpdateValidator struct{}
+
+var _ webhook.CustomValidator = &ClusterInplaceUpdateValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *ClusterInplaceUpdateValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, v.validate(obj)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *ClusterInplaceUpdateValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	return nil, v.validate(newObj)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *ClusterInplaceUpdateValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *ClusterInplaceUpdateValidator) validate(obj runtime.Object) error {
+	r, ok := obj.(*ClusterInplaceUpdate)
+	if !ok {
+		return fmt.Errorf("expected a ClusterInplaceUpdate but got a %T", obj)
+	}
+	clusterinplaceupdatelog.Info("validate", "name", r.Name)
+
+	if errs := ValidateClusterInplaceUpdateSpec(&r.Spec); len(errs) != 0 {
+		return apierrors.NewInvalid(GroupVersion.WithKind("ClusterInplaceUpdate").GroupKind(), r.Name, errs)
+	}
+	return nil
+}
+
+// ValidateClusterInplaceUpdateSpec checks the image patterns selecting the containers
+func ValidateClusterInplaceUpdateSpec(spec *ClusterInplaceUpdateSpec) field.ErrorList {
+	var errs field.ErrorList
+	for i, c := range spec.Containers {
+		if c.ImagePattern == "" {
+			continue
+		}
+		if _, err := MatchImagePattern(c.ImagePattern, ""); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("spec", "containers").Index(i).Child("imagePattern"), c.ImagePattern, err.Error()))
+		}
+	}
+	return errs
+}
+
+// MatchImagePattern reports whether image matches the glob pattern of an ImagePattern. The syntax is the one
+// of path.Match, except that * and ? also match "/" so that *envoy* matches docker.io/envoyproxy/envoy:v1.28.0.
+func MatchImagePattern(pattern, image string) (bool, error) {
+	// "/" is the only separator of path.Match, it is swapped for a NUL byte which never is in an image
+	return path.Match(strings.ReplaceAll(pattern, "/", "\x00"), strings.ReplaceAll(image, "/", "\x00"))
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2024 extreme.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMatchImagePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		image   string
+		want    bool
+		wantErr bool
+	}{
+		{pattern: "envoyproxy/envoy:*", image: "envoyproxy/envoy:v1.28.0", want: true},
+		{pattern: "envoyproxy/envoy:*", image: "docker.io/envoyproxy/envoy:v1.28.0"},
+		{pattern: "*envoy*", image: "docker.io/envoyproxy/envoy:v1.28.0", want: true},
+		{pattern: "*/envoyproxy/envoy:v1.2?.*", image: "registry.example.com:5000/mirror/envoyproxy/envoy:v1.28.0", want: true},
+		{pattern: "*envoy*", image: "nginx:1.25.4"},
+		{pattern: "envoy[", image: "envoy", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.image, func(t *testing.T) {
+			got, err := MatchImagePattern(tt.pattern, tt.image)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestValidateClusterInplaceUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		wantErr bool
+	}{
+		{name: "no pattern"},
+		{name: "valid pattern", pattern: "*envoy*"},
+		{name: "invalid pattern", pattern: "envoy[", wantErr: true},
+		{name: "invalid pattern with a matching prefix", pattern: "*[", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ClusterInplaceUpdate{Spec: ClusterInplaceUpdateSpec{Containers: []ClusterInplaceUpdateContainer{
+				{Name: "envoy", ImagePattern: tt.pattern, Image: "envoyproxy/envoy:v1.29.1"},
+			}}}
+			_, err := (&ClusterInplaceUpdateValidator{}).ValidateCreate(context.Background(), r)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
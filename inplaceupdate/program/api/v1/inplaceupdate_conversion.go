@@ -0,0 +1,505 @@
+/*
+Copyright 2024 extreme.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/Forget-C/demo/inplaceupdate/program/api/v1beta2"
+)
+
+// AnnotationRollingUpdateKey keeps spec.rollingUpdate, which has no counterpart in v1beta2,
+// so that converting back to v1 is lossless
+const AnnotationRollingUpdateKey = "demo.cyisme.top/v1-rolling-update"
+
+var _ conversion.Convertible = &InplaceUpdate{}
+
+// ConvertTo converts this InplaceUpdate to the hub version (v1beta2)
+func (src *InplaceUpdate) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1beta2.InplaceUpdate)
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	delete(dst.Annotations, AnnotationRollingUpdateKey)
+	if src.Spec.RollingUpdate {
+		if dst.Annotations == nil {
+			dst.Annotations = map[string]string{}
+		}
+		dst.Annotations[AnnotationRollingUpdateKey] = strconv.FormatBool(src.Spec.RollingUpdate)
+	}
+
+	spec := &src.Spec
+	if spec.TargetReference != nil {
+		dst.Spec.Target = &v1beta2.TargetReference{
+			APIVersion: spec.TargetReference.APIVersion,
+			Kind:       spec.TargetReference.Kind,
+			Name:       spec.TargetReference.Name,
+		}
+	}
+	dst.Spec.Containers = containersToHub(spec.Containers)
+	dst.Spec.RollbackTo = copyInt64(spec.RollbackTo)
+	dst.Spec.Strategy = v1beta2.UpdateStrategy{
+		Type:                    v1beta2.UpdateStrategyType(spec.UpdateStrategy),
+		MaxUnavailable:          copyIntOrString(spec.MaxUnavailable),
+		MaxSurge:                copyIntOrString(spec.MaxSurge),
+		Ordering:                orderingToHub(spec.Ordering),
+		Atomic:                  spec.Atomic,
+		Autoscalers:             autoscalersToHub(spec.Autoscalers),
+		DelaySeconds:            copyInt32(spec.Delay),
+		ProgressDeadlineSeconds: copyInt32(spec.ProgressDeadlineSeconds),
+	}
+	if schedule := spec.Schedule; schedule != nil {
+		dst.Spec.Strategy.Schedule = &v1beta2.UpdateSchedule{
+			StartTime: schedule.StartTime.DeepCopy(),
+			Cron:      schedule.Cron,
+			TimeZone:  schedule.TimeZone,
+		}
+		for _, window := range schedule.Windows {
+			w := v1beta2.ScheduleWindow{StartHour: window.StartHour, EndHour: window.EndHour}
+			for _, day := range window.Days {
+				w.Days = append(w.Days, v1beta2.Weekday(day))
+			}
+			dst.Spec.Strategy.Schedule.Windows = append(dst.Spec.Strategy.Schedule.Windows, w)
+		}
+	}
+	dst.Spec.Strategy.Analysis = analysisToHub(spec.Analysis)
+	dst.Spec.Policy = v1beta2.UpdatePolicy{
+		Failure:     v1beta2.FailurePolicyType(spec.FailurePolicy),
+		Reclaim:     v1beta2.ReclaimPolicyType(spec.ReclaimPolicy),
+		Concurrency: v1beta2.ConcurrencyPolicyType(spec.ConcurrencyPolicy),
+		Supersede:   spec.Supersede,
+	}
+	dst.Spec.Paused = spec.Paused
+	dst.Spec.Abort = spec.Abort
+	dst.Spec.DryRun = spec.DryRun
+	dst.Spec.Reason = spec.Reason
+	dst.Spec.ChangeRef = spec.ChangeRef
+
+	status := &src.Status
+	dst.Status = v1beta2.InplaceUpdateStatus{
+		Replicas:               status.Replicas,
+		UpdatedReplicas:        status.UpdatedReplicas,
+		UnavailableReplicas:    status.UnavailableReplicas,
+		SurgeReplicas:          status.SurgeReplicas,
+		RecreatedReplicas:      status.RecreatedReplicas,
+		RevertedReplicas:       status.RevertedReplicas,
+		ContainerNumber:        status.ContainerNumber,
+		UpdatedContainerNumber: status.UpdatedContainerNumber,
+		ObservedGeneration:     status.ObservedGeneration,
+		StartTime:              status.StartTime.DeepCopy(),
+		CompletionTime:         status.CompletionTime.DeepCopy(),
+		QueuePosition:          status.QueuePosition,
+		BlockedBy:              status.BlockedBy,
+		NextScheduleTime:       status.NextScheduleTime.DeepCopy(),
+		Phase:                  v1beta2.InplaceUpdatePhase(status.Phase),
+	}
+	if rollback := status.Rollback; rollback != nil {
+		dst.Status.Rollback = &v1beta2.InplaceUpdateRollback{Revision: rollback.Revision, Containers: containersToHub(rollback.Containers)}
+	}
+	for i := range status.Conditions {
+		dst.Status.Conditions = append(dst.Status.Conditions, *status.Conditions[i].DeepCopy())
+	}
+	for _, wave := range status.Waves {
+		dst.Status.Waves = append(dst.Status.Waves, v1beta2.InplaceUpdateWave{
+			Number:         wave.Number,
+			Pods:           append([]string(nil), wave.Pods...),
+			RecreatedPods:  append([]string(nil), wave.RecreatedPods...),
+			RevertedPods:   append([]string(nil), wave.RevertedPods...),
+			StartTime:      wave.StartTime.DeepCopy(),
+			CompletionTime: wave.CompletionTime.DeepCopy(),
+			Analysis:       waveAnalysisToHub(wave.Analysis),
+		})
+	}
+	if plan := status.Plan; plan != nil {
+		dst.Status.Plan = &v1beta2.InplaceUpdatePlan{
+			PlanTime:       plan.PlanTime.DeepCopy(),
+			ReplicaSet:     plan.ReplicaSet,
+			MaxUnavailable: plan.MaxUnavailable,
+			MaxSurge:       plan.MaxSurge,
+			Waves:          plan.Waves,
+			Blockers:       append([]string(nil), plan.Blockers...),
+		}
+		for _, pod := range plan.Pods {
+			podPlan := v1beta2.InplaceUpdatePodPlan{
+				Name:     pod.Name,
+				Wave:     pod.Wave,
+				Accepted: pod.Accepted,
+				Recreate: pod.Recreate,
+				Message:  pod.Message,
+			}
+			for _, change := range pod.Changes {
+				podPlan.Changes = append(podPlan.Changes, v1beta2.InplaceUpdateContainerChange{
+					Name: change.Name,
+					Type: v1beta2.ContainerType(change.Type),
+					From: change.From,
+					To:   change.To,
+				})
+			}
+			dst.Status.Plan.Pods = append(dst.Status.Plan.Pods, podPlan)
+		}
+	}
+	return nil
+}
+
+// ConvertFrom converts from the hub version (v1beta2) to this version
+func (dst *InplaceUpdate) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1beta2.InplaceUpdate)
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	dst.Spec.RollingUpdate = false
+	if value, exist := dst.Annotations[AnnotationRollingUpdateKey]; exist {
+		dst.Spec.RollingUpdate, _ = strconv.ParseBool(value)
+		delete(dst.Annotations, AnnotationRollingUpdateKey)
+		if len(dst.Annotations) == 0 {
+			dst.Annotations = nil
+		}
+	}
+
+	spec := &src.Spec
+	dst.Spec.TargetReference = nil
+	if spec.Target != nil {
+		dst.Spec.TargetReference = &TargetReference{Name: spec.Target.Name}
+		dst.Spec.TargetReference.APIVersion = spec.Target.APIVersion
+		dst.Spec.TargetReference.Kind = spec.Target.Kind
+	}
+	dst.Spec.Containers = containersFromHub(spec.Containers)
+	dst.Spec.RollbackTo = copyInt64(spec.RollbackTo)
+	dst.Spec.MaxUnavailable = copyIntOrString(spec.Strategy.MaxUnavailable)
+	dst.Spec.MaxSurge = copyIntOrString(spec.Strategy.MaxSurge)
+	dst.Spec.Ordering = orderingFromHub(spec.Strategy.Ordering)
+	dst.Spec.UpdateStrategy = UpdateStrategyType(spec.Strategy.Type)
+	dst.Spec.Atomic = spec.Strategy.Atomic
+	dst.Spec.Autoscalers = autoscalersFromHub(spec.Strategy.Autoscalers)
+	dst.Spec.Delay = copyInt32(spec.Strategy.DelaySeconds)
+	dst.Spec.ProgressDeadlineSeconds = copyInt32(spec.Strategy.ProgressDeadlineSeconds)
+	dst.Spec.Schedule = nil
+	if schedule := spec.Strategy.Schedule; schedule != nil {
+		dst.Spec.Schedule = &UpdateSchedule{
+			StartTime: schedule.StartTime.DeepCopy(),
+			Cron:      schedule.Cron,
+			TimeZone:  schedule.TimeZone,
+		}
+		for _, window := range schedule.Windows {
+			w := ScheduleWindow{StartHour: window.StartHour, EndHour: window.EndHour}
+			for _, day := range window.Days {
+				w.Days = append(w.Days, Weekday(day))
+			}
+			dst.Spec.Schedule.Windows = append(dst.Spec.Schedule.Windows, w)
+		}
+	}
+	dst.Spec.Analysis = analysisFromHub(spec.Strategy.Analysis)
+	dst.Spec.FailurePolicy = FailurePolicyType(spec.Policy.Failure)
+	dst.Spec.ReclaimPolicy = ReclaimPolicyType(spec.Policy.Reclaim)
+	dst.Spec.ConcurrencyPolicy = ConcurrencyPolicyType(spec.Policy.Concurrency)
+	dst.Spec.Supersede = spec.Policy.Supersede
+	dst.Spec.Paused = spec.Paused
+	dst.Spec.Abort = spec.Abort
+	dst.Spec.DryRun = spec.DryRun
+	dst.Spec.Reason = spec.Reason
+	dst.Spec.ChangeRef = spec.ChangeRef
+
+	status := &src.Status
+	dst.Status = InplaceUpdateStatus{
+		Replicas:               status.Replicas,
+		UpdatedReplicas:        status.UpdatedReplicas,
+		UnavailableReplicas:    status.UnavailableReplicas,
+		SurgeReplicas:          status.SurgeReplicas,
+		RecreatedReplicas:      status.RecreatedReplicas,
+		RevertedReplicas:       status.RevertedReplicas,
+		ContainerNumber:        status.ContainerNumber,
+		UpdatedContainerNumber: status.UpdatedContainerNumber,
+		ObservedGeneration:     status.ObservedGeneration,
+		StartTime:              status.StartTime.DeepCopy(),
+		CompletionTime:         status.CompletionTime.DeepCopy(),
+		QueuePosition:          status.QueuePosition,
+		BlockedBy:              status.BlockedBy,
+		NextScheduleTime:       status.NextScheduleTime.DeepCopy(),
+		Phase:                  InplaceUpdatePhase(status.Phase),
+	}
+	if rollback := status.Rollback; rollback != nil {
+		dst.Status.Rollback = &InplaceUpdateRollback{Revision: rollback.Revision, Containers: containersFromHub(rollback.Containers)}
+	}
+	for i := range status.Conditions {
+		dst.Status.Conditions = append(dst.Status.Conditions, *status.Conditions[i].DeepCopy())
+	}
+	for _, wave := range status.Waves {
+		dst.Status.Waves = append(dst.Status.Waves, InplaceUpdateWave{
+			Number:         wave.Number,
+			Pods:           append([]string(nil), wave.Pods...),
+			RecreatedPods:  append([]string(nil), wave.RecreatedPods...),
+			RevertedPods:   append([]string(nil), wave.RevertedPods...),
+			StartTime:      wave.StartTime.DeepCopy(),
+			CompletionTime: wave.CompletionTime.DeepCopy(),
+			Analysis:       waveAnalysisFromHub(wave.Analysis),
+		})
+	}
+	if plan := status.Plan; plan != nil {
+		dst.Status.Plan = &InplaceUpdatePlan{
+			PlanTime:       plan.PlanTime.DeepCopy(),
+			ReplicaSet:     plan.ReplicaSet,
+			MaxUnavailable: plan.MaxUnavailable,
+			MaxSurge:       plan.MaxSurge,
+			Waves:          plan.Waves,
+			Blockers:       append([]string(nil), plan.Blockers...),
+		}
+		for _, pod := range plan.Pods {
+			podPlan := InplaceUpdatePodPlan{
+				Name:     pod.Name,
+				Wave:     pod.Wave,
+				Accepted: pod.Accepted,
+				Recreate: pod.Recreate,
+				Message:  pod.Message,
+			}
+			for _, change := range pod.Changes {
+				podPlan.Changes = append(podPlan.Changes, InplaceUpdateContainerChange{
+					Name: change.Name,
+					Type: ContainerType(change.Type),
+					From: change.From,
+					To:   change.To,
+				})
+			}
+			dst.Status.Plan.Pods = append(dst.Status.Plan.Pods, podPlan)
+		}
+	}
+	return nil
+}
+
+func containersToHub(src []InplaceUpdateArgs) []v1beta2.ContainerUpdate {
+	if src == nil {
+		return nil
+	}
+	dst := make([]v1beta2.ContainerUpdate, 0, len(src))
+	for _, c := range src {
+		dst = append(dst, v1beta2.ContainerUpdate{
+			Name:      c.Name,
+			Image:     c.Image,
+			Type:      v1beta2.ContainerType(c.Type),
+			DependsOn: append([]string(nil), c.DependsOn...),
+		})
+	}
+	return dst
+}
+
+func containersFromHub(src []v1beta2.ContainerUpdate) []InplaceUpdateArgs {
+	if src == nil {
+		return nil
+	}
+	dst := make([]InplaceUpdateArgs, 0, len(src))
+	for _, c := range src {
+		dst = append(dst, InplaceUpdateArgs{
+			Name:      c.Name,
+			Image:     c.Image,
+			Type:      ContainerType(c.Type),
+			DependsOn: append([]string(nil), c.DependsOn...),
+		})
+	}
+	return dst
+}
+
+func orderingToHub(src *WaveOrdering) *v1beta2.WaveOrdering {
+	if src == nil {
+		return nil
+	}
+	return &v1beta2.WaveOrdering{
+		Policy:             v1beta2.WaveOrderingPolicy(src.Policy),
+		PriorityLabel:      src.PriorityLabel,
+		PriorityAnnotation: src.PriorityAnnotation,
+	}
+}
+
+func orderingFromHub(src *v1beta2.WaveOrdering) *WaveOrdering {
+	if src == nil {
+		return nil
+	}
+	return &WaveOrdering{
+		Policy:             WaveOrderingPolicy(src.Policy),
+		PriorityLabel:      src.PriorityLabel,
+		PriorityAnnotation: src.PriorityAnnotation,
+	}
+}
+
+func autoscalersToHub(src *AutoscalerCoordination) *v1beta2.AutoscalerCoordination {
+	if src == nil {
+		return nil
+	}
+	return &v1beta2.AutoscalerCoordination{
+		HorizontalPodAutoscaler: v1beta2.HorizontalPodAutoscalerPolicy(src.HorizontalPodAutoscaler),
+		VerticalPodAutoscaler:   v1beta2.VerticalPodAutoscalerPolicy(src.VerticalPodAutoscaler),
+	}
+}
+
+func autoscalersFromHub(src *v1beta2.AutoscalerCoordination) *AutoscalerCoordination {
+	if src == nil {
+		return nil
+	}
+	return &AutoscalerCoordination{
+		HorizontalPodAutoscaler: HorizontalPodAutoscalerPolicy(src.HorizontalPodAutoscaler),
+		VerticalPodAutoscaler:   VerticalPodAutoscalerPolicy(src.VerticalPodAutoscaler),
+	}
+}
+
+func analysisToHub(src *Analysis) *v1beta2.Analysis {
+	if src == nil {
+		return nil
+	}
+	dst := &v1beta2.Analysis{
+		IntervalSeconds: copyInt32(src.IntervalSeconds),
+		Count:           copyInt32(src.Count),
+		FailureLimit:    copyInt32(src.FailureLimit),
+		OnFailure:       v1beta2.AnalysisFailurePolicyType(src.OnFailure),
+	}
+	for _, metric := range src.Metrics {
+		m := v1beta2.AnalysisMetric{Name: metric.Name}
+		if metric.Prometheus != nil {
+			m.Prometheus = &v1beta2.PrometheusMetric{Address: metric.Prometheus.Address, Query: metric.Prometheus.Query}
+		}
+		if metric.Web != nil {
+			m.Web = &v1beta2.WebMetric{
+				URL:                  metric.Web.URL,
+				BearerTokenSecretRef: metric.Web.BearerTokenSecretRef.DeepCopy(),
+				TimeoutSeconds:       copyInt32(metric.Web.TimeoutSeconds),
+			}
+			if tls := metric.Web.TLS; tls != nil {
+				m.Web.TLS = &v1beta2.WebTLSConfig{CASecretRef: tls.CASecretRef.DeepCopy(), ServerName: tls.ServerName, InsecureSkipVerify: tls.InsecureSkipVerify}
+			}
+		}
+		if metric.SuccessThreshold != nil {
+			m.SuccessThreshold = &v1beta2.MetricThreshold{
+				Operator: v1beta2.ThresholdOperator(metric.SuccessThreshold.Operator),
+				Value:    metric.SuccessThreshold.Value,
+			}
+		}
+		if metric.FailureThreshold != nil {
+			m.FailureThreshold = &v1beta2.MetricThreshold{
+				Operator: v1beta2.ThresholdOperator(metric.FailureThreshold.Operator),
+				Value:    metric.FailureThreshold.Value,
+			}
+		}
+		dst.Metrics = append(dst.Metrics, m)
+	}
+	return dst
+}
+
+func analysisFromHub(src *v1beta2.Analysis) *Analysis {
+	if src == nil {
+		return nil
+	}
+	dst := &Analysis{
+		IntervalSeconds: copyInt32(src.IntervalSeconds),
+		Count:           copyInt32(src.Count),
+		FailureLimit:    copyInt32(src.FailureLimit),
+		OnFailure:       AnalysisFailurePolicyType(src.OnFailure),
+	}
+	for _, metric := range src.Metrics {
+		m := AnalysisMetric{Name: metric.Name}
+		if metric.Prometheus != nil {
+			m.Prometheus = &PrometheusMetric{Address: metric.Prometheus.Address, Query: metric.Prometheus.Query}
+		}
+		if metric.Web != nil {
+			m.Web = &WebMetric{
+				URL:                  metric.Web.URL,
+				BearerTokenSecretRef: metric.Web.BearerTokenSecretRef.DeepCopy(),
+				TimeoutSeconds:       copyInt32(metric.Web.TimeoutSeconds),
+			}
+			if tls := metric.Web.TLS; tls != nil {
+				m.Web.TLS = &WebTLSConfig{CASecretRef: tls.CASecretRef.DeepCopy(), ServerName: tls.ServerName, InsecureSkipVerify: tls.InsecureSkipVerify}
+			}
+		}
+		if metric.SuccessThreshold != nil {
+			m.SuccessThreshold = &MetricThreshold{
+				Operator: ThresholdOperator(metric.SuccessThreshold.Operator),
+				Value:    metric.SuccessThreshold.Value,
+			}
+		}
+		if metric.FailureThreshold != nil {
+			m.FailureThreshold = &MetricThreshold{
+				Operator: ThresholdOperator(metric.FailureThreshold.Operator),
+				Value:    metric.FailureThreshold.Value,
+			}
+		}
+		dst.Metrics = append(dst.Metrics, m)
+	}
+	return dst
+}
+
+func waveAnalysisToHub(src *WaveAnalysis) *v1beta2.WaveAnalysis {
+	if src == nil {
+		return nil
+	}
+	dst := &v1beta2.WaveAnalysis{
+		Phase:        v1beta2.AnalysisPhase(src.Phase),
+		Successful:   src.Successful,
+		Failed:       src.Failed,
+		Inconclusive: src.Inconclusive,
+	}
+	for _, m := range src.Measurements {
+		dst.Measurements = append(dst.Measurements, v1beta2.MetricMeasurement{
+			Metric:  m.Metric,
+			Phase:   v1beta2.MeasurementPhase(m.Phase),
+			Value:   m.Value,
+			Message: m.Message,
+			Time:    *m.Time.DeepCopy(),
+		})
+	}
+	return dst
+}
+
+func waveAnalysisFromHub(src *v1beta2.WaveAnalysis) *WaveAnalysis {
+	if src == nil {
+		return nil
+	}
+	dst := &WaveAnalysis{
+		Phase:        AnalysisPhase(src.Phase),
+		Successful:   src.Successful,
+		Failed:       src.Failed,
+		Inconclusive: src.Inconclusive,
+	}
+	for _, m := range src.Measurements {
+		dst.Measurements = append(dst.Measurements, MetricMeasurement{
+			Metric:  m.Metric,
+			Phase:   MeasurementPhase(m.Phase),
+			Value:   m.Value,
+			Message: m.Message,
+			Time:    *m.Time.DeepCopy(),
+		})
+	}
+	return dst
+}
+
+func copyInt32(v *int32) *int32 {
+	if v == nil {
+		return nil
+	}
+	out := *v
+	return &out
+}
+
+func copyInt64(v *int64) *int64 {
+	if v == nil {
+		return nil
+	}
+	out := *v
+	return &out
+}
+
+func copyIntOrString(v *intstr.IntOrString) *intstr.IntOrString {
+	if v == nil {
+		return nil
+	}
+	out := *v
+	return &out
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2024 extreme.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	fuzz "github.com/google/gofuzz"
+	"k8s.io/apimachinery/pkg/api/equality"
+
+	"github.com/Forget-C/demo/inplaceupdate/program/api/v1beta2"
+)
+
+const conversionFuzzIterations = 1000
+
+func newConversionFuzzer(t *testing.T) *fuzz.Fuzzer {
+	seed := rand.Int63()
+	t.Logf("fuzzer seed: %d", seed)
+	return fuzz.NewWithSeed(seed).NilChance(.3).NumElements(0, 3)
+}
+
+func TestInplaceUpdateConversionRoundTrip(t *testing.T) {
+	f := newConversionFuzzer(t)
+	for i := 0; i < conversionFuzzIterations; i++ {
+		original := &InplaceUpdate{}
+		f.Fuzz(original)
+		original.TypeMeta = InplaceUpdate{}.TypeMeta
+
+		hub := &v1beta2.InplaceUpdate{}
+		if err := original.DeepCopy().ConvertTo(hub); err != nil {
+			t.Fatalf("convert to hub: %v", err)
+		}
+		roundTrip := &InplaceUpdate{}
+		if err := roundTrip.ConvertFrom(hub); err != nil {
+			t.Fatalf("convert from hub: %v", err)
+		}
+		if !equality.Semantic.DeepEqual(original, roundTrip) {
+			t.Fatalf("v1 -> v1beta2 -> v1 is lossy:\n%s", cmp.Diff(original, roundTrip))
+		}
+	}
+}
+
+func TestInplaceUpdateHubConversionRoundTrip(t *testing.T) {
+	f := newConversionFuzzer(t)
+	for i := 0; i < conversionFuzzIterations; i++ {
+		original := &v1beta2.InplaceUpdate{}
+		f.Fuzz(original)
+		original.TypeMeta = v1beta2.InplaceUpdate{}.TypeMeta
+		delete(original.Annotations, AnnotationRollingUpdateKey)
+
+		spoke := &InplaceUpdate{}
+		if err := spoke.ConvertFrom(original.DeepCopy()); err != nil {
+			t.Fatalf("convert from hub: %v", err)
+		}
+		roundTrip := &v1beta2.InplaceUpdate{}
+		if err := spoke.ConvertTo(roundTrip); err != nil {
+			t.Fatalf("convert to hub: %v", err)
+		}
+		if !equality.Semantic.DeepEqual(original, roundTrip) {
+			t.Fatalf("v1beta2 -> v1 -> v1beta2 is lossy:\n%s", cmp.Diff(original, roundTrip))
+		}
+	}
+}
+
+func TestInplaceUpdateConversionRollingUpdate(t *testing.T) {
+	original := &InplaceUpdate{Spec: InplaceUpdateSpec{RollingUpdate: true}}
+	hub := &v1beta2.InplaceUpdate{}
+	if err := original.ConvertTo(hub); err != nil {
+		t.Fatal(err)
+	}
+	if hub.Annotations[AnnotationRollingUpdateKey] != "true" {
+		t.Fatalf("expected rollingUpdate to be kept in annotation %s, got %v", AnnotationRollingUpdateKey, hub.Annotations)
+	}
+	roundTrip := &InplaceUpdate{}
+	if err := roundTrip.ConvertFrom(hub); err != nil {
+		t.Fatal(err)
+	}
+	if !roundTrip.Spec.RollingUpdate || len(roundTrip.Annotations) != 0 {
+		t.Fatalf("expected rollingUpdate restored without annotations, got %v %v", roundTrip.Spec.RollingUpdate, roundTrip.Annotations)
+	}
+}
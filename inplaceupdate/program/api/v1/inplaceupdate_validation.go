@@ -0,0 +1,422 @@
+/*
+Copyright 2024 extreme.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+var supportedContainerTypes = []ContainerType{ContainerTypeContainer, ContainerTypeInitContainer}
+var supportedReclaimPolicies = []ReclaimPolicyType{ReclaimPolicyDelete, ReclaimPolicyRetain}
+var supportedFailurePolicies = []FailurePolicyType{FailurePolicyIgnore, FailurePolicyAbort}
+var supportedConcurrencyPolicies = []ConcurrencyPolicyType{ConcurrencyPolicyForbid, ConcurrencyPolicyQueue}
+var supportedWaveOrderingPolicies = []WaveOrderingPolicy{WaveOrderingSpread, WaveOrderingZoneByZone}
+var supportedUpdateStrategies = []UpdateStrategyType{UpdateStrategyInPlace, UpdateStrategyInPlaceIfPossible}
+var supportedHorizontalPodAutoscalerPolicies = []HorizontalPodAutoscalerPolicy{HorizontalPodAutoscalerHold, HorizontalPodAutoscalerNoScaleDown}
+var supportedVerticalPodAutoscalerPolicies = []VerticalPodAutoscalerPolicy{VerticalPodAutoscalerOff}
+var supportedAnalysisFailurePolicies = []AnalysisFailurePolicyType{AnalysisFailurePolicyAbort, AnalysisFailurePolicyPause, AnalysisFailurePolicyRollback}
+var supportedThresholdOperators = []ThresholdOperator{ThresholdOperatorLessThan, ThresholdOperatorLessThanOrEqual,
+	ThresholdOperatorGreaterThan, ThresholdOperatorGreaterThanOrEqual}
+
+// the reason and the change reference end up in events and metric labels, they are kept short
+const (
+	maxReasonLength    = 1024
+	maxChangeRefLength = 128
+)
+
+// Weekdays are the days of the week in the order of time.Weekday
+var Weekdays = []Weekday{Sunday, Monday, Tuesday, Wednesday, Thursday, Friday, Saturday}
+
+// ValidateInplaceUpdateSpec validates the spec on its own, without looking at the target workload
+func ValidateInplaceUpdateSpec(spec *InplaceUpdateSpec) field.ErrorList {
+	specPath := field.NewPath("spec")
+	allErrs := validateTargetReference(spec.TargetReference, specPath.Child("targetRef"))
+	if spec.RollbackTo == nil {
+		allErrs = append(allErrs, validateContainers(spec.Containers, specPath.Child("containers"))...)
+	} else {
+		if *spec.RollbackTo < 0 {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("rollbackTo"), *spec.RollbackTo, "must be greater than or equal to 0"))
+		}
+		if len(spec.Containers) != 0 {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("containers"), "must be empty when spec.rollbackTo is set, the containers are taken from the revision"))
+		}
+	}
+	// pods can be restarted without any unavailable when surge pods make up for them
+	if spec.MaxUnavailable != nil && (spec.MaxSurge == nil || !isZeroIntOrPercent(spec.MaxUnavailable)) {
+		allErrs = append(allErrs, validatePositiveIntOrPercent(spec.MaxUnavailable, specPath.Child("maxUnavailable"))...)
+	}
+	if spec.MaxSurge != nil {
+		allErrs = append(allErrs, validatePositiveIntOrPercent(spec.MaxSurge, specPath.Child("maxSurge"))...)
+	}
+	if spec.Delay != nil && *spec.Delay < 0 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("delay"), *spec.Delay, "must be greater than or equal to 0"))
+	}
+	if spec.ProgressDeadlineSeconds != nil && *spec.ProgressDeadlineSeconds <= 0 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("progressDeadlineSeconds"), *spec.ProgressDeadlineSeconds, "must be greater than 0"))
+	}
+	if spec.ReclaimPolicy != "" && !contains(supportedReclaimPolicies, spec.ReclaimPolicy) {
+		allErrs = append(allErrs, field.NotSupported(specPath.Child("reclaimPolicy"), spec.ReclaimPolicy, supportedReclaimPolicies))
+	}
+	if spec.FailurePolicy != "" && !contains(supportedFailurePolicies, spec.FailurePolicy) {
+		allErrs = append(allErrs, field.NotSupported(specPath.Child("failurePolicy"), spec.FailurePolicy, supportedFailurePolicies))
+	}
+	if spec.ConcurrencyPolicy != "" && !contains(supportedConcurrencyPolicies, spec.ConcurrencyPolicy) {
+		allErrs = append(allErrs, field.NotSupported(specPath.Child("concurrencyPolicy"), spec.ConcurrencyPolicy, supportedConcurrencyPolicies))
+	}
+	if spec.UpdateStrategy != "" && !contains(supportedUpdateStrategies, spec.UpdateStrategy) {
+		allErrs = append(allErrs, field.NotSupported(specPath.Child("updateStrategy"), spec.UpdateStrategy, supportedUpdateStrategies))
+	}
+	if autoscalers := spec.Autoscalers; autoscalers != nil {
+		fldPath := specPath.Child("autoscalers")
+		if autoscalers.HorizontalPodAutoscaler != "" && !contains(supportedHorizontalPodAutoscalerPolicies, autoscalers.HorizontalPodAutoscaler) {
+			allErrs = append(allErrs, field.NotSupported(fldPath.Child("horizontalPodAutoscaler"), autoscalers.HorizontalPodAutoscaler, supportedHorizontalPodAutoscalerPolicies))
+		}
+		if autoscalers.VerticalPodAutoscaler != "" && !contains(supportedVerticalPodAutoscalerPolicies, autoscalers.VerticalPodAutoscaler) {
+			allErrs = append(allErrs, field.NotSupported(fldPath.Child("verticalPodAutoscaler"), autoscalers.VerticalPodAutoscaler, supportedVerticalPodAutoscalerPolicies))
+		}
+	}
+	if spec.Ordering != nil {
+		allErrs = append(allErrs, validateOrdering(spec.Ordering, specPath.Child("ordering"))...)
+	}
+	if spec.Schedule != nil {
+		allErrs = append(allErrs, validateSchedule(spec.Schedule, specPath.Child("schedule"))...)
+	}
+	if spec.Analysis != nil {
+		allErrs = append(allErrs, validateAnalysis(spec.Analysis, specPath.Child("analysis"))...)
+	}
+	if len(spec.Reason) > maxReasonLength {
+		allErrs = append(allErrs, field.TooLong(specPath.Child("reason"), spec.Reason, maxReasonLength))
+	}
+	if len(spec.ChangeRef) > maxChangeRefLength {
+		allErrs = append(allErrs, field.TooLong(specPath.Child("changeRef"), spec.ChangeRef, maxChangeRefLength))
+	}
+	return allErrs
+}
+
+func validateAnalysis(analysis *Analysis, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if len(analysis.Metrics) == 0 {
+		allErrs = append(allErrs, field.Required(fldPath.Child("metrics"), "at least one metric is required"))
+	}
+	names := sets.New[string]()
+	for i, metric := range analysis.Metrics {
+		idxPath := fldPath.Child("metrics").Index(i)
+		if metric.Name == "" {
+			allErrs = append(allErrs, field.Required(idxPath.Child("name"), ""))
+		} else if names.Has(metric.Name) {
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("name"), metric.Name))
+		}
+		names.Insert(metric.Name)
+		switch {
+		case metric.Prometheus != nil && metric.Web != nil:
+			allErrs = append(allErrs, field.Forbidden(idxPath.Child("web"), "only one metric provider may be set"))
+		case metric.Prometheus != nil:
+			allErrs = append(allErrs, validatePrometheusMetric(metric.Prometheus, idxPath.Child("prometheus"))...)
+			if metric.SuccessThreshold == nil && metric.FailureThreshold == nil {
+				allErrs = append(allErrs, field.Required(idxPath.Child("successThreshold"), "a success or a failure threshold is required"))
+			}
+		case metric.Web != nil:
+			allErrs = append(allErrs, validateWebMetric(metric.Web, idxPath.Child("web"))...)
+			if metric.SuccessThreshold != nil {
+				allErrs = append(allErrs, field.Forbidden(idxPath.Child("successThreshold"), "a web metric passes or fails on the answer of the service"))
+			}
+			if metric.FailureThreshold != nil {
+				allErrs = append(allErrs, field.Forbidden(idxPath.Child("failureThreshold"), "a web metric passes or fails on the answer of the service"))
+			}
+		default:
+			allErrs = append(allErrs, field.Required(idxPath.Child("prometheus"), "a metric provider, prometheus or web, is required"))
+		}
+		if metric.SuccessThreshold != nil {
+			allErrs = append(allErrs, validateThreshold(metric.SuccessThreshold, idxPath.Child("successThreshold"))...)
+		}
+		if metric.FailureThreshold != nil {
+			allErrs = append(allErrs, validateThreshold(metric.FailureThreshold, idxPath.Child("failureThreshold"))...)
+		}
+	}
+	if analysis.IntervalSeconds != nil && *analysis.IntervalSeconds <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("intervalSeconds"), *analysis.IntervalSeconds, "must be greater than 0"))
+	}
+	if analysis.Count != nil && *analysis.Count <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("count"), *analysis.Count, "must be greater than 0"))
+	}
+	if analysis.FailureLimit != nil && *analysis.FailureLimit < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("failureLimit"), *analysis.FailureLimit, "must be greater than or equal to 0"))
+	}
+	if analysis.OnFailure != "" && !contains(supportedAnalysisFailurePolicies, analysis.OnFailure) {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("onFailure"), analysis.OnFailure, supportedAnalysisFailurePolicies))
+	}
+	return allErrs
+}
+
+func validatePrometheusMetric(metric *PrometheusMetric, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if u, err := url.Parse(metric.Address); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("address"), metric.Address, "must be an http or https URL"))
+	}
+	if metric.Query == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("query"), ""))
+	} else if _, err := template.New("query").Parse(metric.Query); err != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("query"), metric.Query, err.Error()))
+	}
+	return allErrs
+}
+
+func validateWebMetric(metric *WebMetric, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if u, err := url.Parse(metric.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("url"), metric.URL, "must be an http or https URL"))
+	}
+	if metric.BearerTokenSecretRef != nil {
+		allErrs = append(allErrs, validateSecretKeySelector(metric.BearerTokenSecretRef, fldPath.Child("bearerTokenSecretRef"))...)
+	}
+	if metric.TLS != nil && metric.TLS.CASecretRef != nil {
+		allErrs = append(allErrs, validateSecretKeySelector(metric.TLS.CASecretRef, fldPath.Child("tls", "caSecretRef"))...)
+	}
+	if metric.TimeoutSeconds != nil && *metric.TimeoutSeconds <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("timeoutSeconds"), *metric.TimeoutSeconds, "must be greater than 0"))
+	}
+	return allErrs
+}
+
+func validateSecretKeySelector(selector *corev1.SecretKeySelector, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if selector.Name == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("name"), ""))
+	}
+	if selector.Key == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("key"), ""))
+	}
+	return allErrs
+}
+
+func validateThreshold(threshold *MetricThreshold, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if !contains(supportedThresholdOperators, threshold.Operator) {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("operator"), threshold.Operator, supportedThresholdOperators))
+	}
+	if _, err := strconv.ParseFloat(threshold.Value, 64); err != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("value"), threshold.Value, "must be a decimal number"))
+	}
+	return allErrs
+}
+
+func validateOrdering(ordering *WaveOrdering, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if ordering.Policy != "" && !contains(supportedWaveOrderingPolicies, ordering.Policy) {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("policy"), ordering.Policy, supportedWaveOrderingPolicies))
+	}
+	if ordering.PriorityLabel != "" && ordering.PriorityAnnotation != "" {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("priorityAnnotation"), "may not be set together with priorityLabel"))
+	}
+	for _, key := range []struct {
+		name  string
+		value string
+	}{{"priorityLabel", ordering.PriorityLabel}, {"priorityAnnotation", ordering.PriorityAnnotation}} {
+		if key.value == "" {
+			continue
+		}
+		if msgs := validation.IsQualifiedName(key.value); len(msgs) != 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child(key.name), key.value, strings.Join(msgs, ", ")))
+		}
+	}
+	return allErrs
+}
+
+func validateSchedule(schedule *UpdateSchedule, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if schedule.Cron != "" {
+		if strings.Contains(schedule.Cron, "TZ=") {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("cron"), schedule.Cron, "set the time zone in timeZone instead"))
+		} else if _, err := cron.ParseStandard(schedule.Cron); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("cron"), schedule.Cron, err.Error()))
+		}
+	}
+	if schedule.TimeZone != "" {
+		if _, err := time.LoadLocation(schedule.TimeZone); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("timeZone"), schedule.TimeZone, err.Error()))
+		}
+	}
+	for i, window := range schedule.Windows {
+		idxPath := fldPath.Child("windows").Index(i)
+		if window.StartHour < 0 || window.StartHour > 23 {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("startHour"), window.StartHour, "must be between 0 and 23"))
+		}
+		if window.EndHour < 1 || window.EndHour > 24 {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("endHour"), window.EndHour, "must be between 1 and 24"))
+		}
+		days := sets.New[Weekday]()
+		for j, day := range window.Days {
+			if !contains(Weekdays, day) {
+				allErrs = append(allErrs, field.NotSupported(idxPath.Child("days").Index(j), day, Weekdays))
+			} else if days.Has(day) {
+				allErrs = append(allErrs, field.Duplicate(idxPath.Child("days").Index(j), day))
+			}
+			days.Insert(day)
+		}
+	}
+	return allErrs
+}
+
+func validateTargetReference(target *TargetReference, fldPath *field.Path) field.ErrorList {
+	if target == nil {
+		return field.ErrorList{field.Required(fldPath, "")}
+	}
+	var allErrs field.ErrorList
+	if target.Name == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("name"), ""))
+	}
+	if target.APIVersion != TargetAPIVersionDeployment {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("apiVersion"), target.APIVersion, []string{TargetAPIVersionDeployment}))
+	}
+	if target.Kind != TargetKindDeployment {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("kind"), target.Kind, []string{TargetKindDeployment}))
+	}
+	return allErrs
+}
+
+func validateContainers(containers []InplaceUpdateArgs, fldPath *field.Path) field.ErrorList {
+	if len(containers) == 0 {
+		return field.ErrorList{field.Required(fldPath, "at least one container is required")}
+	}
+	var allErrs field.ErrorList
+	names := sets.New[string]()
+	for i, c := range containers {
+		idxPath := fldPath.Index(i)
+		if c.Name == "" {
+			allErrs = append(allErrs, field.Required(idxPath.Child("name"), ""))
+		} else if names.Has(c.Name) {
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("name"), c.Name))
+		}
+		names.Insert(c.Name)
+		if c.Image == "" {
+			allErrs = append(allErrs, field.Required(idxPath.Child("image"), ""))
+		} else if _, err := reference.ParseNormalizedNamed(c.Image); err != nil {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("image"), c.Image, err.Error()))
+		}
+		switch {
+		case c.Type == "" || contains(supportedContainerTypes, c.Type):
+		case c.Type == ContainerTypeEphemeralContainer:
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("type"), c.Type, "ephemeral containers can not be updated in place"))
+		default:
+			allErrs = append(allErrs, field.NotSupported(idxPath.Child("type"), c.Type, supportedContainerTypes))
+		}
+	}
+	for i, c := range containers {
+		for j, dependency := range c.DependsOn {
+			depPath := fldPath.Index(i).Child("dependsOn").Index(j)
+			if dependency == c.Name {
+				allErrs = append(allErrs, field.Invalid(depPath, dependency, "a container can not depend on itself"))
+			} else if !names.Has(dependency) {
+				allErrs = append(allErrs, field.NotFound(depPath, dependency))
+			}
+		}
+	}
+	if cycle := dependencyCycle(containers); len(cycle) != 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath, strings.Join(cycle, " -> "), "dependsOn must not form a cycle"))
+	}
+	return allErrs
+}
+
+// dependencyCycle returns the names of the containers forming a cycle of dependsOn, if any
+func dependencyCycle(containers []InplaceUpdateArgs) []string {
+	dependencies := make(map[string][]string, len(containers))
+	for _, c := range containers {
+		dependencies[c.Name] = c.DependsOn
+	}
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int, len(containers))
+	var path []string
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		switch state[name] {
+		case visiting:
+			for i := range path {
+				if path[i] == name {
+					return append(append([]string(nil), path[i:]...), name)
+				}
+			}
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, dependency := range dependencies[name] {
+			if dependency == name {
+				continue
+			}
+			if cycle := visit(dependency); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+	for _, c := range containers {
+		if cycle := visit(c.Name); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+func validatePositiveIntOrPercent(v *intstr.IntOrString, fldPath *field.Path) field.ErrorList {
+	switch v.Type {
+	case intstr.Int:
+		if v.IntVal <= 0 {
+			return field.ErrorList{field.Invalid(fldPath, v.IntVal, "must be greater than 0")}
+		}
+	case intstr.String:
+		if msgs := validation.IsValidPercent(v.StrVal); len(msgs) != 0 {
+			return field.ErrorList{field.Invalid(fldPath, v.StrVal, fmt.Sprintf("must be an integer or a percentage, %s", msgs[0]))}
+		}
+		percent, _ := intstr.GetScaledValueFromIntOrPercent(v, 100, false)
+		if percent <= 0 || percent > 100 {
+			return field.ErrorList{field.Invalid(fldPath, v.StrVal, "must be greater than 0% and not more than 100%")}
+		}
+	}
+	return nil
+}
+
+func isZeroIntOrPercent(v *intstr.IntOrString) bool {
+	return (v.Type == intstr.Int && v.IntVal == 0) || (v.Type == intstr.String && v.StrVal == "0%")
+}
+
+func contains[T comparable](values []T, value T) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
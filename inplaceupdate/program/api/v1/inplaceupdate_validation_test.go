@@ -0,0 +1,531 @@
+/*
+Copyright 2024 extreme.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func validInplaceUpdate() *InplaceUpdate {
+	return &InplaceUpdate{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "nginx-update"},
+		Spec: InplaceUpdateSpec{
+			TargetReference: &TargetReference{
+				TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+				Name:     "nginx",
+			},
+			Containers: []InplaceUpdateArgs{
+				{Name: "nginx", Image: "nginx:1.25.4", Type: ContainerTypeContainer},
+				{Name: "envoy", Image: "docker.io/envoyproxy/envoy@sha256:" + strings.Repeat("a", 64), Type: ContainerTypeInitContainer},
+			},
+			ReclaimPolicy: ReclaimPolicyRetain,
+			FailurePolicy: FailurePolicyIgnore,
+		},
+	}
+}
+
+func validAnalysis() *Analysis {
+	return &Analysis{Metrics: []AnalysisMetric{
+		{
+			Name: "error-rate",
+			Prometheus: &PrometheusMetric{
+				Address: "http://prometheus.monitoring:9090",
+				Query:   `sum(rate(http_requests_total{namespace="{{ .Namespace }}",pod=~"{{ .Pods }}",code=~"5.."}[5m])) / sum(rate(http_requests_total{namespace="{{ .Namespace }}",pod=~"{{ .Pods }}"}[5m]))`,
+			},
+			SuccessThreshold: &MetricThreshold{Operator: ThresholdOperatorLessThan, Value: "0.01"},
+			FailureThreshold: &MetricThreshold{Operator: ThresholdOperatorGreaterThanOrEqual, Value: "0.05"},
+		},
+		{
+			Name: "p99-latency",
+			Prometheus: &PrometheusMetric{
+				Address: "https://thanos.monitoring",
+				Query:   `histogram_quantile(0.99, sum by (le) (rate(http_request_duration_seconds_bucket{pod=~"{{ .Pods }}"}[5m])))`,
+			},
+			FailureThreshold: &MetricThreshold{Operator: ThresholdOperatorGreaterThan, Value: "0.5"},
+		},
+	}}
+}
+
+func validWebMetric() AnalysisMetric {
+	return AnalysisMetric{
+		Name: "smoke-tests",
+		Web: &WebMetric{
+			URL: "https://smoke-tests.qa.svc/verify",
+			BearerTokenSecretRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "smoke-tests"},
+				Key:                  "token",
+			},
+			TLS: &WebTLSConfig{CASecretRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "smoke-tests"},
+				Key:                  "ca.crt",
+			}},
+		},
+	}
+}
+
+func TestDefault(t *testing.T) {
+	r := validInplaceUpdate()
+	r.Spec.TargetReference.TypeMeta = metav1.TypeMeta{}
+	r.Spec.Containers[1].Type = ""
+	r.Spec.ReclaimPolicy = ""
+	r.Spec.FailurePolicy = ""
+	r.Spec.Analysis = validAnalysis()
+	r.Spec.Analysis.Metrics = append(r.Spec.Analysis.Metrics, validWebMetric())
+	r.Annotations = map[string]string{AnnotationInitiator: "forged"}
+	ctx := admission.NewContextWithRequest(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		UserInfo:  authenticationv1.UserInfo{Username: "alice", Groups: []string{"sre", "system:authenticated"}},
+	}})
+	if err := (&InplaceUpdateDefaulter{}).Default(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Annotations[AnnotationInitiator] != "alice" || r.Annotations[AnnotationInitiatorGroups] != "sre,system:authenticated" {
+		t.Errorf("expected the requesting user to be stamped, got %v", r.Annotations)
+	}
+	if r.Spec.ConcurrencyPolicy != ConcurrencyPolicyForbid {
+		t.Errorf("expected concurrency policy to default to %s, got %s", ConcurrencyPolicyForbid, r.Spec.ConcurrencyPolicy)
+	}
+	if r.Spec.TargetReference.APIVersion != "apps/v1" || r.Spec.TargetReference.Kind != "Deployment" {
+		t.Errorf("expected target to default to apps/v1 Deployment, got %v", r.Spec.TargetReference.TypeMeta)
+	}
+	if r.Spec.Containers[1].Type != ContainerTypeContainer {
+		t.Errorf("expected container type to default to %s, got %s", ContainerTypeContainer, r.Spec.Containers[1].Type)
+	}
+	if r.Spec.ReclaimPolicy != ReclaimPolicyRetain || r.Spec.FailurePolicy != FailurePolicyIgnore {
+		t.Errorf("expected policies to default to Retain and Ignore, got %s and %s", r.Spec.ReclaimPolicy, r.Spec.FailurePolicy)
+	}
+	expected := validAnalysis()
+	expected.IntervalSeconds = ptr.To[int32](DefaultAnalysisIntervalSeconds)
+	expected.Count = ptr.To[int32](1)
+	expected.FailureLimit = ptr.To[int32](0)
+	expected.OnFailure = AnalysisFailurePolicyRollback
+	expected.Metrics = append(expected.Metrics, validWebMetric())
+	expected.Metrics[2].Web.TimeoutSeconds = ptr.To[int32](DefaultWebMetricTimeoutSeconds)
+	if !equality.Semantic.DeepEqual(r.Spec.Analysis, expected) {
+		t.Errorf("expected analysis to default to %+v, got %+v", expected, r.Spec.Analysis)
+	}
+}
+
+func TestValidateInplaceUpdateSpec(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(r *InplaceUpdate)
+		// errs is the fields expected to be reported, empty for a valid spec
+		errs []string
+	}{
+		{name: "valid", mutate: func(r *InplaceUpdate) {}},
+		{name: "valid percent maxUnavailable", mutate: func(r *InplaceUpdate) {
+			r.Spec.MaxUnavailable = ptr.To(intstr.FromString("100%"))
+		}},
+		{name: "valid int maxUnavailable and delay", mutate: func(r *InplaceUpdate) {
+			r.Spec.MaxUnavailable = ptr.To(intstr.FromInt32(3))
+			r.Spec.Delay = ptr.To[int32](0)
+		}},
+		{name: "missing target", mutate: func(r *InplaceUpdate) {
+			r.Spec.TargetReference = nil
+		}, errs: []string{"spec.targetRef"}},
+		{name: "missing target name", mutate: func(r *InplaceUpdate) {
+			r.Spec.TargetReference.Name = ""
+		}, errs: []string{"spec.targetRef.name"}},
+		{name: "target with core apiVersion", mutate: func(r *InplaceUpdate) {
+			r.Spec.TargetReference.APIVersion = "v1"
+		}, errs: []string{"spec.targetRef.apiVersion"}},
+		{name: "target of another kind", mutate: func(r *InplaceUpdate) {
+			r.Spec.TargetReference.Kind = "StatefulSet"
+		}, errs: []string{"spec.targetRef.kind"}},
+		{name: "no containers", mutate: func(r *InplaceUpdate) {
+			r.Spec.Containers = nil
+		}, errs: []string{"spec.containers"}},
+		{name: "duplicate container names", mutate: func(r *InplaceUpdate) {
+			r.Spec.Containers[1].Name = "nginx"
+		}, errs: []string{"spec.containers[1].name"}},
+		{name: "missing container name and image", mutate: func(r *InplaceUpdate) {
+			r.Spec.Containers[0] = InplaceUpdateArgs{}
+		}, errs: []string{"spec.containers[0].name", "spec.containers[0].image"}},
+		{name: "invalid image", mutate: func(r *InplaceUpdate) {
+			r.Spec.Containers[0].Image = "Nginx:latest"
+		}, errs: []string{"spec.containers[0].image"}},
+		{name: "invalid image tag", mutate: func(r *InplaceUpdate) {
+			r.Spec.Containers[0].Image = "nginx:1.25:4"
+		}, errs: []string{"spec.containers[0].image"}},
+		{name: "ephemeral container", mutate: func(r *InplaceUpdate) {
+			r.Spec.Containers[0].Type = ContainerTypeEphemeralContainer
+		}, errs: []string{"spec.containers[0].type"}},
+		{name: "unknown container type", mutate: func(r *InplaceUpdate) {
+			r.Spec.Containers[0].Type = "Sidecar"
+		}, errs: []string{"spec.containers[0].type"}},
+		{name: "zero maxUnavailable", mutate: func(r *InplaceUpdate) {
+			r.Spec.MaxUnavailable = ptr.To(intstr.FromInt32(0))
+		}, errs: []string{"spec.maxUnavailable"}},
+		{name: "maxUnavailable not a percent", mutate: func(r *InplaceUpdate) {
+			r.Spec.MaxUnavailable = ptr.To(intstr.FromString("half"))
+		}, errs: []string{"spec.maxUnavailable"}},
+		{name: "maxUnavailable over 100%", mutate: func(r *InplaceUpdate) {
+			r.Spec.MaxUnavailable = ptr.To(intstr.FromString("120%"))
+		}, errs: []string{"spec.maxUnavailable"}},
+		{name: "zero maxUnavailable with maxSurge", mutate: func(r *InplaceUpdate) {
+			r.Spec.MaxUnavailable = ptr.To(intstr.FromString("0%"))
+			r.Spec.MaxSurge = ptr.To(intstr.FromInt32(1))
+		}},
+		{name: "zero maxSurge", mutate: func(r *InplaceUpdate) {
+			r.Spec.MaxSurge = ptr.To(intstr.FromInt32(0))
+		}, errs: []string{"spec.maxSurge"}},
+		{name: "valid ordering", mutate: func(r *InplaceUpdate) {
+			r.Spec.Ordering = &WaveOrdering{Policy: WaveOrderingZoneByZone, PriorityLabel: "example.com/update-priority"}
+		}},
+		{name: "unknown ordering policy", mutate: func(r *InplaceUpdate) {
+			r.Spec.Ordering = &WaveOrdering{Policy: "Random"}
+		}, errs: []string{"spec.ordering.policy"}},
+		{name: "priority label and annotation", mutate: func(r *InplaceUpdate) {
+			r.Spec.Ordering = &WaveOrdering{PriorityLabel: "priority", PriorityAnnotation: "priority"}
+		}, errs: []string{"spec.ordering.priorityAnnotation"}},
+		{name: "invalid priority label", mutate: func(r *InplaceUpdate) {
+			r.Spec.Ordering = &WaveOrdering{PriorityLabel: "not a key"}
+		}, errs: []string{"spec.ordering.priorityLabel"}},
+		{name: "recreate if not possible in place", mutate: func(r *InplaceUpdate) {
+			r.Spec.UpdateStrategy = UpdateStrategyInPlaceIfPossible
+		}},
+		{name: "unknown update strategy", mutate: func(r *InplaceUpdate) {
+			r.Spec.UpdateStrategy = "Recreate"
+		}, errs: []string{"spec.updateStrategy"}},
+		{name: "sidecar before the main container", mutate: func(r *InplaceUpdate) {
+			r.Spec.Containers = []InplaceUpdateArgs{{Name: "envoy", Image: "envoy:v2"}, {Name: "nginx", Image: "nginx:1.25", DependsOn: []string{"envoy"}}}
+			r.Spec.Atomic = true
+		}},
+		{name: "unknown dependency", mutate: func(r *InplaceUpdate) {
+			r.Spec.Containers = []InplaceUpdateArgs{{Name: "nginx", Image: "nginx:1.25", DependsOn: []string{"envoy", "nginx"}}}
+		}, errs: []string{"spec.containers[0].dependsOn[0]", "spec.containers[0].dependsOn[1]"}},
+		{name: "dependency cycle", mutate: func(r *InplaceUpdate) {
+			r.Spec.Containers = []InplaceUpdateArgs{
+				{Name: "envoy", Image: "envoy:v2", DependsOn: []string{"nginx"}},
+				{Name: "nginx", Image: "nginx:1.25", DependsOn: []string{"envoy"}},
+			}
+		}, errs: []string{"spec.containers"}},
+		{name: "hold autoscalers", mutate: func(r *InplaceUpdate) {
+			r.Spec.Autoscalers = &AutoscalerCoordination{HorizontalPodAutoscaler: HorizontalPodAutoscalerHold, VerticalPodAutoscaler: VerticalPodAutoscalerOff}
+		}},
+		{name: "unknown autoscaler policies", mutate: func(r *InplaceUpdate) {
+			r.Spec.Autoscalers = &AutoscalerCoordination{HorizontalPodAutoscaler: "Freeze", VerticalPodAutoscaler: "Initial"}
+		}, errs: []string{"spec.autoscalers.horizontalPodAutoscaler", "spec.autoscalers.verticalPodAutoscaler"}},
+		{name: "negative delay", mutate: func(r *InplaceUpdate) {
+			r.Spec.Delay = ptr.To[int32](-1)
+		}, errs: []string{"spec.delay"}},
+		{name: "zero progress deadline", mutate: func(r *InplaceUpdate) {
+			r.Spec.ProgressDeadlineSeconds = ptr.To[int32](0)
+		}, errs: []string{"spec.progressDeadlineSeconds"}},
+		{name: "unknown reclaim policy", mutate: func(r *InplaceUpdate) {
+			r.Spec.ReclaimPolicy = "Keep"
+		}, errs: []string{"spec.reclaimPolicy"}},
+		{name: "unknown failure policy", mutate: func(r *InplaceUpdate) {
+			r.Spec.FailurePolicy = "Retry"
+		}, errs: []string{"spec.failurePolicy"}},
+		{name: "unknown concurrency policy", mutate: func(r *InplaceUpdate) {
+			r.Spec.ConcurrencyPolicy = "Replace"
+		}, errs: []string{"spec.concurrencyPolicy"}},
+		{name: "valid schedule", mutate: func(r *InplaceUpdate) {
+			r.Spec.Schedule = &UpdateSchedule{Cron: "0 2 * * 1-5", TimeZone: "Asia/Shanghai", Windows: []ScheduleWindow{
+				{Days: []Weekday{Saturday, Sunday}, StartHour: 22, EndHour: 4},
+				{StartHour: 0, EndHour: 24},
+			}}
+		}},
+		{name: "invalid cron", mutate: func(r *InplaceUpdate) {
+			r.Spec.Schedule = &UpdateSchedule{Cron: "0 2 * *"}
+		}, errs: []string{"spec.schedule.cron"}},
+		{name: "time zone in cron", mutate: func(r *InplaceUpdate) {
+			r.Spec.Schedule = &UpdateSchedule{Cron: "CRON_TZ=Asia/Shanghai 0 2 * * *"}
+		}, errs: []string{"spec.schedule.cron"}},
+		{name: "unknown time zone", mutate: func(r *InplaceUpdate) {
+			r.Spec.Schedule = &UpdateSchedule{TimeZone: "Mars/Olympus"}
+		}, errs: []string{"spec.schedule.timeZone"}},
+		{name: "window hours out of range", mutate: func(r *InplaceUpdate) {
+			r.Spec.Schedule = &UpdateSchedule{Windows: []ScheduleWindow{{StartHour: 24, EndHour: 0}}}
+		}, errs: []string{"spec.schedule.windows[0].startHour", "spec.schedule.windows[0].endHour"}},
+		{name: "unknown and duplicate days", mutate: func(r *InplaceUpdate) {
+			r.Spec.Schedule = &UpdateSchedule{Windows: []ScheduleWindow{{Days: []Weekday{"Monday", Friday, Friday}, StartHour: 1, EndHour: 5}}}
+		}, errs: []string{"spec.schedule.windows[0].days[0]", "spec.schedule.windows[0].days[2]"}},
+		{name: "valid analysis", mutate: func(r *InplaceUpdate) {
+			r.Spec.Analysis = validAnalysis()
+		}},
+		{name: "analysis without metrics", mutate: func(r *InplaceUpdate) {
+			r.Spec.Analysis = &Analysis{}
+		}, errs: []string{"spec.analysis.metrics"}},
+		{name: "analysis metric without provider", mutate: func(r *InplaceUpdate) {
+			r.Spec.Analysis = &Analysis{Metrics: []AnalysisMetric{{Name: "error-rate"}}}
+		}, errs: []string{"spec.analysis.metrics[0].prometheus"}},
+		{name: "prometheus metric without thresholds", mutate: func(r *InplaceUpdate) {
+			r.Spec.Analysis = validAnalysis()
+			r.Spec.Analysis.Metrics[1].FailureThreshold = nil
+		}, errs: []string{"spec.analysis.metrics[1].successThreshold"}},
+		{name: "valid web metric", mutate: func(r *InplaceUpdate) {
+			r.Spec.Analysis = &Analysis{Metrics: []AnalysisMetric{validWebMetric()}, OnFailure: AnalysisFailurePolicyPause}
+		}},
+		{name: "invalid web metric", mutate: func(r *InplaceUpdate) {
+			metric := validWebMetric()
+			metric.Web.URL = "smoke-tests/verify"
+			metric.Web.BearerTokenSecretRef.Key = ""
+			metric.Web.TLS.CASecretRef.Name = ""
+			metric.Web.TimeoutSeconds = ptr.To[int32](0)
+			metric.FailureThreshold = &MetricThreshold{Operator: ThresholdOperatorGreaterThan, Value: "0"}
+			r.Spec.Analysis = &Analysis{Metrics: []AnalysisMetric{metric}}
+		}, errs: []string{"spec.analysis.metrics[0].web.url", "spec.analysis.metrics[0].web.bearerTokenSecretRef.key",
+			"spec.analysis.metrics[0].web.tls.caSecretRef.name", "spec.analysis.metrics[0].web.timeoutSeconds",
+			"spec.analysis.metrics[0].failureThreshold"}},
+		{name: "metric with two providers", mutate: func(r *InplaceUpdate) {
+			r.Spec.Analysis = validAnalysis()
+			r.Spec.Analysis.Metrics[0].Web = validWebMetric().Web
+		}, errs: []string{"spec.analysis.metrics[0].web"}},
+		{name: "invalid analysis metrics", mutate: func(r *InplaceUpdate) {
+			r.Spec.Analysis = validAnalysis()
+			r.Spec.Analysis.Metrics[1].Name = "error-rate"
+			r.Spec.Analysis.Metrics[1].Prometheus = &PrometheusMetric{Address: "prometheus:9090", Query: "{{ .Pods "}
+			r.Spec.Analysis.Metrics[1].SuccessThreshold = &MetricThreshold{Operator: "Below", Value: "0.5s"}
+		}, errs: []string{"spec.analysis.metrics[1].name", "spec.analysis.metrics[1].prometheus.address",
+			"spec.analysis.metrics[1].prometheus.query", "spec.analysis.metrics[1].successThreshold.operator",
+			"spec.analysis.metrics[1].successThreshold.value"}},
+		{name: "valid rollback", mutate: func(r *InplaceUpdate) {
+			r.Spec.Containers = nil
+			r.Spec.RollbackTo = ptr.To[int64](0)
+		}},
+		{name: "rollback with containers", mutate: func(r *InplaceUpdate) {
+			r.Spec.RollbackTo = ptr.To[int64](-1)
+		}, errs: []string{"spec.rollbackTo", "spec.containers"}},
+		{name: "valid reason and change", mutate: func(r *InplaceUpdate) {
+			r.Spec.Reason, r.Spec.ChangeRef = "fix CVE-2024-1234", "CHG-1234"
+		}},
+		{name: "too long reason and change", mutate: func(r *InplaceUpdate) {
+			r.Spec.Reason, r.Spec.ChangeRef = strings.Repeat("x", 1025), strings.Repeat("x", 129)
+		}, errs: []string{"spec.reason", "spec.changeRef"}},
+		{name: "invalid analysis rounds", mutate: func(r *InplaceUpdate) {
+			r.Spec.Analysis = validAnalysis()
+			r.Spec.Analysis.IntervalSeconds = ptr.To[int32](0)
+			r.Spec.Analysis.Count = ptr.To[int32](0)
+			r.Spec.Analysis.FailureLimit = ptr.To[int32](-1)
+			r.Spec.Analysis.OnFailure = "Retry"
+		}, errs: []string{"spec.analysis.intervalSeconds", "spec.analysis.count", "spec.analysis.failureLimit", "spec.analysis.onFailure"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := validInplaceUpdate()
+			tt.mutate(r)
+			errs := ValidateInplaceUpdateSpec(&r.Spec)
+			var got []string
+			for _, err := range errs {
+				got = append(got, err.Field)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.errs, ",") {
+				t.Errorf("expected errors on %v, got %v", tt.errs, errs)
+			}
+		})
+	}
+}
+
+func TestValidateCreate(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "nginx"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					InitContainers: []corev1.Container{{Name: "envoy", Image: "envoyproxy/envoy:v1.29.0"}},
+					Containers:     []corev1.Container{{Name: "nginx", Image: "nginx:1.25.3"}},
+				},
+			},
+		},
+	}
+	otherUpdate := func(name, target string, phase InplaceUpdatePhase) *InplaceUpdate {
+		other := validInplaceUpdate()
+		other.Name = name
+		other.Spec.TargetReference.Name = target
+		other.Status.Phase = phase
+		return other
+	}
+	dryRunUpdate := otherUpdate("plan", "nginx", InplaceUpdatePhasePlanned)
+	dryRunUpdate.Spec.DryRun = true
+	revision := &InplaceUpdateRevision{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "nginx-2", Labels: map[string]string{LabelTarget: "nginx"}},
+		Spec:       InplaceUpdateRevisionSpec{Revision: 2},
+	}
+	tests := []struct {
+		name     string
+		objects  []client.Object
+		mutate   func(r *InplaceUpdate)
+		wantErr  bool
+		warnings []string
+	}{
+		{name: "target matches", objects: []client.Object{deployment}, mutate: func(r *InplaceUpdate) {}},
+		{name: "target not found", mutate: func(r *InplaceUpdate) {},
+			warnings: []string{"target Deployment default/nginx does not exist"}},
+		{name: "container missing in target", objects: []client.Object{deployment}, mutate: func(r *InplaceUpdate) {
+			r.Spec.Containers[0].Name = "php"
+		}, warnings: []string{"target Deployment default/nginx has no Container named php"}},
+		{name: "container of another type in target", objects: []client.Object{deployment}, mutate: func(r *InplaceUpdate) {
+			r.Spec.Containers[1].Type = ContainerTypeContainer
+		}, warnings: []string{"target Deployment default/nginx has no Container named envoy"}},
+		{name: "invalid spec is denied", objects: []client.Object{deployment}, mutate: func(r *InplaceUpdate) {
+			r.Spec.Containers = nil
+		}, wantErr: true},
+		{name: "another update running on the target", objects: []client.Object{deployment, otherUpdate("earlier", "nginx", InplaceUpdatePhaseRunning)},
+			mutate: func(r *InplaceUpdate) {}, wantErr: true},
+		{name: "another update pending on the target", objects: []client.Object{deployment, otherUpdate("earlier", "nginx", "")},
+			mutate: func(r *InplaceUpdate) {}, wantErr: true},
+		{name: "other updates completed", objects: []client.Object{deployment, otherUpdate("finished", "nginx", InplaceUpdatePhaseFinished), otherUpdate("failed", "nginx", InplaceUpdatePhaseFailed)},
+			mutate: func(r *InplaceUpdate) {}},
+		{name: "another update running on another target", objects: []client.Object{deployment, otherUpdate("earlier", "php", InplaceUpdatePhaseRunning)},
+			mutate: func(r *InplaceUpdate) {}},
+		{name: "queued behind another update", objects: []client.Object{deployment, otherUpdate("earlier", "nginx", InplaceUpdatePhaseRunning)},
+			mutate:   func(r *InplaceUpdate) { r.Spec.ConcurrencyPolicy = ConcurrencyPolicyQueue },
+			warnings: []string{"InplaceUpdate earlier is still updating the target, this update is queued"}},
+		{name: "superseding another update", objects: []client.Object{deployment, otherUpdate("earlier", "nginx", InplaceUpdatePhasePending)},
+			mutate:   func(r *InplaceUpdate) { r.Spec.Supersede = true },
+			warnings: []string{"InplaceUpdate earlier is still updating the target, this update is queued"}},
+		{name: "other update superseded", objects: []client.Object{deployment, otherUpdate("earlier", "nginx", InplaceUpdatePhaseSuperseded)},
+			mutate: func(r *InplaceUpdate) {}},
+		{name: "dry run next to another update", objects: []client.Object{deployment, otherUpdate("earlier", "nginx", InplaceUpdatePhaseRunning)},
+			mutate: func(r *InplaceUpdate) { r.Spec.DryRun = true }},
+		{name: "other update is a dry run", objects: []client.Object{deployment, dryRunUpdate},
+			mutate: func(r *InplaceUpdate) {}},
+		{name: "rollback to a recorded revision", objects: []client.Object{deployment, revision},
+			mutate: func(r *InplaceUpdate) { r.Spec.Containers, r.Spec.RollbackTo = nil, ptr.To[int64](2) }},
+		{name: "rollback to the latest revision", objects: []client.Object{deployment, revision},
+			mutate: func(r *InplaceUpdate) { r.Spec.Containers, r.Spec.RollbackTo = nil, ptr.To[int64](0) }},
+		{name: "rollback to a missing revision", objects: []client.Object{deployment, revision},
+			mutate:   func(r *InplaceUpdate) { r.Spec.Containers, r.Spec.RollbackTo = nil, ptr.To[int64](1) },
+			warnings: []string{"target Deployment default/nginx has no revision 1"}},
+		{name: "rollback without revisions", objects: []client.Object{deployment},
+			mutate:   func(r *InplaceUpdate) { r.Spec.Containers, r.Spec.RollbackTo = nil, ptr.To[int64](0) },
+			warnings: []string{"target Deployment default/nginx has no revision to roll back"}},
+	}
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := fake.NewClientBuilder().WithScheme(scheme)
+			for _, obj := range tt.objects {
+				builder = builder.WithObjects(obj.DeepCopyObject().(client.Object))
+			}
+			v := &InplaceUpdateValidator{Reader: builder.Build()}
+			r := validInplaceUpdate()
+			tt.mutate(r)
+			before := r.DeepCopy()
+
+			warnings, err := v.ValidateCreate(context.Background(), r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if strings.Join(warnings, ";") != strings.Join(tt.warnings, ";") {
+				t.Errorf("expected warnings %v, got %v", tt.warnings, warnings)
+			}
+			if !equality.Semantic.DeepEqual(before, r) {
+				t.Errorf("validation must not mutate the object")
+			}
+		})
+	}
+}
+
+func TestValidateUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		dryRun  bool
+		mutate  func(r *InplaceUpdate)
+		wantErr bool
+	}{
+		{name: "pause", mutate: func(r *InplaceUpdate) { r.Spec.Paused = true }},
+		{name: "metadata only", mutate: func(r *InplaceUpdate) { r.Labels = map[string]string{"team": "web"} }},
+		{name: "change image", mutate: func(r *InplaceUpdate) { r.Spec.Containers[0].Image = "nginx:1.25.5" }, wantErr: true},
+		{name: "change target", mutate: func(r *InplaceUpdate) { r.Spec.TargetReference.Name = "php" }, wantErr: true},
+		{name: "run a dry run", dryRun: true, mutate: func(r *InplaceUpdate) { r.Spec.DryRun = false }},
+		{name: "turn on dry run", mutate: func(r *InplaceUpdate) { r.Spec.DryRun = true }, wantErr: true},
+		{name: "abort", mutate: func(r *InplaceUpdate) { r.Spec.Abort = true }},
+		{name: "abort along with another change", mutate: func(r *InplaceUpdate) { r.Spec.Abort, r.Spec.Supersede = true, true }, wantErr: true},
+		{name: "change initiator", mutate: func(r *InplaceUpdate) { r.Annotations[AnnotationInitiator] = "mallory" }, wantErr: true},
+		{name: "drop initiator groups", mutate: func(r *InplaceUpdate) { delete(r.Annotations, AnnotationInitiatorGroups) }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := validInplaceUpdate()
+			old.Annotations = map[string]string{AnnotationInitiator: "alice", AnnotationInitiatorGroups: "system:authenticated"}
+			old.Spec.DryRun = tt.dryRun
+			r := old.DeepCopy()
+			tt.mutate(r)
+			_, err := (&InplaceUpdateValidator{}).ValidateUpdate(context.Background(), old, r)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateUpdateBeforeDefaults(t *testing.T) {
+	// stored before spec.concurrencyPolicy and the container types were defaulted
+	old := validInplaceUpdate()
+	old.Spec.ConcurrencyPolicy = ""
+	for i := range old.Spec.Containers {
+		old.Spec.Containers[i].Type = ""
+	}
+	old.Spec.Containers = old.Spec.Containers[:1]
+	r := old.DeepCopy()
+	r.Finalizers = []string{"demo.cyisme.top/teardown"}
+	if err := (&InplaceUpdateDefaulter{}).Default(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Spec.ConcurrencyPolicy != ConcurrencyPolicyForbid || r.Spec.Containers[0].Type != ContainerTypeContainer {
+		t.Fatalf("expected the new object to be defaulted, got %+v", r.Spec)
+	}
+	if _, err := (&InplaceUpdateValidator{}).ValidateUpdate(context.Background(), old, r); err != nil {
+		t.Errorf("expected the update of an object missing the defaults to be admitted, got %v", err)
+	}
+	r.Spec.Containers[0].Image = "nginx:1.25.5"
+	if _, err := (&InplaceUpdateValidator{}).ValidateUpdate(context.Background(), old, r); err == nil {
+		t.Error("expected the spec to stay immutable")
+	}
+}
+
+func TestDefaultOnUpdate(t *testing.T) {
+	r := validInplaceUpdate()
+	r.Annotations = map[string]string{AnnotationInitiator: "alice"}
+	ctx := admission.NewContextWithRequest(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Update,
+		UserInfo:  authenticationv1.UserInfo{Username: "bob"},
+	}})
+	if err := (&InplaceUpdateDefaulter{}).Default(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Annotations[AnnotationInitiator] != "alice" {
+		t.Errorf("expected the initiator to be kept on updates, got %s", r.Annotations[AnnotationInitiator])
+	}
+}
@@ -17,10 +17,21 @@ limitations under the License.
 package v1
 
 import (
+	"context"
 	"fmt"
+	"strings"
 
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
@@ -33,78 +44,251 @@ var inplaceupdatelog = logf.Log.WithName("inplaceupdate-resource")
 func (r *InplaceUpdate) SetupWebhookWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewWebhookManagedBy(mgr).
 		For(r).
+		WithDefaulter(&InplaceUpdateDefaulter{}).
+		WithValidator(&InplaceUpdateValidator{Reader: mgr.GetAPIReader()}).
 		Complete()
 }
 
 //+kubebuilder:webhook:path=/mutate-apps-demo-cyisme-top-v1-inplaceupdate,mutating=true,failurePolicy=fail,sideEffects=None,groups=apps.demo.cyisme.top,resources=inplaceupdates,verbs=create;update,versions=v1,name=minplaceupdate.kb.io,admissionReviewVersions=v1
 
-var _ webhook.Defaulter = &InplaceUpdate{}
+// InplaceUpdateDefaulter defaults InplaceUpdates, and stamps the user creating an update
+// and its groups into the initiator annotations
+// +kubebuilder:object:generate=false
+type InplaceUpdateDefaulter struct{}
 
-// Default implements webhook.Defaulter so a webhook will be registered for the type
-func (r *InplaceUpdate) Default() {
+var _ webhook.CustomDefaulter = &InplaceUpdateDefaulter{}
+
+// Default implements webhook.CustomDefaulter so a webhook will be registered for the type
+func (d *InplaceUpdateDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	r, ok := obj.(*InplaceUpdate)
+	if !ok {
+		return fmt.Errorf("expected an InplaceUpdate but got a %T", obj)
+	}
 	inplaceupdatelog.Info("default", "name", r.Name)
+	// the annotations are overwritten so they can not be forged, and kept as they are on updates
+	if req, err := admission.RequestFromContext(ctx); err == nil && req.Operation == admissionv1.Create {
+		if r.Annotations == nil {
+			r.Annotations = map[string]string{}
+		}
+		r.Annotations[AnnotationInitiator] = req.UserInfo.Username
+		r.Annotations[AnnotationInitiatorGroups] = strings.Join(req.UserInfo.Groups, ",")
+	}
+	setDefaults(r)
+	return nil
+}
+
+func setDefaults(r *InplaceUpdate) {
+	if target := r.Spec.TargetReference; target != nil {
+		if target.APIVersion == "" {
+			target.APIVersion = TargetAPIVersionDeployment
+		}
+		if target.Kind == "" {
+			target.Kind = TargetKindDeployment
+		}
+	}
 	if r.Spec.ReclaimPolicy == "" {
 		r.Spec.ReclaimPolicy = ReclaimPolicyRetain
 	}
 	if r.Spec.FailurePolicy == "" {
 		r.Spec.FailurePolicy = FailurePolicyIgnore
 	}
+	if r.Spec.ConcurrencyPolicy == "" {
+		r.Spec.ConcurrencyPolicy = ConcurrencyPolicyForbid
+	}
+	for i := range r.Spec.Containers {
+		if r.Spec.Containers[i].Type == "" {
+			r.Spec.Containers[i].Type = ContainerTypeContainer
+		}
+	}
+	if analysis := r.Spec.Analysis; analysis != nil {
+		if analysis.IntervalSeconds == nil {
+			analysis.IntervalSeconds = ptr.To[int32](DefaultAnalysisIntervalSeconds)
+		}
+		if analysis.Count == nil {
+			analysis.Count = ptr.To[int32](1)
+		}
+		if analysis.FailureLimit == nil {
+			analysis.FailureLimit = ptr.To[int32](0)
+		}
+		if analysis.OnFailure == "" {
+			analysis.OnFailure = AnalysisFailurePolicyRollback
+		}
+		for idx := range analysis.Metrics {
+			if web := analysis.Metrics[idx].Web; web != nil && web.TimeoutSeconds == nil {
+				web.TimeoutSeconds = ptr.To[int32](DefaultWebMetricTimeoutSeconds)
+			}
+		}
+	}
 }
 
 //+kubebuilder:webhook:path=/validate-apps-demo-cyisme-top-v1-inplaceupdate,mutating=false,failurePolicy=fail,sideEffects=None,groups=apps.demo.cyisme.top,resources=inplaceupdates,verbs=create;update,versions=v1,name=vinplaceupdate.kb.io,admissionReviewVersions=v1
 
-var _ webhook.Validator = &InplaceUpdate{}
+// InplaceUpdateValidator validates InplaceUpdates, the Reader is used to look up the target
+// workload, problems found on the live target are reported as warnings
+// +kubebuilder:object:generate=false
+type InplaceUpdateValidator struct {
+	Reader client.Reader
+}
+
+var _ webhook.CustomValidator = &InplaceUpdateValidator{}
 
-func checkTargetReference(target *TargetReference) error {
-	if target == nil {
-		return fmt.Errorf("targetReference is required")
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *InplaceUpdateValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	r, ok := obj.(*InplaceUpdate)
+	if !ok {
+		return nil, fmt.Errorf("expected an InplaceUpdate but got a %T", obj)
 	}
-	if target.Name == "" {
-		return fmt.Errorf("targetReference.name is required")
+	inplaceupdatelog.Info("validate create", "name", r.Name)
+
+	if errs := ValidateInplaceUpdateSpec(&r.Spec); len(errs) != 0 {
+		return nil, apierrors.NewInvalid(GroupVersion.WithKind("InplaceUpdate").GroupKind(), r.Name, errs)
 	}
-	if target.APIVersion == "" || target.Kind == "" {
-		return fmt.Errorf("targetReference.apiVersion and targetReference.kind are required")
+	warnings, err := v.checkConcurrentUpdates(ctx, r)
+	if err != nil {
+		return warnings, err
 	}
-	if target.APIVersion != "v1" && target.Kind != "Deployment" {
-		return fmt.Errorf("targetReference.apiVersion should be v1 and targetReference.kind should be Deployment")
+	warnings = append(warnings, v.targetWarnings(ctx, r)...)
+	return append(warnings, v.revisionWarnings(ctx, r)...), nil
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *InplaceUpdateValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	r, ok := newObj.(*InplaceUpdate)
+	if !ok {
+		return nil, fmt.Errorf("expected an InplaceUpdate but got a %T", newObj)
 	}
+	inplaceupdatelog.Info("validate update", "name", r.Name)
 
-	return nil
+	old, ok := oldObj.(*InplaceUpdate)
+	if !ok {
+		return nil, fmt.Errorf("expected an InplaceUpdate but got a %T", oldObj)
+	}
+	// only spec.paused can be changed once the update is created, spec.abort can be turned on
+	// and spec.dryRun can be turned off to run the planned update.
+	// Both sides are compared defaulted, the old object may predate newer defaults.
+	defaultedOld, defaultedNew := old.DeepCopy(), r.DeepCopy()
+	setDefaults(defaultedOld)
+	setDefaults(defaultedNew)
+	oldSpec := &defaultedOld.Spec
+	oldSpec.Paused = r.Spec.Paused
+	if r.Spec.Abort {
+		oldSpec.Abort = true
+	}
+	if old.Spec.DryRun {
+		oldSpec.DryRun = r.Spec.DryRun
+	}
+	if !equality.Semantic.DeepEqual(*oldSpec, defaultedNew.Spec) {
+		return nil, fmt.Errorf("inplaceupdate spec is immutable except spec.paused, spec.abort which can only be turned on, and spec.dryRun which can only be turned off")
+	}
+	for _, key := range []string{AnnotationInitiator, AnnotationInitiatorGroups} {
+		if old.Annotations[key] != r.Annotations[key] {
+			return nil, apierrors.NewInvalid(GroupVersion.WithKind("InplaceUpdate").GroupKind(), r.Name, field.ErrorList{
+				field.Forbidden(field.NewPath("metadata", "annotations").Key(key), "is set when the update is created and can not be changed"),
+			})
+		}
+	}
+	if old.Spec.DryRun && !r.Spec.DryRun {
+		return v.checkConcurrentUpdates(ctx, r)
+	}
+	return nil, nil
 }
 
-// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
-func (r *InplaceUpdate) ValidateCreate() (admission.Warnings, error) {
-	inplaceupdatelog.Info("validate create", "name", r.Name)
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *InplaceUpdateValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
 
-	if r.Spec.TargetReference == nil {
-		return admission.Warnings{}, fmt.Errorf("targetReference is required")
+// checkConcurrentUpdates denies the update if another one is still updating the same target,
+// unless the update asks to be queued or to supersede the others. Dry runs never touch the target
+// and are left out on both sides.
+func (v *InplaceUpdateValidator) checkConcurrentUpdates(ctx context.Context, r *InplaceUpdate) (admission.Warnings, error) {
+	if v.Reader == nil || r.Spec.DryRun {
+		return nil, nil
 	}
+	list := &InplaceUpdateList{}
+	if err := v.Reader.List(ctx, list, client.InNamespace(r.Namespace)); err != nil {
+		return admission.Warnings{fmt.Sprintf("unable to look up the updates of the target: %v", err)}, nil
+	}
+	for _, other := range list.Items {
+		if other.Name == r.Name || other.Spec.DryRun || other.Spec.TargetReference == nil || other.Spec.TargetReference.Name != r.Spec.TargetReference.Name {
+			continue
+		}
+		switch other.Status.Phase {
+		case InplaceUpdatePhaseFinished, InplaceUpdatePhaseFailed, InplaceUpdatePhaseSuperseded:
+			continue
+		}
+		if r.Spec.ConcurrencyPolicy == ConcurrencyPolicyQueue || r.Spec.Supersede {
+			return admission.Warnings{fmt.Sprintf("InplaceUpdate %s is still updating the target, this update is queued", other.Name)}, nil
+		}
+		return nil, apierrors.NewInvalid(GroupVersion.WithKind("InplaceUpdate").GroupKind(), r.Name, field.ErrorList{
+			field.Forbidden(field.NewPath("spec", "targetRef", "name"),
+				fmt.Sprintf("InplaceUpdate %s is still updating %s %s, set spec.concurrencyPolicy to %s to wait for it",
+					other.Name, r.Spec.TargetReference.Kind, r.Spec.TargetReference.Name, ConcurrencyPolicyQueue)),
+		})
+	}
+	return nil, nil
+}
 
-	warnings := admission.Warnings{}
-	if r.Spec.TargetReference.APIVersion == "" {
-		r.Spec.TargetReference.APIVersion = "v1"
-		warnings = append(warnings, "targetReference.apiVersion is empty, defaulting to v1")
+// targetWarnings looks up the target workload and warns if it does not exist
+// or does not have the containers to be updated
+func (v *InplaceUpdateValidator) targetWarnings(ctx context.Context, r *InplaceUpdate) admission.Warnings {
+	if v.Reader == nil {
+		return nil
 	}
-	if r.Spec.TargetReference.Kind == "" {
-		r.Spec.TargetReference.Kind = "Deployment"
-		warnings = append(warnings, "targetReference.kind is empty, defaulting to Deployment")
+	target := r.Spec.TargetReference
+	d := &appsv1.Deployment{}
+	if err := v.Reader.Get(ctx, types.NamespacedName{Namespace: r.Namespace, Name: target.Name}, d); err != nil {
+		if apierrors.IsNotFound(err) {
+			return admission.Warnings{fmt.Sprintf("target %s %s/%s does not exist", target.Kind, r.Namespace, target.Name)}
+		}
+		return admission.Warnings{fmt.Sprintf("unable to look up target %s %s/%s: %v", target.Kind, r.Namespace, target.Name, err)}
 	}
-	if err := checkTargetReference(r.Spec.TargetReference); err != nil {
-		return warnings, err
+	var warnings admission.Warnings
+	for _, c := range r.Spec.Containers {
+		if !hasContainer(&d.Spec.Template.Spec, c) {
+			warnings = append(warnings, fmt.Sprintf("target %s %s/%s has no %s named %s", target.Kind, r.Namespace, target.Name, containerTypeOrDefault(c.Type), c.Name))
+		}
 	}
-
-	return warnings, nil
+	return warnings
 }
 
-// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
-func (r *InplaceUpdate) ValidateUpdate(old runtime.Object) (admission.Warnings, error) {
-	inplaceupdatelog.Info("validate update", "name", r.Name)
+// revisionWarnings warns if the revision to roll back to does not exist
+func (v *InplaceUpdateValidator) revisionWarnings(ctx context.Context, r *InplaceUpdate) admission.Warnings {
+	if v.Reader == nil || r.Spec.RollbackTo == nil {
+		return nil
+	}
+	target := r.Spec.TargetReference
+	list := &InplaceUpdateRevisionList{}
+	if err := v.Reader.List(ctx, list, client.InNamespace(r.Namespace), client.MatchingLabels{LabelTarget: target.Name}); err != nil {
+		return admission.Warnings{fmt.Sprintf("unable to look up the revisions of target %s %s/%s: %v", target.Kind, r.Namespace, target.Name, err)}
+	}
+	for _, revision := range list.Items {
+		if *r.Spec.RollbackTo == 0 || revision.Spec.Revision == *r.Spec.RollbackTo {
+			return nil
+		}
+	}
+	if *r.Spec.RollbackTo == 0 {
+		return admission.Warnings{fmt.Sprintf("target %s %s/%s has no revision to roll back", target.Kind, r.Namespace, target.Name)}
+	}
+	return admission.Warnings{fmt.Sprintf("target %s %s/%s has no revision %d", target.Kind, r.Namespace, target.Name, *r.Spec.RollbackTo)}
+}
 
-	return nil, fmt.Errorf("inplaceupdate is immutable")
+func hasContainer(spec *corev1.PodSpec, c InplaceUpdateArgs) bool {
+	containers := spec.Containers
+	if containerTypeOrDefault(c.Type) == ContainerTypeInitContainer {
+		containers = spec.InitContainers
+	}
+	for i := range containers {
+		if containers[i].Name == c.Name {
+			return true
+		}
+	}
+	return false
 }
 
-// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
-func (r *InplaceUpdate) ValidateDelete() (admission.Warnings, error) {
-	inplaceupdatelog.Info("validate delete", "name", r.Name)
-	return nil, nil
+func containerTypeOrDefault(t ContainerType) ContainerType {
+	if t == "" {
+		return ContainerTypeContainer
+	}
+	return t
 }
@@ -0,0 +1,114 @@
+/*
+Copyright 2024 extreme.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// LabelTarget on an InplaceUpdateRevision is the name of the updated workload
+	LabelTarget = "demo.cyisme.top/target"
+	// LabelInplaceUpdateUID on an InplaceUpdateRevision is the UID of the recorded InplaceUpdate
+	LabelInplaceUpdateUID = "demo.cyisme.top/inplaceupdate-uid"
+)
+
+// RevisionContainer is the change of a container made by an update
+type RevisionContainer struct {
+	// Name of the container
+	Name string `json:"name"`
+	// Type of the container
+	// +optional
+	Type ContainerType `json:"type,omitempty"`
+	// From is the image before the update, empty if it is not known
+	// +optional
+	From string `json:"from,omitempty"`
+	// To is the image set by the update
+	To string `json:"to"`
+}
+
+// InplaceUpdateRevisionSpec is the record of a completed InplaceUpdate
+type InplaceUpdateRevisionSpec struct {
+	// TargetReference is the updated workload
+	TargetReference TargetReference `json:"targetRef"`
+	// Revision is the number of the revision, counted per workload from 1
+	Revision int64 `json:"revision"`
+	// InplaceUpdate is the name of the recorded InplaceUpdate
+	InplaceUpdate string `json:"inplaceUpdate"`
+	// Containers are the changes of the containers
+	Containers []RevisionContainer `json:"containers"`
+	// Initiator is who started the update, taken from the demo.cyisme.top/initiator annotation,
+	// or the field manager that created the update
+	// +optional
+	Initiator string `json:"initiator,omitempty"`
+	// InitiatorGroups are the groups of the initiator, taken from the demo.cyisme.top/initiator-groups annotation
+	// +optional
+	InitiatorGroups []string `json:"initiatorGroups,omitempty"`
+	// Reason is spec.reason of the update
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// ChangeRef is spec.changeRef of the update
+	// +optional
+	ChangeRef string `json:"changeRef,omitempty"`
+	// Result is the phase the update ended in, Finished or Failed
+	Result InplaceUpdatePhase `json:"result"`
+	// Message tells why the update failed
+	// +optional
+	Message string `json:"message,omitempty"`
+	// Replicas is the number of pods of the workload
+	Replicas int32 `json:"replicas"`
+	// UpdatedReplicas is the number of pods running the new images
+	UpdatedReplicas int32 `json:"updatedReplicas"`
+	// RollbackOf is the revision undone by the update, set for updates with spec.rollbackTo
+	// +optional
+	RollbackOf *int64 `json:"rollbackOf,omitempty"`
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:printcolumn:name="Target",type=string,JSONPath=`.spec.targetRef.name`
+//+kubebuilder:printcolumn:name="Revision",type=integer,JSONPath=`.spec.revision`
+//+kubebuilder:printcolumn:name="Result",type=string,JSONPath=`.spec.result`
+//+kubebuilder:printcolumn:name="Initiator",type=string,JSONPath=`.spec.initiator`
+//+kubebuilder:printcolumn:name="Change",type=string,JSONPath=`.spec.changeRef`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// InplaceUpdateRevision is the immutable record of a completed InplaceUpdate, it outlives the update
+// and is deleted with the workload
+type InplaceUpdateRevision struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="revision is immutable"
+	Spec InplaceUpdateRevisionSpec `json:"spec"`
+}
+
+//+kubebuilder:object:root=true
+
+// InplaceUpdateRevisionList contains a list of InplaceUpdateRevision
+type InplaceUpdateRevisionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []InplaceUpdateRevision `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&InplaceUpdateRevision{}, &InplaceUpdateRevisionList{})
+}
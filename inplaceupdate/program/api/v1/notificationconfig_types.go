@@ -0,0 +1,168 @@
+/*
+Copyright 2024 extreme.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NotificationEvent is a transition of an InplaceUpdate that is notified
+// +kubebuilder:validation:Enum=Started;Paused;Failed;Finished
+type NotificationEvent string
+
+const (
+	// NotificationEventStarted is sent when the first wave of the update starts
+	NotificationEventStarted NotificationEvent = "Started"
+	// NotificationEventPaused is sent when the update is paused, by spec.paused, a failed analysis or the schedule
+	NotificationEventPaused NotificationEvent = "Paused"
+	// NotificationEventFailed is sent when the update fails
+	NotificationEventFailed NotificationEvent = "Failed"
+	// NotificationEventFinished is sent when all pods are updated
+	NotificationEventFinished NotificationEvent = "Finished"
+)
+
+const (
+	DefaultNotificationDedupeSeconds  = 300
+	DefaultNotificationRetryAttempts  = 3
+	DefaultNotificationBackoffSeconds = 5
+)
+
+// WebhookSink posts a JSON document to a URL, e.g. a Slack compatible incoming webhook
+// +kubebuilder:validation:XValidation:rule="has(self.url) != has(self.urlSecretRef)",message="exactly one of url and urlSecretRef must be set"
+type WebhookSink struct {
+	// URL to post to
+	// +optional
+	URL string `json:"url,omitempty"`
+	// URLSecretRef selects the key of a Secret holding the URL, for URLs carrying a token like Slack webhooks
+	// +optional
+	URLSecretRef *corev1.SecretKeySelector `json:"urlSecretRef,omitempty"`
+	// Template is a Go template rendering the JSON body. The notification is the data of the template,
+	// and the json function quotes a value, e.g. {"text": {{ json .Message }}}.
+	// Defaults to a Slack compatible {"text": ...} summary
+	// +optional
+	Template string `json:"template,omitempty"`
+}
+
+// SMTPSink sends the notification by email
+type SMTPSink struct {
+	// Address of the SMTP server, host:port. STARTTLS is used if the server supports it
+	Address string `json:"address"`
+	// From is the sender address
+	From string `json:"from"`
+	// To are the recipient addresses
+	// +kubebuilder:validation:MinItems=1
+	To []string `json:"to"`
+	// UsernameSecretRef selects the key of a Secret holding the username, no authentication if unset
+	// +optional
+	UsernameSecretRef *corev1.SecretKeySelector `json:"usernameSecretRef,omitempty"`
+	// PasswordSecretRef selects the key of a Secret holding the password
+	// +optional
+	PasswordSecretRef *corev1.SecretKeySelector `json:"passwordSecretRef,omitempty"`
+}
+
+// CloudEventsSink posts the notification as a CloudEvent in binary content mode over HTTP
+type CloudEventsSink struct {
+	// URL to post to
+	URL string `json:"url"`
+	// Source of the events, defaults to the path of the InplaceUpdate
+	// +optional
+	Source string `json:"source,omitempty"`
+}
+
+// NotificationSink is a destination of notifications, exactly one of webhook, smtp and cloudEvents is set
+// +kubebuilder:validation:XValidation:rule="[has(self.webhook), has(self.smtp), has(self.cloudEvents)].filter(x, x).size() == 1",message="exactly one of webhook, smtp and cloudEvents must be set"
+type NotificationSink struct {
+	// Name of the sink, routes refer to it
+	Name string `json:"name"`
+	// +optional
+	Webhook *WebhookSink `json:"webhook,omitempty"`
+	// +optional
+	SMTP *SMTPSink `json:"smtp,omitempty"`
+	// +optional
+	CloudEvents *CloudEventsSink `json:"cloudEvents,omitempty"`
+}
+
+// NotificationRoute sends the events of the matching updates to sinks
+type NotificationRoute struct {
+	// Selector matches the labels of the InplaceUpdates, all updates of the namespace match if unset
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// Events to send, all events if empty
+	// +optional
+	Events []NotificationEvent `json:"events,omitempty"`
+	// Sinks are the names of the sinks to send to
+	// +kubebuilder:validation:MinItems=1
+	Sinks []string `json:"sinks"`
+}
+
+// NotificationRetry controls how failed deliveries are retried
+type NotificationRetry struct {
+	// Attempts is the number of deliveries tried before the notification is dropped
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=3
+	// +optional
+	Attempts *int32 `json:"attempts,omitempty"`
+	// BackoffSeconds is the wait before the first retry, doubled for every retry
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=5
+	// +optional
+	BackoffSeconds *int32 `json:"backoffSeconds,omitempty"`
+}
+
+// NotificationConfigSpec defines the notifications of the InplaceUpdates of the namespace
+type NotificationConfigSpec struct {
+	// Sinks are the destinations of the notifications
+	// +kubebuilder:validation:MinItems=1
+	Sinks []NotificationSink `json:"sinks"`
+	// Routes decide which events of which updates are sent to which sinks
+	// +kubebuilder:validation:MinItems=1
+	Routes []NotificationRoute `json:"routes"`
+	// Retry controls how failed deliveries are retried
+	// +optional
+	Retry *NotificationRetry `json:"retry,omitempty"`
+	// DedupeSeconds drops a notification if the same event of the same update was sent to the sink
+	// within the window, e.g. an update paused and resumed repeatedly
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default=300
+	// +optional
+	DedupeSeconds *int32 `json:"dedupeSeconds,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// NotificationConfig sends notifications when the InplaceUpdates of its namespace start, pause, fail or finish
+type NotificationConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec NotificationConfigSpec `json:"spec"`
+}
+
+//+kubebuilder:object:root=true
+
+// NotificationConfigList contains a list of NotificationConfig
+type NotificationConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NotificationConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NotificationConfig{}, &NotificationConfigList{})
+}
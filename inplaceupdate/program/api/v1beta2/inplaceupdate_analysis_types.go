@@ -0,0 +1,190 @@
+/*
+Copyright 2024 extreme.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:validation:Enum=LessThan;LessThanOrEqual;GreaterThan;GreaterThanOrEqual
+type ThresholdOperator string
+
+const (
+	ThresholdOperatorLessThan           ThresholdOperator = "LessThan"
+	ThresholdOperatorLessThanOrEqual    ThresholdOperator = "LessThanOrEqual"
+	ThresholdOperatorGreaterThan        ThresholdOperator = "GreaterThan"
+	ThresholdOperatorGreaterThanOrEqual ThresholdOperator = "GreaterThanOrEqual"
+)
+
+// MetricThreshold compares the value of a measurement with a threshold
+type MetricThreshold struct {
+	// Operator is one of LessThan, LessThanOrEqual, GreaterThan, GreaterThanOrEqual
+	Operator ThresholdOperator `json:"operator"`
+	// Value is the threshold, a decimal number, e.g. "0.01"
+	Value string `json:"value"`
+}
+
+// PrometheusMetric is measured with an instant query against a Prometheus compatible HTTP API
+type PrometheusMetric struct {
+	// Address is the base URL of the API, e.g. http://prometheus.monitoring:9090
+	Address string `json:"address"`
+	// Query is the PromQL query, it must return a scalar or a vector with a single sample.
+	// It is a Go template: {{.Namespace}}, {{.Target}}, {{.Wave}} and {{.Pods}}, a regular expression
+	// matching the pods updated so far, are replaced before the query is sent
+	Query string `json:"query"`
+}
+
+// WebMetric is measured by posting the updated pods to a verification service. The wave, the
+// update and the name, IP and images of each pod updated so far are posted as JSON, the measurement
+// is successful if the service answers 2xx with {"result":"pass"}, failed on any other answer
+type WebMetric struct {
+	// URL of the service, http or https
+	URL string `json:"url"`
+	// BearerTokenSecretRef selects the key of a Secret in the namespace of the update holding
+	// the token sent in the Authorization header
+	// +optional
+	BearerTokenSecretRef *corev1.SecretKeySelector `json:"bearerTokenSecretRef,omitempty"`
+	// TLS configures the verification of the certificate of the service
+	// +optional
+	TLS *WebTLSConfig `json:"tls,omitempty"`
+	// TimeoutSeconds is how long to wait for an answer, a request timing out is inconclusive
+	// default is 10
+	// +optional
+	TimeoutSeconds *int32 `json:"timeoutSeconds,omitempty"`
+}
+
+// WebTLSConfig configures the verification of the certificate of a web metric
+type WebTLSConfig struct {
+	// CASecretRef selects the key of a Secret in the namespace of the update holding
+	// the PEM encoded CA certificates trusted instead of the system ones
+	// +optional
+	CASecretRef *corev1.SecretKeySelector `json:"caSecretRef,omitempty"`
+	// ServerName overrides the name the certificate is verified against
+	// +optional
+	ServerName string `json:"serverName,omitempty"`
+	// InsecureSkipVerify skips the verification of the certificate
+	// +optional
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+// AnalysisMetric is a metric measured after each wave
+type AnalysisMetric struct {
+	// Name of the metric, unique within the analysis
+	Name string `json:"name"`
+	// Prometheus measures the metric with a PromQL query
+	// +optional
+	Prometheus *PrometheusMetric `json:"prometheus,omitempty"`
+	// Web measures the metric with a verification service, it does not take thresholds
+	// +optional
+	Web *WebMetric `json:"web,omitempty"`
+	// SuccessThreshold is met by a successful measurement. If it is not met the measurement is
+	// inconclusive when a failure threshold is set, failed otherwise
+	// +optional
+	SuccessThreshold *MetricThreshold `json:"successThreshold,omitempty"`
+	// FailureThreshold is met by a failed measurement
+	// +optional
+	FailureThreshold *MetricThreshold `json:"failureThreshold,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=Abort;Pause;Rollback
+type AnalysisFailurePolicyType string
+
+// AnalysisFailurePolicyAbort fails the update, pods already updated are kept
+const AnalysisFailurePolicyAbort AnalysisFailurePolicyType = "Abort"
+
+// AnalysisFailurePolicyPause pauses the update by setting spec.paused, the analysis of the wave
+// starts over once the update is resumed
+const AnalysisFailurePolicyPause AnalysisFailurePolicyType = "Pause"
+
+// AnalysisFailurePolicyRollback fails the update and creates an update restoring the images
+// the updated pods ran before
+const AnalysisFailurePolicyRollback AnalysisFailurePolicyType = "Rollback"
+
+// Analysis measures metrics of the updated pods once a wave has completed,
+// the next wave only starts, and the update only finishes, once the analysis has passed
+type Analysis struct {
+	// Metrics are measured together, a measurement round is successful if all metrics are,
+	// failed if any metric is, inconclusive otherwise
+	Metrics []AnalysisMetric `json:"metrics"`
+	// IntervalSeconds is the time between the completion of the wave and the first round,
+	// and between two rounds
+	// default is 60
+	// +optional
+	IntervalSeconds *int32 `json:"intervalSeconds,omitempty"`
+	// Count is the number of successful rounds needed for the analysis to pass,
+	// inconclusive rounds are repeated
+	// default is 1
+	// +optional
+	Count *int32 `json:"count,omitempty"`
+	// FailureLimit is the number of failed rounds tolerated
+	// default is 0
+	// +optional
+	FailureLimit *int32 `json:"failureLimit,omitempty"`
+	// OnFailure is what happens once the analysis has failed, one of Abort, Pause, Rollback
+	// default is Rollback
+	// +optional
+	OnFailure AnalysisFailurePolicyType `json:"onFailure,omitempty"`
+}
+
+type MeasurementPhase string
+
+const (
+	MeasurementPhaseSuccessful   MeasurementPhase = "Successful"
+	MeasurementPhaseFailed       MeasurementPhase = "Failed"
+	MeasurementPhaseInconclusive MeasurementPhase = "Inconclusive"
+	// MeasurementPhaseError is a measurement that could not be taken, the round is inconclusive
+	MeasurementPhaseError MeasurementPhase = "Error"
+)
+
+// MetricMeasurement is a measurement of a metric
+type MetricMeasurement struct {
+	// Metric is the name of the metric
+	Metric string `json:"metric"`
+	// Phase is the outcome of the measurement
+	Phase MeasurementPhase `json:"phase"`
+	// Value is the measured value
+	// +optional
+	Value string `json:"value,omitempty"`
+	// Message tells why the measurement failed or could not be taken
+	// +optional
+	Message string `json:"message,omitempty"`
+	// Time is the time the measurement was taken
+	Time metav1.Time `json:"time"`
+}
+
+type AnalysisPhase string
+
+const (
+	AnalysisPhaseRunning    AnalysisPhase = "Running"
+	AnalysisPhaseSuccessful AnalysisPhase = "Successful"
+	AnalysisPhaseFailed     AnalysisPhase = "Failed"
+)
+
+// WaveAnalysis is the analysis run after a wave
+type WaveAnalysis struct {
+	Phase AnalysisPhase `json:"phase"`
+	// Successful is the number of successful rounds
+	Successful int32 `json:"successful"`
+	// Failed is the number of failed rounds
+	Failed int32 `json:"failed"`
+	// Inconclusive is the number of inconclusive rounds
+	Inconclusive int32 `json:"inconclusive"`
+	// Measurements are the latest measurements, oldest first
+	// +optional
+	Measurements []MetricMeasurement `json:"measurements,omitempty"`
+}
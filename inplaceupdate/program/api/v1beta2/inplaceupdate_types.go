@@ -0,0 +1,487 @@
+/*
+Copyright 2024 extreme.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// TargetReference identifies the workload to be updated
+type TargetReference struct {
+	// APIVersion of the workload, e.g. apps/v1
+	APIVersion string `json:"apiVersion"`
+	// Kind of the workload, e.g. Deployment
+	Kind string `json:"kind"`
+	// Name of the workload, in the namespace of the InplaceUpdate
+	Name string `json:"name"`
+}
+
+// +kubebuilder:validation:Enum=Container;InitContainer;EphemeralContainer
+type ContainerType string
+
+const ContainerTypeContainer ContainerType = "Container"
+const ContainerTypeInitContainer ContainerType = "InitContainer"
+const ContainerTypeEphemeralContainer ContainerType = "EphemeralContainer"
+
+type ContainerUpdate struct {
+	// Name of the container
+	Name string `json:"name"`
+	// Image is the new image of the container
+	Image string `json:"image"`
+	// Type is the type of the container, one of Container, InitContainer
+	// InitContainer only supports restartable init containers (native sidecars),
+	// EphemeralContainer can not be updated in place
+	// default is Container
+	// +optional
+	Type ContainerType `json:"type,omitempty"`
+	// DependsOn are the names of other containers of the update that run their new image and are ready
+	// before this container is updated, so e.g. a sidecar is updated before the main container
+	// +optional
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+// Weekday is the short name of a day of the week
+// +kubebuilder:validation:Enum=Sun;Mon;Tue;Wed;Thu;Fri;Sat
+type Weekday string
+
+// ScheduleWindow is a range of hours in which pods may be updated
+type ScheduleWindow struct {
+	// Days are the days of the week the window opens on, every day if empty
+	// +optional
+	Days []Weekday `json:"days,omitempty"`
+	// StartHour is the hour the window opens at, from 0 to 23
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=23
+	StartHour int32 `json:"startHour"`
+	// EndHour is the hour the window closes at, from 1 to 24. A window whose end hour
+	// is not after its start hour closes on the next day, e.g. 22 to 4
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=24
+	EndHour int32 `json:"endHour"`
+}
+
+// UpdateSchedule restricts when the update starts and when its waves may start
+type UpdateSchedule struct {
+	// StartTime is the earliest time the update starts at
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// Cron is a standard cron expression, e.g. "0 2 * * *",
+	// the update starts at the first time it matches after the creation of the update and the start time
+	// +optional
+	Cron string `json:"cron,omitempty"`
+	// Windows are the maintenance windows, the update starts and starts new waves only inside one of them.
+	// Pods already restarting when a window closes are left to become ready.
+	// Any time is allowed if empty
+	// +optional
+	Windows []ScheduleWindow `json:"windows,omitempty"`
+	// TimeZone is the IANA name of the time zone of the cron expression and the windows
+	// default is UTC
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// WaveOrderingPolicy tells how the pods of the target are ordered into waves
+// +kubebuilder:validation:Enum=Spread;ZoneByZone
+type WaveOrderingPolicy string
+
+const (
+	// WaveOrderingSpread interleaves the pods of the zones and of the nodes, so a wave takes
+	// as few pods of the same zone and node as it can
+	WaveOrderingSpread WaveOrderingPolicy = "Spread"
+	// WaveOrderingZoneByZone updates the zones one after another, a wave only takes pods of one zone
+	WaveOrderingZoneByZone WaveOrderingPolicy = "ZoneByZone"
+)
+
+// WaveOrdering orders the pods into waves. Pods with a higher priority go first, then the pods
+// on cordoned nodes, then the pods are ordered by the policy.
+// +kubebuilder:validation:XValidation:rule="!has(self.priorityLabel) || !has(self.priorityAnnotation)",message="at most one of priorityLabel and priorityAnnotation may be set"
+type WaveOrdering struct {
+	// Policy is one of Spread, ZoneByZone. Zones and nodes are read from the topology.kubernetes.io/zone
+	// and kubernetes.io/hostname labels of the nodes. The pods are ordered by name if empty
+	// +optional
+	Policy WaveOrderingPolicy `json:"policy,omitempty"`
+	// PriorityLabel is the key of a label of the pods holding an integer priority, higher goes first.
+	// Pods without it have the priority 0
+	// +optional
+	PriorityLabel string `json:"priorityLabel,omitempty"`
+	// PriorityAnnotation is the key of an annotation of the pods holding an integer priority, higher goes first.
+	// Pods without it have the priority 0. At most one of priorityLabel and priorityAnnotation is set
+	// +optional
+	PriorityAnnotation string `json:"priorityAnnotation,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=InPlace;InPlaceIfPossible
+type UpdateStrategyType string
+
+const (
+	// UpdateStrategyInPlace only updates the images of the pods in place
+	UpdateStrategyInPlace UpdateStrategyType = "InPlace"
+	// UpdateStrategyInPlaceIfPossible updates the pods in place, and deletes the pods that can not be updated
+	// in place so that the replicaset recreates them. The images are set in the template of the target and
+	// of its replicaset too, so the recreated pods run the new images.
+	UpdateStrategyInPlaceIfPossible UpdateStrategyType = "InPlaceIfPossible"
+)
+
+// HorizontalPodAutoscalerPolicy tells how the HorizontalPodAutoscaler of the target is held during the update
+// +kubebuilder:validation:Enum=Hold;NoScaleDown
+type HorizontalPodAutoscalerPolicy string
+
+const (
+	// HorizontalPodAutoscalerHold sets the min and max replicas of the HorizontalPodAutoscaler to the replicas
+	// of the target, so it does not scale the target while the pods restart
+	HorizontalPodAutoscalerHold HorizontalPodAutoscalerPolicy = "Hold"
+	// HorizontalPodAutoscalerNoScaleDown raises the min replicas of the HorizontalPodAutoscaler to the replicas
+	// of the target, so it may scale the target up but not down
+	HorizontalPodAutoscalerNoScaleDown HorizontalPodAutoscalerPolicy = "NoScaleDown"
+)
+
+// VerticalPodAutoscalerPolicy tells how the VerticalPodAutoscaler of the target is held during the update
+// +kubebuilder:validation:Enum=Off
+type VerticalPodAutoscalerPolicy string
+
+// VerticalPodAutoscalerOff sets the update mode of the VerticalPodAutoscaler to Off, so it does not evict
+// the pods being updated
+const VerticalPodAutoscalerOff VerticalPodAutoscalerPolicy = "Off"
+
+// AutoscalerCoordination holds the autoscalers targeting the deployment while it is updated.
+// Their settings are restored once the update is completed or deleted.
+type AutoscalerCoordination struct {
+	// HorizontalPodAutoscaler is one of Hold, NoScaleDown. The HorizontalPodAutoscaler is left alone if empty
+	// +optional
+	HorizontalPodAutoscaler HorizontalPodAutoscalerPolicy `json:"horizontalPodAutoscaler,omitempty"`
+	// VerticalPodAutoscaler is Off or empty. The VerticalPodAutoscaler is left alone if empty
+	// +optional
+	VerticalPodAutoscaler VerticalPodAutoscalerPolicy `json:"verticalPodAutoscaler,omitempty"`
+}
+
+// UpdateStrategy controls how fast the pods are updated
+type UpdateStrategy struct {
+	// Type is one of InPlace, InPlaceIfPossible. With InPlaceIfPossible a pod is recreated when
+	// its patch is rejected, when a target is an init container that is not a restartable sidecar, or when
+	// a target container differs from the template of the replicaset in fields other than the image.
+	// Recreated pods count toward maxUnavailable.
+	// default is InPlace
+	// +optional
+	Type UpdateStrategyType `json:"type,omitempty"`
+	// The maximum number of pods that can be unavailable during update.
+	// Value can be an absolute number (ex: 5) or a percentage of desired pods (ex: 10%).
+	// Absolute number is calculated from percentage by rounding up.
+	// Defaults to 20%.
+	// +optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+	// The maximum number of surge pods created with the new images before a wave starts, so the wave
+	// restarts pods without reducing the capacity of the target. Value can be an absolute number (ex: 1)
+	// or a percentage of desired pods (ex: 10%), rounded up. The surge pods are removed once the last wave
+	// has completed. Defaults to 0.
+	// +optional
+	MaxSurge *intstr.IntOrString `json:"maxSurge,omitempty"`
+	// Ordering orders the pods into waves by priority, cordoned nodes and topology
+	// +optional
+	Ordering *WaveOrdering `json:"ordering,omitempty"`
+	// DelaySeconds is the time to wait before starting the update
+	// default is 0
+	// +optional
+	DelaySeconds *int32 `json:"delaySeconds,omitempty"`
+	// ProgressDeadlineSeconds is the maximum time in seconds for the update to make progress,
+	// i.e. to start or complete a wave, before it is considered to be failed
+	// with the ProgressDeadlineExceeded reason
+	// +optional
+	ProgressDeadlineSeconds *int32 `json:"progressDeadlineSeconds,omitempty"`
+	// Schedule restricts when the update starts and when its waves may start
+	// +optional
+	Schedule *UpdateSchedule `json:"schedule,omitempty"`
+	// Analysis gates each wave on metrics of the pods updated so far
+	// +optional
+	Analysis *Analysis `json:"analysis,omitempty"`
+	// Atomic reverts all the containers of a pod to the images they ran before the update when one of them
+	// does not come up on its new image. A reverted pod is left out of the rest of the update, which fails
+	// once the other pods are updated, or right away with the Abort failure policy. The revert only covers the
+	// pods: the deployment and replicaset templates keep the new images until the failed update puts them back
+	// +optional
+	Atomic bool `json:"atomic,omitempty"`
+	// Autoscalers holds the HorizontalPodAutoscaler and the VerticalPodAutoscaler of the target during the update
+	// +optional
+	Autoscalers *AutoscalerCoordination `json:"autoscalers,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=Delete;Retain
+type ReclaimPolicyType string
+
+const ReclaimPolicyDelete ReclaimPolicyType = "Delete"
+const ReclaimPolicyRetain ReclaimPolicyType = "Retain"
+
+// +kubebuilder:validation:Enum=Ignore;Abort
+type FailurePolicyType string
+
+const FailurePolicyIgnore FailurePolicyType = "Ignore"
+const FailurePolicyAbort FailurePolicyType = "Abort"
+
+// +kubebuilder:validation:Enum=Forbid;Queue
+type ConcurrencyPolicyType string
+
+const ConcurrencyPolicyForbid ConcurrencyPolicyType = "Forbid"
+const ConcurrencyPolicyQueue ConcurrencyPolicyType = "Queue"
+
+// UpdatePolicy controls what happens on failure, on concurrent updates and after the update
+type UpdatePolicy struct {
+	// Failure is the policy to handle the failure during the update
+	// default is Ignore
+	// +optional
+	Failure FailurePolicyType `json:"failure,omitempty"`
+	// Reclaim is the policy to reclaim the resources after the update
+	// default is Retain
+	// +optional
+	Reclaim ReclaimPolicyType `json:"reclaim,omitempty"`
+	// Concurrency decides what happens if another update is still updating the same target,
+	// one of Forbid, Queue
+	// default is Forbid
+	// +optional
+	Concurrency ConcurrencyPolicyType `json:"concurrency,omitempty"`
+	// Supersede skips the updates of the same target created before this one that have not started yet,
+	// they end in the Superseded phase. The update is queued as with the Queue concurrency policy.
+	// +optional
+	Supersede bool `json:"supersede,omitempty"`
+}
+
+// InplaceUpdateSpec defines the desired state of InplaceUpdate
+type InplaceUpdateSpec struct {
+	// Target is the workload to be updated
+	Target *TargetReference `json:"target"`
+	// Containers defines the containers to be updated, required unless spec.rollbackTo is set
+	// +optional
+	Containers []ContainerUpdate `json:"containers,omitempty"`
+	// RollbackTo undoes a revision of the target: the containers it changed are set back to the images
+	// they ran before it, 0 undoes the latest revision. spec.containers must be empty
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	RollbackTo *int64 `json:"rollbackTo,omitempty"`
+	// Strategy controls how fast the pods are updated
+	// +optional
+	Strategy UpdateStrategy `json:"strategy,omitempty"`
+	// Policy controls what happens on failure, on concurrent updates and after the update
+	// +optional
+	Policy UpdatePolicy `json:"policy,omitempty"`
+	// Paused stops the update from making progress, pods already updated are kept
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+	// Abort fails the update with the Aborted reason, pods already updated are kept and the target is
+	// given back as for any failed update. It can only be turned on.
+	// +optional
+	Abort bool `json:"abort,omitempty"`
+	// DryRun plans the update without pausing the target or patching any pod, the plan is reported
+	// in status.plan and refreshed until spec.dryRun is set to false, which starts the update
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
+	// Reason tells why the update is made, it is carried into the events and the revision
+	// +kubebuilder:validation:MaxLength=1024
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// ChangeRef refers to the change request or ticket behind the update, e.g. CHG-1234
+	// +kubebuilder:validation:MaxLength=128
+	// +optional
+	ChangeRef string `json:"changeRef,omitempty"`
+}
+
+// InplaceUpdateRollback is the resolution of spec.rollbackTo
+type InplaceUpdateRollback struct {
+	// Revision is the number of the revision undone
+	Revision int64 `json:"revision"`
+	// Containers are set back to the images they ran before the revision
+	Containers []ContainerUpdate `json:"containers"`
+}
+
+// InplaceUpdateWave records a group of pods updated together
+type InplaceUpdateWave struct {
+	// Number of the wave, starting from 1
+	Number int32 `json:"number"`
+	// Pods is the names of the pods updated in the wave
+	Pods []string `json:"pods"`
+	// RecreatedPods is the names of the pods of the wave that were recreated, the others were updated in place
+	// +optional
+	RecreatedPods []string `json:"recreatedPods,omitempty"`
+	// RevertedPods is the names of the pods of the wave reverted to their previous images by an atomic update
+	// +optional
+	RevertedPods []string `json:"revertedPods,omitempty"`
+	// StartTime is the time the pods of the wave were patched
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// CompletionTime is the time all pods of the wave were observed ready on the new images
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// Analysis is the analysis run once the wave has completed
+	// +optional
+	Analysis *WaveAnalysis `json:"analysis,omitempty"`
+}
+
+type InplaceUpdatePhase string
+
+const InplaceUpdatePhasePending InplaceUpdatePhase = "Pending"
+const InplaceUpdatePhaseRunning InplaceUpdatePhase = "Running"
+const InplaceUpdatePhaseFinished InplaceUpdatePhase = "Finished"
+const InplaceUpdatePhaseFailed InplaceUpdatePhase = "Failed"
+const InplaceUpdatePhaseSuperseded InplaceUpdatePhase = "Superseded"
+const InplaceUpdatePhasePlanned InplaceUpdatePhase = "Planned"
+
+// InplaceUpdateContainerChange is an image change planned for a container
+type InplaceUpdateContainerChange struct {
+	// Name of the container
+	Name string `json:"name"`
+	// Type of the container
+	// +optional
+	Type ContainerType `json:"type,omitempty"`
+	// From is the image the container runs
+	From string `json:"from"`
+	// To is the image the container would be updated to
+	To string `json:"to"`
+}
+
+// InplaceUpdatePodPlan is what the update would do to a pod
+type InplaceUpdatePodPlan struct {
+	// Name of the pod
+	Name string `json:"name"`
+	// Wave is the number of the wave the pod would be updated in, 0 if the pod needs no update
+	// +optional
+	Wave int32 `json:"wave,omitempty"`
+	// Changes are the image changes of the pod
+	// +optional
+	Changes []InplaceUpdateContainerChange `json:"changes,omitempty"`
+	// Accepted reports whether the server-side dry-run patch of the pod was accepted
+	// +optional
+	Accepted bool `json:"accepted,omitempty"`
+	// Recreate reports whether the pod would be recreated rather than updated in place, with InPlaceIfPossible
+	// +optional
+	Recreate bool `json:"recreate,omitempty"`
+	// Message is the reason the server-side dry-run patch was rejected, or the pod would be recreated
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// InplaceUpdatePlan is the outcome of a dry run
+type InplaceUpdatePlan struct {
+	// PlanTime is the time the plan was made
+	PlanTime *metav1.Time `json:"planTime,omitempty"`
+	// ReplicaSet is the new replicaset of the target whose pods would be updated
+	// +optional
+	ReplicaSet string `json:"replicaSet,omitempty"`
+	// MaxUnavailable is the absolute number of pods that could be unavailable during the update
+	MaxUnavailable int32 `json:"maxUnavailable"`
+	// MaxSurge is the absolute number of surge pods created before the waves
+	// +optional
+	MaxSurge int32 `json:"maxSurge,omitempty"`
+	// Waves is the number of waves planned. The first wave accounts for the pods already unavailable
+	// and the PodDisruptionBudgets, the next ones assume the pods of the previous wave come back ready
+	Waves int32 `json:"waves"`
+	// Pods is the plan of every pod of the target
+	// +optional
+	Pods []InplaceUpdatePodPlan `json:"pods,omitempty"`
+	// Blockers are the reasons the update could not run or make progress now
+	// +optional
+	Blockers []string `json:"blockers,omitempty"`
+}
+
+// InplaceUpdateStatus defines the observed state of InplaceUpdate
+type InplaceUpdateStatus struct {
+	// Replicas is the number of pods to be updated
+	Replicas int32 `json:"replicas"`
+	// UpdatedReplicas is the number of pods that have been updated
+	UpdatedReplicas int32 `json:"updatedReplicas"`
+	// UnavailableReplicas is the number of pods that are unavailable
+	UnavailableReplicas int32 `json:"unavailableReplicas"`
+	// SurgeReplicas is the number of surge pods created by the update and not removed yet
+	// +optional
+	SurgeReplicas int32 `json:"surgeReplicas,omitempty"`
+	// RecreatedReplicas is the number of pods recreated rather than updated in place
+	// +optional
+	RecreatedReplicas int32 `json:"recreatedReplicas,omitempty"`
+	// RevertedReplicas is the number of pods reverted to their previous images by an atomic update
+	// +optional
+	RevertedReplicas int32 `json:"revertedReplicas,omitempty"`
+	// ContainerNumber is the number of containers to be updated
+	ContainerNumber int32 `json:"containerNumber"`
+	// UpdatedContainerNumber is the number of containers that have been updated
+	UpdatedContainerNumber int32 `json:"updatedContainerNumber"`
+	// ObservedGeneration is the most recent generation observed by the controller
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions represent the latest available observations of the update
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Waves is the timeline of the waves of the update
+	// +optional
+	Waves []InplaceUpdateWave `json:"waves,omitempty"`
+	// StartTime is the time the controller started to work on the update, after the delay
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// CompletionTime is the time the update finished, failed or was superseded
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// QueuePosition is the position of the update in the queue of its target,
+	// 0 when the update is not waiting for others
+	// +optional
+	QueuePosition int32 `json:"queuePosition,omitempty"`
+	// BlockedBy is the name of the update at the head of the queue of the target
+	// while this update is waiting for it
+	// +optional
+	BlockedBy string `json:"blockedBy,omitempty"`
+	// NextScheduleTime is the next time the schedule allows the update to start or to start a wave,
+	// set while the update waits for it
+	// +optional
+	NextScheduleTime *metav1.Time `json:"nextScheduleTime,omitempty"`
+	// Rollback is the revision undone and the containers set back by spec.rollbackTo,
+	// resolved when the update is first reconciled
+	// +optional
+	Rollback *InplaceUpdateRollback `json:"rollback,omitempty"`
+	// Plan is the outcome of the dry run, it is dropped once the update starts
+	// +optional
+	Plan  *InplaceUpdatePlan `json:"plan,omitempty"`
+	Phase InplaceUpdatePhase `json:"phase,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:storageversion
+//+kubebuilder:printcolumn:name="Target",type=string,JSONPath=`.spec.target.name`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Updated",type=integer,JSONPath=`.status.updatedReplicas`
+//+kubebuilder:printcolumn:name="Replicas",type=integer,JSONPath=`.status.replicas`
+//+kubebuilder:printcolumn:name="Started",type=date,JSONPath=`.status.startTime`
+//+kubebuilder:printcolumn:name="Completed",type=date,JSONPath=`.status.completionTime`
+//+kubebuilder:printcolumn:name="Queue",type=integer,JSONPath=`.status.queuePosition`,priority=1
+//+kubebuilder:printcolumn:name="Blocked-By",type=string,JSONPath=`.status.blockedBy`,priority=1
+//+kubebuilder:printcolumn:name="Next-Schedule",type=date,JSONPath=`.status.nextScheduleTime`,priority=1
+
+// InplaceUpdate is the Schema for the inplaceupdates API
+type InplaceUpdate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   InplaceUpdateSpec   `json:"spec,omitempty"`
+	Status InplaceUpdateStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// InplaceUpdateList contains a list of InplaceUpdate
+type InplaceUpdateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []InplaceUpdate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&InplaceUpdate{}, &InplaceUpdateList{})
+}
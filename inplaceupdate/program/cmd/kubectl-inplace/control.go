@@ -0,0 +1,161 @@
+/*
+Copyright 2024 extreme.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+	"github.com/Forget-C/demo/inplaceupdate/program/internal/util/inplaceupdate"
+)
+
+func newPauseCommand(o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "pause NAME",
+		Short: "Pause an InplaceUpdate, pods already updated are kept",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			i, err := o.setPaused(cmd.Context(), args[0], true)
+			if err != nil {
+				return err
+			}
+			if o.output == "" {
+				fmt.Fprintf(cmd.OutOrStdout(), "inplaceupdate.%s/%s paused\n", v1.GroupVersion.Group, i.Name)
+			}
+			return o.finish(cmd.Context(), cmd.OutOrStdout(), i)
+		},
+	}
+}
+
+func newResumeCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "resume NAME",
+		Short: "Resume a paused InplaceUpdate",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			i, err := o.setPaused(cmd.Context(), args[0], false)
+			if err != nil {
+				return err
+			}
+			if o.output == "" {
+				fmt.Fprintf(cmd.OutOrStdout(), "inplaceupdate.%s/%s resumed\n", v1.GroupVersion.Group, i.Name)
+			}
+			return o.finish(cmd.Context(), cmd.OutOrStdout(), i)
+		},
+	}
+	addWaitFlags(cmd, o)
+	return cmd
+}
+
+func newAbortCommand(o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "abort NAME",
+		Short: "Abort an InplaceUpdate, it fails with the Aborted reason and pods already updated are kept",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			i, err := o.abort(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			if o.output == "" {
+				fmt.Fprintf(cmd.OutOrStdout(), "inplaceupdate.%s/%s aborted\n", v1.GroupVersion.Group, i.Name)
+			}
+			return o.finish(cmd.Context(), cmd.OutOrStdout(), i)
+		},
+	}
+}
+
+func newApproveCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "approve NAME",
+		Short: "Start a dry-run InplaceUpdate as planned",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			i, err := o.approve(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			if o.output == "" {
+				fmt.Fprintf(cmd.OutOrStdout(), "inplaceupdate.%s/%s approved\n", v1.GroupVersion.Group, i.Name)
+			}
+			return o.finish(cmd.Context(), cmd.OutOrStdout(), i)
+		},
+	}
+	addWaitFlags(cmd, o)
+	return cmd
+}
+
+func (o *options) setPaused(ctx context.Context, name string, paused bool) (*v1.InplaceUpdate, error) {
+	var i *v1.InplaceUpdate
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		var err error
+		if i, err = o.getInplaceUpdate(ctx, name); err != nil {
+			return err
+		}
+		if i.Spec.Paused == paused {
+			return nil
+		}
+		patch := client.MergeFromWithOptions(i.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		i.Spec.Paused = paused
+		return o.client.Patch(ctx, i, patch)
+	})
+	return i, err
+}
+
+// approve turns off spec.dryRun, the update then runs as it was planned
+func (o *options) approve(ctx context.Context, name string) (*v1.InplaceUpdate, error) {
+	var i *v1.InplaceUpdate
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		var err error
+		if i, err = o.getInplaceUpdate(ctx, name); err != nil {
+			return err
+		}
+		if !i.Spec.DryRun {
+			return fmt.Errorf("inplaceupdate %s is not a dry run", name)
+		}
+		patch := client.MergeFromWithOptions(i.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		i.Spec.DryRun = false
+		return o.client.Patch(ctx, i, patch)
+	})
+	return i, err
+}
+
+// abort sets spec.abort, the controller then fails the update and gives its target back
+func (o *options) abort(ctx context.Context, name string) (*v1.InplaceUpdate, error) {
+	var i *v1.InplaceUpdate
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		var err error
+		if i, err = o.getInplaceUpdate(ctx, name); err != nil {
+			return err
+		}
+		if inplaceupdate.IsCompleted(i) {
+			return fmt.Errorf("inplaceupdate %s is already %s", name, i.Status.Phase)
+		}
+		if i.Spec.Abort {
+			return nil
+		}
+		patch := client.MergeFromWithOptions(i.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		i.Spec.Abort = true
+		return o.client.Patch(ctx, i, patch)
+	})
+	return i, err
+}
@@ -0,0 +1,238 @@
+/*
+Copyright 2024 extreme.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+	"github.com/Forget-C/demo/inplaceupdate/program/internal/util/inplaceupdate"
+)
+
+type historyOptions struct {
+	*options
+	updates bool
+}
+
+func newHistoryCommand(o *options) *cobra.Command {
+	h := &historyOptions{options: o}
+	cmd := &cobra.Command{
+		Use:   "history DEPLOYMENT",
+		Short: "List the revisions recorded for the completed updates of a deployment, oldest first",
+		Long: `List the revisions recorded for the completed updates of a deployment, oldest first.
+
+Every completed InplaceUpdate is recorded in an InplaceUpdateRevision holding the images before
+and after the update, rollback --to-revision undoes a revision.
+With --updates the InplaceUpdates of the deployment are listed instead.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if h.updates {
+				updates, err := h.listInplaceUpdates(cmd.Context(), args[0])
+				if err != nil {
+					return err
+				}
+				if h.output == "json" {
+					return printJSON(cmd.OutOrStdout(), updates)
+				}
+				return printHistory(cmd.OutOrStdout(), updates)
+			}
+			revisions, err := inplaceupdate.ListRevisions(cmd.Context(), h.client, h.namespace, args[0])
+			if err != nil {
+				return err
+			}
+			if h.output == "json" {
+				return printJSON(cmd.OutOrStdout(), revisions)
+			}
+			return printRevisions(cmd.OutOrStdout(), revisions)
+		},
+	}
+	cmd.Flags().BoolVar(&h.updates, "updates", false, "List the InplaceUpdates of the deployment instead of the revisions")
+	return cmd
+}
+
+func printHistory(out io.Writer, updates []v1.InplaceUpdate) error {
+	w := newTabWriter(out)
+	fmt.Fprintln(w, "NAME\tPHASE\tIMAGES\tUPDATED\tCREATED\tCOMPLETED")
+	for idx := range updates {
+		i := &updates[idx]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d/%d\t%s\t%s\n", i.Name, phase(i), images(inplaceupdate.UpdateContainers(i)),
+			i.Status.UpdatedReplicas, i.Status.Replicas, age(&i.CreationTimestamp), age(i.Status.CompletionTime))
+	}
+	return w.Flush()
+}
+
+func printRevisions(out io.Writer, revisions []v1.InplaceUpdateRevision) error {
+	w := newTabWriter(out)
+	fmt.Fprintln(w, "REVISION\tINPLACEUPDATE\tRESULT\tCHANGES\tUPDATED\tINITIATOR\tCHANGE-REF\tCOMPLETED")
+	for idx := range revisions {
+		spec := &revisions[idx].Spec
+		result := string(spec.Result)
+		if spec.RollbackOf != nil {
+			result += fmt.Sprintf(" (rollback of %d)", *spec.RollbackOf)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d/%d\t%s\t%s\t%s\n", spec.Revision, spec.InplaceUpdate, result, changes(spec.Containers),
+			spec.UpdatedReplicas, spec.Replicas, orNone(spec.Initiator), orNone(spec.ChangeRef), age(spec.CompletionTime))
+	}
+	return w.Flush()
+}
+
+type rollbackOptions struct {
+	*options
+	to         string
+	toRevision int64
+}
+
+func newRollbackCommand(o *options) *cobra.Command {
+	r := &rollbackOptions{options: o}
+	cmd := &cobra.Command{
+		Use:   "rollback DEPLOYMENT",
+		Short: "Create an InplaceUpdate restoring the images the deployment ran before the last update",
+		Long: `Create an InplaceUpdate restoring the images the deployment ran before the last update.
+
+The images are read from the state the controller recorded on the pods before updating them.
+With --to the images of the named InplaceUpdate are applied again instead.
+With --to-revision the update undoes the revision, see history, the controller takes the images
+from it. 0 undoes the latest revision.
+The rollback supersedes the updates of the deployment that are queued and have not started.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if cmd.Flags().Changed("to-revision") {
+				if r.to != "" {
+					return fmt.Errorf("--to and --to-revision are mutually exclusive")
+				}
+				return r.runToRevision(cmd.Context(), cmd, args[0])
+			}
+			return r.run(cmd.Context(), cmd, args[0])
+		},
+	}
+	cmd.Flags().StringVar(&r.to, "to", "", "Name of an earlier InplaceUpdate whose images are applied again")
+	cmd.Flags().Int64Var(&r.toRevision, "to-revision", 0, "Revision of the deployment to undo, 0 is the latest revision")
+	addWaitFlags(cmd, o)
+	addDryRunFlag(cmd, o)
+	addAuditFlags(cmd, o)
+	return cmd
+}
+
+func (r *rollbackOptions) run(ctx context.Context, cmd *cobra.Command, deployment string) error {
+	d, err := r.getDeployment(ctx, deployment)
+	if err != nil {
+		return err
+	}
+	var containers []v1.InplaceUpdateArgs
+	if r.to != "" {
+		to, err := r.getInplaceUpdate(ctx, r.to)
+		if err != nil {
+			return err
+		}
+		if to.Spec.TargetReference == nil || to.Spec.TargetReference.Name != deployment {
+			return fmt.Errorf("inplaceupdate %s does not target deployment %s", r.to, deployment)
+		}
+		containers = append(containers, to.Spec.Containers...)
+	} else {
+		pods, err := r.listPods(ctx, d)
+		if err != nil {
+			return err
+		}
+		containers = previousImages(pods)
+	}
+	var changed []v1.InplaceUpdateArgs
+	for _, c := range containers {
+		typ := containerType(&d.Spec.Template.Spec, c.Name)
+		if typ == "" {
+			continue
+		}
+		changed = append(changed, v1.InplaceUpdateArgs{Name: c.Name, Image: c.Image, Type: typ})
+	}
+	if len(changed) == 0 {
+		return fmt.Errorf("nothing to roll back for deployment %s", deployment)
+	}
+	i := newInplaceUpdate(r.namespace, "", deployment, changed)
+	i.GenerateName = deployment + "-rollback-"
+	i.Spec.Supersede = true
+	i.Spec.DryRun = r.dryRun
+	i.Spec.Reason = r.reason
+	i.Spec.ChangeRef = r.changeRef
+	if err := r.client.Create(ctx, i); err != nil {
+		return err
+	}
+	if r.output == "" {
+		fmt.Fprintf(cmd.OutOrStdout(), "inplaceupdate.%s/%s created, rolling back to %s\n", v1.GroupVersion.Group, i.Name, images(changed))
+	}
+	return r.finish(ctx, cmd.OutOrStdout(), i)
+}
+
+// runToRevision creates an update with spec.rollbackTo, the revision is looked up first
+// so a missing revision fails the command instead of the update
+func (r *rollbackOptions) runToRevision(ctx context.Context, cmd *cobra.Command, deployment string) error {
+	if r.toRevision < 0 {
+		return fmt.Errorf("--to-revision must not be negative")
+	}
+	revisions, err := inplaceupdate.ListRevisions(ctx, r.client, r.namespace, deployment)
+	if err != nil {
+		return err
+	}
+	revision := inplaceupdate.FindRevision(revisions, r.toRevision)
+	if revision == nil {
+		return fmt.Errorf("deployment %s has no revision %d", deployment, r.toRevision)
+	}
+	i := newInplaceUpdate(r.namespace, "", deployment, nil)
+	i.GenerateName = deployment + "-rollback-"
+	i.Spec.RollbackTo = &revision.Spec.Revision
+	i.Spec.Supersede = true
+	i.Spec.DryRun = r.dryRun
+	i.Spec.Reason = r.reason
+	i.Spec.ChangeRef = r.changeRef
+	if err := r.client.Create(ctx, i); err != nil {
+		return err
+	}
+	if r.output == "" {
+		fmt.Fprintf(cmd.OutOrStdout(), "inplaceupdate.%s/%s created, undoing revision %d\n", v1.GroupVersion.Group, i.Name, revision.Spec.Revision)
+	}
+	return r.finish(ctx, cmd.OutOrStdout(), i)
+}
+
+// changes formats the containers of a revision as name=from->to
+func changes(containers []v1.RevisionContainer) string {
+	var pairs []string
+	for _, c := range containers {
+		pairs = append(pairs, fmt.Sprintf("%s=%s->%s", c.Name, orNone(c.From), c.To))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
+
+// previousImages returns the images the containers ran before the latest in-place update,
+// as recorded in the state annotation of the pods
+func previousImages(pods []corev1.Pod) []v1.InplaceUpdateArgs {
+	refs := make([]*corev1.Pod, 0, len(pods))
+	for idx := range pods {
+		refs = append(refs, &pods[idx])
+	}
+	return inplaceupdate.PreviousImages(refs)
+}
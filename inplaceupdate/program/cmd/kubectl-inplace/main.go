@@ -0,0 +1,181 @@
+/*
+Copyright 2024 extreme.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kubectl-inplace is a kubectl plugin to drive InplaceUpdates, run it as `kubectl inplace`.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appsv1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+// Exit codes of the plugin, pipelines can tell the outcome of an update with --wait from them
+const (
+	exitFinished     = 0
+	exitCommandError = 1
+	exitFailed       = 2
+	exitSuperseded   = 3
+	exitTimeout      = 4
+)
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(appsv1.AddToScheme(scheme))
+}
+
+// exitError carries the exit code of a command
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+type options struct {
+	loadingRules *clientcmd.ClientConfigLoadingRules
+	overrides    *clientcmd.ConfigOverrides
+
+	output    string
+	wait      bool
+	dryRun    bool
+	timeout   time.Duration
+	interval  time.Duration
+	reason    string
+	changeRef string
+
+	namespace string
+	client    client.Client
+}
+
+func (o *options) complete() error {
+	config := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(o.loadingRules, o.overrides)
+	namespace, _, err := config.Namespace()
+	if err != nil {
+		return err
+	}
+	restConfig, err := config.ClientConfig()
+	if err != nil {
+		return err
+	}
+	c, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return err
+	}
+	o.namespace = namespace
+	o.client = c
+	return nil
+}
+
+func (o *options) validateOutput() error {
+	switch o.output {
+	case "", "json":
+		return nil
+	}
+	return fmt.Errorf("unsupported output format %q, only json is supported", o.output)
+}
+
+func newRootCommand() *cobra.Command {
+	o := &options{
+		loadingRules: clientcmd.NewDefaultClientConfigLoadingRules(),
+		overrides:    &clientcmd.ConfigOverrides{},
+	}
+	cmd := &cobra.Command{
+		Use:   "kubectl-inplace",
+		Short: "Update the images of Deployment pods in place through InplaceUpdates",
+		Long: `Update the images of Deployment pods in place through InplaceUpdates.
+
+With --wait the command waits for the update to complete, the exit code tells the outcome:
+  0  the update finished
+  1  the command failed
+  2  the update failed
+  3  the update was superseded by a later one
+  4  the update did not complete within --timeout`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// the shell completion scripts do not talk to the cluster
+			if cmd.HasParent() && cmd.Parent().Name() == "completion" {
+				return nil
+			}
+			if err := o.validateOutput(); err != nil {
+				return err
+			}
+			return o.complete()
+		},
+	}
+	flags := cmd.PersistentFlags()
+	flags.StringVar(&o.loadingRules.ExplicitPath, "kubeconfig", "", "Path to the kubeconfig file")
+	flags.StringVar(&o.overrides.CurrentContext, "context", "", "The kubeconfig context to use")
+	flags.StringVarP(&o.overrides.Context.Namespace, "namespace", "n", "", "The namespace of the update and its target")
+	flags.StringVarP(&o.output, "output", "o", "", "Output format, json or empty for human readable text")
+
+	cmd.AddCommand(
+		newSetImageCommand(o),
+		newStatusCommand(o),
+		newPauseCommand(o),
+		newResumeCommand(o),
+		newAbortCommand(o),
+		newApproveCommand(o),
+		newHistoryCommand(o),
+		newRollbackCommand(o),
+	)
+	return cmd
+}
+
+// addWaitFlags adds the flags of the commands able to wait for the update to complete
+func addWaitFlags(cmd *cobra.Command, o *options) {
+	cmd.Flags().BoolVar(&o.wait, "wait", false, "Wait for the update to complete, the exit code tells the outcome")
+	cmd.Flags().DurationVar(&o.timeout, "timeout", 30*time.Minute, "How long to wait for the update to complete")
+	cmd.Flags().DurationVar(&o.interval, "interval", 2*time.Second, "How often the update is checked")
+}
+
+// addDryRunFlag adds the flag of the commands able to create the update as a dry run
+func addDryRunFlag(cmd *cobra.Command, o *options) {
+	cmd.Flags().BoolVar(&o.dryRun, "dry-run", false, "Create the update as a dry run, wait for its plan and print it, no pod is touched until it is approved")
+}
+
+// addAuditFlags adds the flags telling why the update is made, they are recorded in its revision
+func addAuditFlags(cmd *cobra.Command, o *options) {
+	cmd.Flags().StringVar(&o.reason, "reason", "", "Why the update is made, set as spec.reason")
+	cmd.Flags().StringVar(&o.changeRef, "change-ref", "", "Change request or ticket behind the update, set as spec.changeRef")
+}
+
+func main() {
+	if err := newRootCommand().Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		code := exitCommandError
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			code = exitErr.code
+		}
+		os.Exit(code)
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2024 extreme.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+	"github.com/Forget-C/demo/inplaceupdate/program/internal/util/inplaceupdate"
+)
+
+func TestParseImages(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{args: []string{"nginx=nginx:1.25.4"}, want: "nginx=nginx:1.25.4"},
+		{args: []string{"nginx=nginx:1.25.4", "envoy=envoyproxy/envoy:v1.29.1"}, want: "nginx=nginx:1.25.4,envoy=envoyproxy/envoy:v1.29.1"},
+		{args: []string{"nginx"}, wantErr: true},
+		{args: []string{"=nginx:1.25.4"}, wantErr: true},
+		{args: []string{"nginx="}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseImages(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseImages(%v) expected error %v, got %v", tt.args, tt.wantErr, err)
+			continue
+		}
+		if images(got) != tt.want {
+			t.Errorf("parseImages(%v) = %s, want %s", tt.args, images(got), tt.want)
+		}
+	}
+}
+
+func TestPodProgress(t *testing.T) {
+	i := &v1.InplaceUpdate{Spec: v1.InplaceUpdateSpec{Containers: []v1.InplaceUpdateArgs{
+		{Name: "nginx", Image: "nginx:1.25.4", Type: v1.ContainerTypeContainer},
+		{Name: "envoy", Image: "envoyproxy/envoy:v1.29.1", Type: v1.ContainerTypeInitContainer},
+	}}}
+	pod := func(name, nginxImage, runningImage string, ready bool) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx", Image: nginxImage}}},
+			Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "nginx", Image: runningImage, Ready: ready},
+			}},
+		}
+	}
+	rows := podProgress(i, []corev1.Pod{
+		pod("pending", "nginx:1.25.3", "docker.io/library/nginx:1.25.3", true),
+		pod("restarting", "nginx:1.25.4", "docker.io/library/nginx:1.25.3", true),
+		pod("updated", "nginx:1.25.4", "docker.io/library/nginx:1.25.4", true),
+	})
+	want := []string{
+		"pending/nginx=" + statePending, "pending/envoy=" + stateMissing,
+		"restarting/nginx=" + stateRestarting, "restarting/envoy=" + stateMissing,
+		"updated/nginx=" + stateUpdated, "updated/envoy=" + stateMissing,
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("expected %d rows, got %d", len(want), len(rows))
+	}
+	for idx, row := range rows {
+		if got := row.Pod + "/" + row.Container + "=" + row.State; got != want[idx] {
+			t.Errorf("row %d = %s, want %s", idx, got, want[idx])
+		}
+	}
+}
+
+func TestPreviousImages(t *testing.T) {
+	state := func(at int64, image string) string {
+		data, _ := json.Marshal(inplaceupdate.UpdateState{
+			UpdateTimestamp:       metav1.Unix(at, 0),
+			LastContainerStatuses: map[string]*corev1.ContainerStatus{"nginx": {Name: "nginx", Image: image}},
+		})
+		return string(data)
+	}
+	pods := []corev1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "a", Annotations: map[string]string{inplaceupdate.AnnotationStateKey: state(100, "nginx:1.25.2")}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "b", Annotations: map[string]string{inplaceupdate.AnnotationStateKey: state(200, "nginx:1.25.3")}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "c"}},
+	}
+	if got := images(previousImages(pods)); got != "nginx=nginx:1.25.3" {
+		t.Errorf("expected the images before the latest update, got %s", got)
+	}
+	if got := previousImages(pods[2:]); got != nil {
+		t.Errorf("expected no images without state, got %v", got)
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	tests := map[string]int{
+		v1.InplaceUpdatePhaseFinished:   exitFinished,
+		v1.InplaceUpdatePhaseFailed:     exitFailed,
+		v1.InplaceUpdatePhaseSuperseded: exitSuperseded,
+		v1.InplaceUpdatePhasePlanned:    exitFinished,
+	}
+	for phase, code := range tests {
+		err := outcome(&v1.InplaceUpdate{Status: v1.InplaceUpdateStatus{Phase: v1.InplaceUpdatePhase(phase)}})
+		got := exitFinished
+		if exitErr, ok := err.(*exitError); ok {
+			got = exitErr.code
+		}
+		if got != code {
+			t.Errorf("phase %s: expected exit code %d, got %d", phase, code, got)
+		}
+	}
+}
+
+func TestPlanned(t *testing.T) {
+	plan := &v1.InplaceUpdatePlan{Waves: 1}
+	tests := []struct {
+		name   string
+		i      v1.InplaceUpdate
+		expect bool
+	}{
+		{name: "planned", i: v1.InplaceUpdate{Spec: v1.InplaceUpdateSpec{DryRun: true},
+			Status: v1.InplaceUpdateStatus{Phase: v1.InplaceUpdatePhasePlanned, Plan: plan}}, expect: true},
+		{name: "not planned yet", i: v1.InplaceUpdate{Spec: v1.InplaceUpdateSpec{DryRun: true}}},
+		{name: "approved", i: v1.InplaceUpdate{Status: v1.InplaceUpdateStatus{Phase: v1.InplaceUpdatePhasePlanned, Plan: plan}}},
+		{name: "stale plan", i: v1.InplaceUpdate{ObjectMeta: metav1.ObjectMeta{Generation: 2}, Spec: v1.InplaceUpdateSpec{DryRun: true},
+			Status: v1.InplaceUpdateStatus{Phase: v1.InplaceUpdatePhasePlanned, Plan: plan, ObservedGeneration: 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := planned(&tt.i); got != tt.expect {
+				t.Errorf("expected %v, got %v", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestPrintRevisions(t *testing.T) {
+	rollbackOf := int64(1)
+	revisions := []v1.InplaceUpdateRevision{
+		{Spec: v1.InplaceUpdateRevisionSpec{Revision: 1, InplaceUpdate: "nginx-abcde", Result: v1.InplaceUpdatePhaseFinished,
+			Initiator: "alice", ChangeRef: "CHG-1234", Replicas: 3, UpdatedReplicas: 3,
+			Containers: []v1.RevisionContainer{{Name: "nginx", From: "nginx:1.25.3", To: "nginx:1.25.4"}}}},
+		{Spec: v1.InplaceUpdateRevisionSpec{Revision: 2, InplaceUpdate: "nginx-rollback-fghij", Result: v1.InplaceUpdatePhaseFailed,
+			Replicas: 3, UpdatedReplicas: 1, RollbackOf: &rollbackOf,
+			Containers: []v1.RevisionContainer{{Name: "nginx", To: "nginx:1.25.3"}}}},
+	}
+	out := &strings.Builder{}
+	if err := printRevisions(out, revisions); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"REVISION INPLACEUPDATE RESULT CHANGES UPDATED INITIATOR CHANGE-REF COMPLETED",
+		"1 nginx-abcde Finished nginx=nginx:1.25.3->nginx:1.25.4 3/3 alice CHG-1234 <none>",
+		"2 nginx-rollback-fghij Failed (rollback of 1) nginx=<none>->nginx:1.25.3 1/3 <none> <none> <none>",
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("expected %d lines, got\n%s", len(want), out.String())
+	}
+	for idx, line := range lines {
+		if got := strings.Join(strings.Fields(line), " "); got != want[idx] {
+			t.Errorf("line %d = %q, want %q", idx, got, want[idx])
+		}
+	}
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2024 extreme.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+func printJSON(out io.Writer, obj interface{}) error {
+	data, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, string(data))
+	return err
+}
+
+func newTabWriter(out io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+}
+
+// age formats a time like kubectl does, <none> if it is not set
+func age(t *metav1.Time) string {
+	if t == nil || t.IsZero() {
+		return "<none>"
+	}
+	return duration.HumanDuration(time.Since(t.Time))
+}
+
+func images(containers []v1.InplaceUpdateArgs) string {
+	var pairs []string
+	for _, c := range containers {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", c.Name, c.Image))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func phase(i *v1.InplaceUpdate) string {
+	if i.Status.Phase == "" {
+		return v1.InplaceUpdatePhasePending
+	}
+	return string(i.Status.Phase)
+}
+
+// summary is a one line description of the state of the update
+func summary(i *v1.InplaceUpdate) string {
+	s := fmt.Sprintf("inplaceupdate %s: %s, %d/%d pods updated", i.Name, phase(i), i.Status.UpdatedReplicas, i.Status.Replicas)
+	if n := len(i.Status.Waves); n != 0 {
+		wave := i.Status.Waves[n-1]
+		s += fmt.Sprintf(", wave %d", wave.Number)
+		if a := wave.Analysis; a != nil && a.Phase != v1.AnalysisPhaseSuccessful {
+			s += fmt.Sprintf(", analysis %s with %d successful, %d failed and %d inconclusive rounds", a.Phase, a.Successful, a.Failed, a.Inconclusive)
+		}
+	}
+	if i.Status.SurgeReplicas != 0 {
+		s += fmt.Sprintf(", %d surge pods", i.Status.SurgeReplicas)
+	}
+	if i.Status.RecreatedReplicas != 0 {
+		s += fmt.Sprintf(", %d pods recreated", i.Status.RecreatedReplicas)
+	}
+	if i.Status.RevertedReplicas != 0 {
+		s += fmt.Sprintf(", %d pods reverted", i.Status.RevertedReplicas)
+	}
+	if i.Status.BlockedBy != "" {
+		s += fmt.Sprintf(", queued at %d behind %s", i.Status.QueuePosition, i.Status.BlockedBy)
+	}
+	if i.Spec.Paused {
+		s += ", paused"
+	}
+	if i.Spec.DryRun {
+		s += ", dry run"
+	}
+	if i.Status.NextScheduleTime != nil {
+		s += fmt.Sprintf(", waiting for the schedule until %s", i.Status.NextScheduleTime.Format(time.RFC3339))
+	}
+	return s
+}
+
+// printPlan prints the blockers and the waves of the plan of a dry run
+func printPlan(out io.Writer, plan *v1.InplaceUpdatePlan) error {
+	fmt.Fprintf(out, "plan of replicaset %s: %d waves of up to %d pods", plan.ReplicaSet, plan.Waves, plan.MaxUnavailable+plan.MaxSurge)
+	if plan.MaxSurge != 0 {
+		fmt.Fprintf(out, ", %d surge pods", plan.MaxSurge)
+	}
+	fmt.Fprintln(out)
+	for _, blocker := range plan.Blockers {
+		fmt.Fprintf(out, "Blocked: %s\n", blocker)
+	}
+	fmt.Fprintln(out)
+	w := newTabWriter(out)
+	fmt.Fprintln(w, "WAVE\tPOD\tCONTAINER\tFROM\tTO\tDRY-RUN")
+	for _, pod := range plan.Pods {
+		if len(pod.Changes) == 0 {
+			fmt.Fprintf(w, "-\t%s\t\t\t\tUpToDate\n", pod.Name)
+			continue
+		}
+		result := "Accepted"
+		if pod.Recreate {
+			result = "Recreate: " + pod.Message
+		} else if !pod.Accepted {
+			result = "Rejected: " + pod.Message
+		}
+		for _, change := range pod.Changes {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", pod.Wave, pod.Name, change.Name, change.From, change.To, result)
+		}
+	}
+	return w.Flush()
+}
+
+// printStatus prints the summary, the conditions that need attention and the per pod progress,
+// or the plan of a dry run
+func printStatus(out io.Writer, i *v1.InplaceUpdate, rows []containerProgress) error {
+	fmt.Fprintln(out, summary(i))
+	if i.Spec.DryRun && i.Status.Plan != nil {
+		return printPlan(out, i.Status.Plan)
+	}
+	for _, c := range i.Status.Conditions {
+		attention := (c.Type == v1.InplaceUpdateConditionBlocked || c.Type == v1.InplaceUpdateConditionDegraded) && c.Status == metav1.ConditionTrue
+		if attention {
+			fmt.Fprintf(out, "%s: %s %s\n", c.Type, c.Reason, c.Message)
+		}
+	}
+	fmt.Fprintln(out)
+	w := newTabWriter(out)
+	fmt.Fprintln(w, "POD\tCONTAINER\tIMAGE\tTARGET\tREADY\tRESTARTS\tSTATE")
+	for _, row := range rows {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\t%d\t%s\n", row.Pod, row.Container, row.Image, row.TargetImage, row.Ready, row.RestartCount, row.State)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2024 extreme.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+type setImageOptions struct {
+	*options
+	name           string
+	maxUnavailable string
+	maxSurge       string
+	ordering       string
+	updateStrategy string
+	hpaPolicy      string
+	vpaPolicy      string
+	order          []string
+	atomic         bool
+	failurePolicy  string
+	queue          bool
+	supersede      bool
+}
+
+func newSetImageCommand(o *options) *cobra.Command {
+	s := &setImageOptions{options: o}
+	cmd := &cobra.Command{
+		Use:   "set-image DEPLOYMENT CONTAINER=IMAGE [CONTAINER=IMAGE...]",
+		Short: "Create an InplaceUpdate changing the images of the deployment pods in place",
+		Example: `  kubectl inplace set-image nginx nginx=nginx:1.25.4
+  kubectl inplace set-image nginx nginx=nginx:1.25.4 envoy=envoyproxy/envoy:v1.29.1 --max-unavailable 25% --wait
+  kubectl inplace set-image nginx nginx=nginx:1.25.4 --max-surge 1 --max-unavailable 0
+  kubectl inplace set-image nginx nginx=nginx:1.25.4 envoy=envoyproxy/envoy:v1.29.1 --order envoy,nginx --atomic
+  kubectl inplace set-image nginx nginx=nginx:1.25.4 --dry-run
+  kubectl inplace set-image nginx nginx=nginx:1.25.4 --change-ref CHG-1234 --reason "fix CVE-2024-1234"`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return s.run(cmd.Context(), cmd, args[0], args[1:])
+		},
+	}
+	cmd.Flags().StringVar(&s.name, "name", "", "Name of the InplaceUpdate, generated from the deployment name if empty")
+	cmd.Flags().StringVar(&s.maxUnavailable, "max-unavailable", "", "Maximum number or percentage of pods unavailable during the update")
+	cmd.Flags().StringVar(&s.maxSurge, "max-surge", "", "Maximum number or percentage of extra pods running the new images while the pods are restarted")
+	cmd.Flags().StringVar(&s.ordering, "ordering", "", "Order of the pods into waves, Spread or ZoneByZone")
+	cmd.Flags().StringVar(&s.updateStrategy, "update-strategy", "", "InPlace, or InPlaceIfPossible to recreate the pods that can not be updated in place")
+	cmd.Flags().StringVar(&s.hpaPolicy, "hpa", "", "Hold or NoScaleDown the HorizontalPodAutoscaler of the deployment during the update")
+	cmd.Flags().StringVar(&s.vpaPolicy, "vpa", "", "Off to turn the VerticalPodAutoscaler of the deployment off during the update")
+	cmd.Flags().StringSliceVar(&s.order, "order", nil, "Containers updated one after another in a pod, each once the previous one runs its new image and is ready")
+	cmd.Flags().BoolVar(&s.atomic, "atomic", false, "Revert all the containers of a pod when one of them does not come up on its new image")
+	cmd.Flags().StringVar(&s.failurePolicy, "failure-policy", "", "Ignore or Abort")
+	cmd.Flags().BoolVar(&s.queue, "queue", false, "Wait for the updates already running on the deployment instead of being rejected")
+	cmd.Flags().BoolVar(&s.supersede, "supersede", false, "Skip the updates of the deployment queued before this one")
+	addWaitFlags(cmd, o)
+	addDryRunFlag(cmd, o)
+	addAuditFlags(cmd, o)
+	return cmd
+}
+
+func (s *setImageOptions) run(ctx context.Context, cmd *cobra.Command, deployment string, pairs []string) error {
+	d, err := s.getDeployment(ctx, deployment)
+	if err != nil {
+		return err
+	}
+	containers, err := parseImages(pairs)
+	if err != nil {
+		return err
+	}
+	for idx := range containers {
+		typ := containerType(&d.Spec.Template.Spec, containers[idx].Name)
+		if typ == "" {
+			return fmt.Errorf("deployment %s has no container named %s", deployment, containers[idx].Name)
+		}
+		containers[idx].Type = typ
+	}
+	if err := orderContainers(containers, s.order); err != nil {
+		return err
+	}
+	i := newInplaceUpdate(s.namespace, s.name, deployment, containers)
+	if s.maxUnavailable != "" {
+		maxUnavailable := intstr.Parse(s.maxUnavailable)
+		i.Spec.MaxUnavailable = &maxUnavailable
+	}
+	if s.maxSurge != "" {
+		maxSurge := intstr.Parse(s.maxSurge)
+		i.Spec.MaxSurge = &maxSurge
+	}
+	if s.ordering != "" {
+		i.Spec.Ordering = &v1.WaveOrdering{Policy: v1.WaveOrderingPolicy(s.ordering)}
+	}
+	i.Spec.UpdateStrategy = v1.UpdateStrategyType(s.updateStrategy)
+	if s.hpaPolicy != "" || s.vpaPolicy != "" {
+		i.Spec.Autoscalers = &v1.AutoscalerCoordination{
+			HorizontalPodAutoscaler: v1.HorizontalPodAutoscalerPolicy(s.hpaPolicy),
+			VerticalPodAutoscaler:   v1.VerticalPodAutoscalerPolicy(s.vpaPolicy),
+		}
+	}
+	i.Spec.Atomic = s.atomic
+	i.Spec.FailurePolicy = v1.FailurePolicyType(s.failurePolicy)
+	if s.queue {
+		i.Spec.ConcurrencyPolicy = v1.ConcurrencyPolicyQueue
+	}
+	i.Spec.Supersede = s.supersede
+	i.Spec.DryRun = s.dryRun
+	i.Spec.Reason = s.reason
+	i.Spec.ChangeRef = s.changeRef
+	if err := s.client.Create(ctx, i); err != nil {
+		return err
+	}
+	if s.output == "" {
+		fmt.Fprintf(cmd.OutOrStdout(), "inplaceupdate.%s/%s created\n", v1.GroupVersion.Group, i.Name)
+	}
+	return s.finish(ctx, cmd.OutOrStdout(), i)
+}
+
+// orderContainers makes each container of the order depend on the one before it
+func orderContainers(containers []v1.InplaceUpdateArgs, order []string) error {
+	for idx, name := range order {
+		found := false
+		for j := range containers {
+			if containers[j].Name != name {
+				continue
+			}
+			found = true
+			if idx > 0 {
+				containers[j].DependsOn = []string{order[idx-1]}
+			}
+		}
+		if !found {
+			return fmt.Errorf("container %s of the order has no image set", name)
+		}
+	}
+	return nil
+}
+
+// parseImages parses CONTAINER=IMAGE pairs
+func parseImages(pairs []string) ([]v1.InplaceUpdateArgs, error) {
+	var containers []v1.InplaceUpdateArgs
+	for _, pair := range pairs {
+		name, image, found := strings.Cut(pair, "=")
+		if !found || name == "" || image == "" {
+			return nil, fmt.Errorf("invalid argument %q, expected CONTAINER=IMAGE", pair)
+		}
+		containers = append(containers, v1.InplaceUpdateArgs{Name: name, Image: image})
+	}
+	return containers, nil
+}
+
+// newInplaceUpdate builds an update of the deployment, its name is generated if name is empty
+func newInplaceUpdate(namespace, name, deployment string, containers []v1.InplaceUpdateArgs) *v1.InplaceUpdate {
+	i := &v1.InplaceUpdate{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: v1.InplaceUpdateSpec{
+			TargetReference: &v1.TargetReference{
+				TypeMeta: metav1.TypeMeta{APIVersion: v1.TargetAPIVersionDeployment, Kind: v1.TargetKindDeployment},
+				Name:     deployment,
+			},
+			Containers: containers,
+		},
+	}
+	if name == "" {
+		i.GenerateName = deployment + "-"
+	}
+	return i
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2024 extreme.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+	"github.com/Forget-C/demo/inplaceupdate/program/internal/util/inplaceupdate"
+)
+
+type statusOptions struct {
+	*options
+	watch    bool
+	interval time.Duration
+}
+
+// statusReport is the json output of the status command
+type statusReport struct {
+	InplaceUpdate *v1.InplaceUpdate   `json:"inplaceUpdate"`
+	Pods          []containerProgress `json:"pods"`
+}
+
+func newStatusCommand(o *options) *cobra.Command {
+	s := &statusOptions{options: o}
+	cmd := &cobra.Command{
+		Use:   "status NAME",
+		Short: "Show the per pod progress of an InplaceUpdate",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return s.run(cmd.Context(), cmd.OutOrStdout(), args[0])
+		},
+	}
+	cmd.Flags().BoolVarP(&s.watch, "watch", "w", false, "Refresh the progress until the update completes")
+	cmd.Flags().DurationVar(&s.interval, "interval", 2*time.Second, "How often the progress is refreshed with --watch")
+	return cmd
+}
+
+func (s *statusOptions) run(ctx context.Context, out io.Writer, name string) error {
+	for {
+		i, err := s.getInplaceUpdate(ctx, name)
+		if err != nil {
+			return err
+		}
+		rows, err := s.progress(ctx, i)
+		if err != nil {
+			return err
+		}
+		if s.output == "json" {
+			err = printJSON(out, statusReport{InplaceUpdate: i, Pods: rows})
+		} else {
+			if s.watch {
+				// redraw the table in place
+				fmt.Fprint(out, "\033[H\033[2J")
+			}
+			err = printStatus(out, i, rows)
+		}
+		if err != nil {
+			return err
+		}
+		if !s.watch || inplaceupdate.IsCompleted(i) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+func (s *statusOptions) progress(ctx context.Context, i *v1.InplaceUpdate) ([]containerProgress, error) {
+	if i.Spec.TargetReference == nil {
+		return nil, nil
+	}
+	d, err := s.getDeployment(ctx, i.Spec.TargetReference.Name)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := s.listPods(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+	return podProgress(i, pods), nil
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2024 extreme.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/distribution/reference"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+	"github.com/Forget-C/demo/inplaceupdate/program/internal/util"
+	"github.com/Forget-C/demo/inplaceupdate/program/internal/util/inplaceupdate"
+)
+
+func (o *options) getDeployment(ctx context.Context, name string) (*appsv1.Deployment, error) {
+	d := &appsv1.Deployment{}
+	if err := o.client.Get(ctx, types.NamespacedName{Namespace: o.namespace, Name: name}, d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (o *options) getInplaceUpdate(ctx context.Context, name string) (*v1.InplaceUpdate, error) {
+	i := &v1.InplaceUpdate{}
+	if err := o.client.Get(ctx, types.NamespacedName{Namespace: o.namespace, Name: name}, i); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// listInplaceUpdates returns the updates of the deployment, oldest first
+func (o *options) listInplaceUpdates(ctx context.Context, deployment string) ([]v1.InplaceUpdate, error) {
+	list := &v1.InplaceUpdateList{}
+	if err := o.client.List(ctx, list, client.InNamespace(o.namespace)); err != nil {
+		return nil, err
+	}
+	var updates []v1.InplaceUpdate
+	for _, i := range list.Items {
+		if i.Spec.TargetReference != nil && i.Spec.TargetReference.Name == deployment {
+			updates = append(updates, i)
+		}
+	}
+	sort.SliceStable(updates, func(a, b int) bool {
+		return updates[a].CreationTimestamp.Before(&updates[b].CreationTimestamp)
+	})
+	return updates, nil
+}
+
+// listPods returns the pods owned by the replicasets of the deployment
+func (o *options) listPods(ctx context.Context, d *appsv1.Deployment) ([]corev1.Pod, error) {
+	selector, err := metav1.LabelSelectorAsSelector(d.Spec.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("deployment %s/%s has invalid selector: %v", d.Namespace, d.Name, err)
+	}
+	rsList := &appsv1.ReplicaSetList{}
+	if err := o.client.List(ctx, rsList, client.InNamespace(d.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	owned := make(map[types.UID]struct{})
+	for i := range rsList.Items {
+		if metav1.IsControlledBy(&rsList.Items[i], d) {
+			owned[rsList.Items[i].UID] = struct{}{}
+		}
+	}
+	podList := &corev1.PodList{}
+	if err := o.client.List(ctx, podList, client.InNamespace(d.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	var pods []corev1.Pod
+	for _, pod := range podList.Items {
+		if ref := metav1.GetControllerOf(&pod); ref != nil {
+			if _, exist := owned[ref.UID]; exist {
+				pods = append(pods, pod)
+			}
+		}
+	}
+	sort.Slice(pods, func(a, b int) bool { return pods[a].Name < pods[b].Name })
+	return pods, nil
+}
+
+// containerType returns the type of the named container in the pod template, empty if it does not exist
+func containerType(spec *corev1.PodSpec, name string) v1.ContainerType {
+	if util.FindContainer(name, *spec) != nil {
+		return v1.ContainerTypeContainer
+	}
+	if util.FindInitContainer(name, *spec) != nil {
+		return v1.ContainerTypeInitContainer
+	}
+	return ""
+}
+
+// Progress states of a container
+const (
+	statePending    = "Pending"
+	stateRestarting = "Restarting"
+	stateUpdated    = "Updated"
+	stateMissing    = "Missing"
+)
+
+type containerProgress struct {
+	Pod          string `json:"pod"`
+	Container    string `json:"container"`
+	Image        string `json:"image"`
+	RunningImage string `json:"runningImage,omitempty"`
+	TargetImage  string `json:"targetImage"`
+	Ready        bool   `json:"ready"`
+	RestartCount int32  `json:"restartCount"`
+	State        string `json:"state"`
+}
+
+// podProgress returns the progress of every container of the update in every pod
+func podProgress(i *v1.InplaceUpdate, pods []corev1.Pod) []containerProgress {
+	var rows []containerProgress
+	for _, pod := range pods {
+		statuses := util.AllContainerStatuses(pod.Status)
+		for _, target := range inplaceupdate.UpdateContainers(i) {
+			row := containerProgress{Pod: pod.Name, Container: target.Name, TargetImage: target.Image, State: stateMissing}
+			container := util.FindContainer(target.Name, pod.Spec)
+			if target.Type == v1.ContainerTypeInitContainer {
+				container = util.FindInitContainer(target.Name, pod.Spec)
+			}
+			if container == nil {
+				rows = append(rows, row)
+				continue
+			}
+			row.Image = container.Image
+			if status := util.FindContainerStatus(target.Name, statuses); status != nil {
+				row.RunningImage = status.Image
+				row.Ready = status.Ready
+				row.RestartCount = status.RestartCount
+			}
+			switch {
+			case !sameImage(container.Image, target.Image):
+				row.State = statePending
+			case row.Ready && sameImage(row.RunningImage, target.Image):
+				row.State = stateUpdated
+			default:
+				row.State = stateRestarting
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// sameImage compares two image references after normalization, so nginx:1.25 equals docker.io/library/nginx:1.25
+func sameImage(a, b string) bool {
+	if a == b {
+		return true
+	}
+	na, errA := reference.ParseNormalizedNamed(a)
+	nb, errB := reference.ParseNormalizedNamed(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return reference.TagNameOnly(na).String() == reference.TagNameOnly(nb).String()
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2024 extreme.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+	"github.com/Forget-C/demo/inplaceupdate/program/internal/util/inplaceupdate"
+)
+
+// waitForCompletion polls the update until it completes, or is planned if it is a dry run, or --timeout passes.
+// The progress is printed to out as it changes unless the output is json, the outcome is returned as an exitError.
+func (o *options) waitForCompletion(ctx context.Context, out io.Writer, name string) (*v1.InplaceUpdate, error) {
+	var latest *v1.InplaceUpdate
+	var last string
+	err := wait.PollUntilContextTimeout(ctx, o.interval, o.timeout, true, func(ctx context.Context) (bool, error) {
+		i, err := o.getInplaceUpdate(ctx, name)
+		if err != nil {
+			return false, err
+		}
+		latest = i
+		if o.output == "" {
+			if s := summary(i); s != last {
+				fmt.Fprintln(out, s)
+				last = s
+			}
+		}
+		return inplaceupdate.IsCompleted(i) || planned(i), nil
+	})
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return latest, &exitError{code: exitTimeout, err: fmt.Errorf("inplaceupdate %s did not complete within %s", name, o.timeout)}
+		}
+		return latest, err
+	}
+	return latest, outcome(latest)
+}
+
+// outcome maps the phase of a completed update to the exit code of the plugin
+func outcome(i *v1.InplaceUpdate) error {
+	switch i.Status.Phase {
+	case v1.InplaceUpdatePhaseFailed:
+		message := ""
+		if c := meta.FindStatusCondition(i.Status.Conditions, v1.InplaceUpdateConditionDegraded); c != nil {
+			message = fmt.Sprintf(": %s %s", c.Reason, c.Message)
+		}
+		return &exitError{code: exitFailed, err: fmt.Errorf("inplaceupdate %s failed%s", i.Name, message)}
+	case v1.InplaceUpdatePhaseSuperseded:
+		return &exitError{code: exitSuperseded, err: fmt.Errorf("inplaceupdate %s was superseded", i.Name)}
+	}
+	return nil
+}
+
+// planned reports whether the plan of a dry run is up to date with its spec
+func planned(i *v1.InplaceUpdate) bool {
+	return i.Spec.DryRun && i.Status.Phase == v1.InplaceUpdatePhasePlanned && i.Status.Plan != nil &&
+		i.Status.ObservedGeneration == i.Generation
+}
+
+// finish waits for the update if --wait or --dry-run is set, then prints it as json if asked to,
+// or the plan of a dry run
+func (o *options) finish(ctx context.Context, out io.Writer, i *v1.InplaceUpdate) error {
+	var err error
+	if o.wait || (o.dryRun && i.Spec.DryRun) {
+		var latest *v1.InplaceUpdate
+		latest, err = o.waitForCompletion(ctx, out, i.Name)
+		if latest != nil {
+			i = latest
+		}
+	}
+	switch {
+	case o.output == "json":
+		if printErr := printJSON(out, i); printErr != nil {
+			return printErr
+		}
+	case err == nil && planned(i):
+		if printErr := printPlan(out, i.Status.Plan); printErr != nil {
+			return printErr
+		}
+		fmt.Fprintf(out, "\nrun `kubectl inplace approve %s` to start the update\n", i.Name)
+	}
+	return err
+}
@@ -20,22 +20,28 @@ import (
 	"crypto/tls"
 	"flag"
 	"os"
+	// the time zones of spec.schedule are resolved without the zoneinfo of the base image
+	_ "time/tzdata"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	appsv1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+	appsv1beta2 "github.com/Forget-C/demo/inplaceupdate/program/api/v1beta2"
 	"github.com/Forget-C/demo/inplaceupdate/program/internal/controller"
+	"github.com/Forget-C/demo/inplaceupdate/program/internal/util/inplaceupdate"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -48,6 +54,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(appsv1.AddToScheme(scheme))
+	utilruntime.Must(appsv1beta2.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -96,6 +103,9 @@ func main() {
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme: scheme,
+		// secrets referenced by analysis metrics and notification sinks are read from the API server
+		// rather than through a cluster wide informer
+		Client: client.Options{Cache: &client.CacheOptions{DisableFor: []client.Object{&corev1.Secret{}}}},
 		Metrics: metricsserver.Options{
 			BindAddress:   metricsAddr,
 			SecureServing: secureMetrics,
@@ -123,8 +133,10 @@ func main() {
 	}
 
 	if err = (&controller.InplaceUpdateReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("inplaceupdate-controller"),
+		Notifier: inplaceupdate.NewNotifier(mgr.GetClient()),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "InplaceUpdate")
 		os.Exit(1)
@@ -134,6 +146,22 @@ func main() {
 			setupLog.Error(err, "unable to create webhook", "webhook", "InplaceUpdate")
 			os.Exit(1)
 		}
+		if err = (&appsv1.ClusterInplaceUpdate{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ClusterInplaceUpdate")
+			os.Exit(1)
+		}
+		// serves /convert for v1 <-> v1beta2, the hub is v1beta2
+		if err = (&appsv1beta2.InplaceUpdate{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create conversion webhook", "webhook", "InplaceUpdate")
+			os.Exit(1)
+		}
+	}
+	if err = (&controller.ClusterInplaceUpdateReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterInplaceUpdate")
+		os.Exit(1)
 	}
 	//+kubebuilder:scaffold:builder
 
@@ -0,0 +1,55 @@
+/*
+Copyright 2024 extreme.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+	"github.com/Forget-C/demo/inplaceupdate/program/internal/util/inplaceupdate"
+)
+
+// ClusterInplaceUpdateReconciler reconciles a ClusterInplaceUpdate object
+type ClusterInplaceUpdateReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=apps.demo.cyisme.top,resources=clusterinplaceupdates,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps.demo.cyisme.top,resources=clusterinplaceupdates/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=apps.demo.cyisme.top,resources=clusterinplaceupdates/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch
+
+// Reconcile selects the workloads of a ClusterInplaceUpdate and fans out
+// one child InplaceUpdate per workload.
+func (r *ClusterInplaceUpdateReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	reconcile := inplaceupdate.NewRealClusterControl(r.Client, r.Scheme)
+	return reconcile.Reconcile(ctx, req.Name)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ClusterInplaceUpdateReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1.ClusterInplaceUpdate{}).
+		Owns(&v1.InplaceUpdate{}).
+		Complete(r)
+}
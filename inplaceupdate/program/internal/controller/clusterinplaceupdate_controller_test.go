@@ -0,0 +1,121 @@
+/*
+Copyright 2024 extreme.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+	"github.com/Forget-C/demo/inplaceupdate/program/internal/util/inplaceupdate"
+)
+
+var _ = Describe("ClusterInplaceUpdate Controller", func() {
+	Context("When reconciling a resource", func() {
+		const resourceName = "test-cluster-resource"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{Name: resourceName}
+
+		newDeployment := func(name string) *appsv1.Deployment {
+			labels := map[string]string{"app": name}
+			return &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels},
+				Spec: appsv1.DeploymentSpec{
+					Replicas: ptr.To[int32](1),
+					Selector: &metav1.LabelSelector{MatchLabels: labels},
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: labels},
+						Spec: corev1.PodSpec{Containers: []corev1.Container{
+							{Name: "app", Image: "app:v1"},
+							{Name: "envoy", Image: "envoyproxy/envoy:v1.28.0"},
+						}},
+					},
+				},
+			}
+		}
+
+		BeforeEach(func() {
+			By("creating the workloads and the custom resource for the Kind ClusterInplaceUpdate")
+			for _, name := range []string{"fleet-a", "fleet-b"} {
+				Expect(k8sClient.Create(ctx, newDeployment(name))).To(Succeed())
+			}
+			resource := &v1.ClusterInplaceUpdate{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName},
+				Spec: v1.ClusterInplaceUpdateSpec{
+					WorkloadSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+						{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"fleet-a", "fleet-b"}},
+					}},
+					Containers: []v1.ClusterInplaceUpdateContainer{
+						{ImagePattern: "envoyproxy/envoy:*", Image: "envoyproxy/envoy:v1.29.1"},
+					},
+					MaxConcurrency: ptr.To[int32](1),
+				},
+			}
+			Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			resource := &v1.ClusterInplaceUpdate{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			By("Cleanup the specific resource instance ClusterInplaceUpdate")
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+			Expect(k8sClient.DeleteAllOf(ctx, &v1.InplaceUpdate{}, client.InNamespace("default"),
+				client.MatchingLabels{inplaceupdate.LabelClusterInplaceUpdateKey: resourceName})).To(Succeed())
+			for _, name := range []string{"fleet-a", "fleet-b"} {
+				Expect(k8sClient.Delete(ctx, newDeployment(name))).To(Succeed())
+			}
+		})
+
+		It("should fan out child updates within the concurrency limit", func() {
+			controllerReconciler := &ClusterInplaceUpdateReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			children := &v1.InplaceUpdateList{}
+			Expect(k8sClient.List(ctx, children,
+				client.MatchingLabels{inplaceupdate.LabelClusterInplaceUpdateKey: resourceName})).To(Succeed())
+			Expect(children.Items).To(HaveLen(1))
+			Expect(children.Items[0].Spec.Containers).To(Equal([]v1.InplaceUpdateArgs{
+				{Name: "envoy", Image: "envoyproxy/envoy:v1.29.1", Type: v1.ContainerTypeContainer},
+			}))
+
+			resource := &v1.ClusterInplaceUpdate{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Workloads).To(Equal(int32(2)))
+			Expect(resource.Status.Running).To(Equal(int32(1)))
+			Expect(resource.Status.Pending).To(Equal(int32(1)))
+			Expect(resource.Status.Phase).To(Equal(v1.InplaceUpdatePhase(v1.InplaceUpdatePhaseRunning)))
+		})
+	})
+})
@@ -22,10 +22,12 @@ import (
 
 	errors2 "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
 	"github.com/Forget-C/demo/inplaceupdate/program/internal/util/inplaceupdate"
@@ -36,12 +38,25 @@ const defaultRequeueAfter = time.Second * 10
 // InplaceUpdateReconciler reconciles a InplaceUpdate object
 type InplaceUpdateReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	Notifier *inplaceupdate.Notifier
 }
 
 //+kubebuilder:rbac:groups=apps.demo.cyisme.top,resources=inplaceupdates,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps.demo.cyisme.top,resources=inplaceupdates/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=apps.demo.cyisme.top,resources=inplaceupdates/finalizers,verbs=update
+//+kubebuilder:rbac:groups=apps.demo.cyisme.top,resources=inplaceupdaterevisions,verbs=get;list;watch;create
+//+kubebuilder:rbac:groups=apps.demo.cyisme.top,resources=notificationconfigs,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=apps,resources=replicasets,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;patch;delete
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=autoscaling.k8s.io,resources=verticalpodautoscalers,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get
+//+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -57,15 +72,17 @@ func (r *InplaceUpdateReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 	obj := &v1.InplaceUpdate{}
 	err := r.Client.Get(ctx, req.NamespacedName, obj)
 	if errors2.IsNotFound(err) {
+		inplaceupdate.DeleteMetrics(req.NamespacedName)
 		return ctrl.Result{}, nil
 	}
 	if err != nil {
 		return ctrl.Result{}, err
 	}
+	inplaceupdate.ObserveMetrics(obj)
 	switch obj.Spec.TargetReference.Kind {
 	case "Deployment":
-		reconcile := inplaceupdate.NewRealDeploymentControl(r.Client)
-		return reconcile.Reconcile(ctx, req.NamespacedName, types.NamespacedName{Namespace: req.Namespace, Name: obj.Spec.TargetReference.Name})
+		reconcile := inplaceupdate.NewRealDeploymentControl(r.Client, r.Recorder, r.Notifier)
+		return reconcile.Reconcile(ctx, req.NamespacedName)
 	default:
 		// never reach here
 		return ctrl.Result{}, nil
@@ -76,5 +93,27 @@ func (r *InplaceUpdateReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 func (r *InplaceUpdateReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&v1.InplaceUpdate{}).
+		Watches(&v1.InplaceUpdate{}, handler.EnqueueRequestsFromMapFunc(r.queuedUpdates)).
 		Complete(r)
 }
+
+// queuedUpdates wakes up the other updates queued on the same target, so the queue moves on
+// as soon as the update at its head completes
+func (r *InplaceUpdateReconciler) queuedUpdates(ctx context.Context, obj client.Object) []reconcile.Request {
+	i, ok := obj.(*v1.InplaceUpdate)
+	if !ok || i.Spec.TargetReference == nil {
+		return nil
+	}
+	queue, err := inplaceupdate.TargetQueue(ctx, r.Client, i.Namespace, i.Spec.TargetReference.Name)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "failed to list the queue of the target", "inplaceupdate", client.ObjectKeyFromObject(i))
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, queued := range queue {
+		if queued.Name != i.Name {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(queued)})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,314 @@
+package inplaceupdate
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+// LabelRollbackOf is set on the update created to roll back a failed analysis, to the name of the failed update
+const LabelRollbackOf = "demo.cyisme.top/rollback-of"
+
+// only the latest measurements of a wave are kept in the status
+const maxMeasurements = 20
+
+// analysisArgs are the values available to the templates of the metrics
+type analysisArgs struct {
+	Namespace string
+	Target    string
+	Wave      int32
+	// Pods is a regular expression matching the names of the pods updated so far
+	Pods string
+	// updated are the pods updated so far
+	updated []*corev1.Pod
+}
+
+// waveUnderAnalysis returns the latest wave if it has completed and its analysis has not ended yet
+func waveUnderAnalysis(obj *v1.InplaceUpdate, status *v1.InplaceUpdateStatus) *v1.InplaceUpdateWave {
+	if obj.Spec.Analysis == nil || len(status.Waves) == 0 {
+		return nil
+	}
+	wave := &status.Waves[len(status.Waves)-1]
+	if wave.CompletionTime == nil || (wave.Analysis != nil && wave.Analysis.Phase != v1.AnalysisPhaseRunning) {
+		return nil
+	}
+	return wave
+}
+
+// AnalysisPassed reports whether the analysis of the latest wave has passed,
+// it is true if the update has no analysis or no wave yet
+func AnalysisPassed(obj *v1.InplaceUpdate, status *v1.InplaceUpdateStatus) bool {
+	if obj.Spec.Analysis == nil || len(status.Waves) == 0 {
+		return true
+	}
+	analysis := status.Waves[len(status.Waves)-1].Analysis
+	return analysis != nil && analysis.Phase == v1.AnalysisPhaseSuccessful
+}
+
+// failedAnalysis returns the wave whose analysis has failed, if any
+func failedAnalysis(status *v1.InplaceUpdateStatus) *v1.InplaceUpdateWave {
+	for i := range status.Waves {
+		if analysis := status.Waves[i].Analysis; analysis != nil && analysis.Phase == v1.AnalysisPhaseFailed {
+			return &status.Waves[i]
+		}
+	}
+	return nil
+}
+
+// restartFailedAnalysis starts the failed analysis of a wave over, its measurements are kept
+func restartFailedAnalysis(status *v1.InplaceUpdateStatus) {
+	if wave := failedAnalysis(status); wave != nil {
+		wave.Analysis.Phase = v1.AnalysisPhaseRunning
+		wave.Analysis.Successful, wave.Analysis.Failed, wave.Analysis.Inconclusive = 0, 0, 0
+	}
+}
+
+// pause sets spec.paused of the update
+func (r *RealDeploymentControl) pause(ctx context.Context, obj *v1.InplaceUpdate) error {
+	patch := client.MergeFrom(obj.DeepCopy())
+	paused := obj.DeepCopy()
+	paused.Spec.Paused = true
+	return r.Client.Patch(ctx, paused, patch)
+}
+
+// analyze takes a round of measurements of the wave once the interval has passed since the completion
+// of the wave or the previous round, and ends the analysis when enough rounds have passed or failed
+func (r *RealDeploymentControl) analyze(ctx context.Context, obj *v1.InplaceUpdate, status *v1.InplaceUpdateStatus, wave *v1.InplaceUpdateWave, pods []*corev1.Pod, now metav1.Time) {
+	spec := obj.Spec.Analysis
+	if wave.Analysis == nil {
+		wave.Analysis = &v1.WaveAnalysis{Phase: v1.AnalysisPhaseRunning}
+	}
+	analysis := wave.Analysis
+	last := wave.CompletionTime.Time
+	if n := len(analysis.Measurements); n != 0 {
+		last = analysis.Measurements[n-1].Time.Time
+	}
+	interval := time.Duration(ptr.Deref(spec.IntervalSeconds, v1.DefaultAnalysisIntervalSeconds)) * time.Second
+	if now.Sub(last) < interval {
+		return
+	}
+	args := analysisArgs{
+		Namespace: obj.Namespace,
+		Target:    obj.Spec.TargetReference.Name,
+		Wave:      wave.Number,
+		Pods:      updatedPodsPattern(status.Waves),
+		updated:   updatedPods(status.Waves, pods),
+	}
+	round := v1.MeasurementPhaseSuccessful
+	for _, metric := range spec.Metrics {
+		measurement := r.measure(ctx, obj, metric, args, now)
+		analysis.Measurements = append(analysis.Measurements, measurement)
+		switch {
+		case measurement.Phase == v1.MeasurementPhaseFailed:
+			round = v1.MeasurementPhaseFailed
+		case measurement.Phase != v1.MeasurementPhaseSuccessful && round == v1.MeasurementPhaseSuccessful:
+			round = v1.MeasurementPhaseInconclusive
+		}
+	}
+	if n := len(analysis.Measurements); n > maxMeasurements {
+		analysis.Measurements = append([]v1.MetricMeasurement(nil), analysis.Measurements[n-maxMeasurements:]...)
+	}
+	switch round {
+	case v1.MeasurementPhaseSuccessful:
+		analysis.Successful++
+	case v1.MeasurementPhaseFailed:
+		analysis.Failed++
+	default:
+		analysis.Inconclusive++
+	}
+	if analysis.Failed > ptr.Deref(spec.FailureLimit, 0) {
+		analysis.Phase = v1.AnalysisPhaseFailed
+	} else if analysis.Successful >= ptr.Deref(spec.Count, 1) {
+		analysis.Phase = v1.AnalysisPhaseSuccessful
+	}
+}
+
+// measure takes a measurement of the metric, a measurement that can not be taken ends in the Error phase
+func (r *RealDeploymentControl) measure(ctx context.Context, obj *v1.InplaceUpdate, metric v1.AnalysisMetric, args analysisArgs, now metav1.Time) v1.MetricMeasurement {
+	measurement := v1.MetricMeasurement{Metric: metric.Name, Time: now}
+	switch {
+	case metric.Prometheus != nil:
+		query, err := renderTemplate(metric.Prometheus.Query, args)
+		if err != nil {
+			measurement.Phase, measurement.Message = v1.MeasurementPhaseError, err.Error()
+			break
+		}
+		value, err := r.prometheus.Query(ctx, metric.Prometheus.Address, query, now.Time)
+		if err != nil {
+			measurement.Phase, measurement.Message = v1.MeasurementPhaseError, err.Error()
+			break
+		}
+		measurement.Value = strconv.FormatFloat(value, 'f', -1, 64)
+		measurement.Phase = evaluateThresholds(metric, value)
+	case metric.Web != nil:
+		measurement.Phase, measurement.Value, measurement.Message = r.verify(ctx, obj.Namespace, metric.Web, newWebPayload(obj, args))
+	default:
+		measurement.Phase, measurement.Message = v1.MeasurementPhaseError, fmt.Sprintf("metric %s has no provider", metric.Name)
+	}
+	return measurement
+}
+
+// evaluateThresholds classifies a value: failed if it meets the failure threshold, successful if it meets
+// the success threshold or there is none. Otherwise it is inconclusive if there is a failure threshold, failed if not.
+// NaN, e.g. an error rate without any request, is always inconclusive.
+func evaluateThresholds(metric v1.AnalysisMetric, value float64) v1.MeasurementPhase {
+	if math.IsNaN(value) {
+		return v1.MeasurementPhaseInconclusive
+	}
+	if metric.FailureThreshold != nil && thresholdMet(metric.FailureThreshold, value) {
+		return v1.MeasurementPhaseFailed
+	}
+	if metric.SuccessThreshold == nil || thresholdMet(metric.SuccessThreshold, value) {
+		return v1.MeasurementPhaseSuccessful
+	}
+	if metric.FailureThreshold != nil {
+		return v1.MeasurementPhaseInconclusive
+	}
+	return v1.MeasurementPhaseFailed
+}
+
+func thresholdMet(threshold *v1.MetricThreshold, value float64) bool {
+	limit, err := strconv.ParseFloat(threshold.Value, 64)
+	if err != nil {
+		return false
+	}
+	switch threshold.Operator {
+	case v1.ThresholdOperatorLessThan:
+		return value < limit
+	case v1.ThresholdOperatorLessThanOrEqual:
+		return value <= limit
+	case v1.ThresholdOperatorGreaterThan:
+		return value > limit
+	case v1.ThresholdOperatorGreaterThanOrEqual:
+		return value >= limit
+	}
+	return false
+}
+
+func renderTemplate(text string, args analysisArgs) (string, error) {
+	tmpl, err := template.New("metric").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	out := &strings.Builder{}
+	if err := tmpl.Execute(out, args); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// updatedPodsPattern is a regular expression matching exactly the pods of the waves
+func updatedPodsPattern(waves []v1.InplaceUpdateWave) string {
+	names := sets.New[string]()
+	for _, wave := range waves {
+		names.Insert(wave.Pods...)
+	}
+	quoted := make([]string, 0, names.Len())
+	for _, name := range sets.List(names) {
+		quoted = append(quoted, regexp.QuoteMeta(name))
+	}
+	return strings.Join(quoted, "|")
+}
+
+// updatedPods returns the live pods of the waves
+func updatedPods(waves []v1.InplaceUpdateWave, pods []*corev1.Pod) []*corev1.Pod {
+	names := sets.New[string]()
+	for _, wave := range waves {
+		names.Insert(wave.Pods...)
+	}
+	var updated []*corev1.Pod
+	for _, pod := range pods {
+		if names.Has(pod.Name) {
+			updated = append(updated, pod)
+		}
+	}
+	return updated
+}
+
+// analysisMessage summarizes the analysis of the wave and its latest failed measurements
+func analysisMessage(wave *v1.InplaceUpdateWave) string {
+	analysis := wave.Analysis
+	message := fmt.Sprintf("analysis of wave %d failed, %d failed, %d successful and %d inconclusive rounds",
+		wave.Number, analysis.Failed, analysis.Successful, analysis.Inconclusive)
+	var failed []string
+	seen := sets.New[string]()
+	for i := len(analysis.Measurements) - 1; i >= 0; i-- {
+		m := analysis.Measurements[i]
+		if m.Phase == v1.MeasurementPhaseFailed && !seen.Has(m.Metric) {
+			seen.Insert(m.Metric)
+			if m.Value != "" {
+				failed = append(failed, fmt.Sprintf("%s=%s", m.Metric, m.Value))
+			} else {
+				failed = append(failed, fmt.Sprintf("%s: %s", m.Metric, m.Message))
+			}
+		}
+	}
+	if len(failed) != 0 {
+		sort.Strings(failed)
+		message += ": " + strings.Join(failed, ", ")
+	}
+	return message
+}
+
+// rollback creates an update restoring the images the pods of the waves ran before the update,
+// it supersedes the updates queued on the target. The name of the rollback is returned,
+// empty if there is nothing to restore.
+func (r *RealDeploymentControl) rollback(ctx context.Context, obj *v1.InplaceUpdate, d *appsv1.Deployment, status *v1.InplaceUpdateStatus) (string, error) {
+	pods, err := r.updatedTargetPods(d, status)
+	if err != nil {
+		return "", err
+	}
+	var containers []v1.InplaceUpdateArgs
+	for _, previous := range PreviousImages(pods) {
+		for _, target := range obj.Spec.Containers {
+			if target.Name == previous.Name {
+				containers = append(containers, v1.InplaceUpdateArgs{Name: previous.Name, Image: previous.Image, Type: target.Type})
+			}
+		}
+	}
+	if len(containers) == 0 {
+		return "", nil
+	}
+	rollback := &v1.InplaceUpdate{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: obj.Namespace,
+			Name:      obj.Name + "-rollback",
+			Labels:    map[string]string{LabelRollbackOf: obj.Name},
+		},
+		Spec: v1.InplaceUpdateSpec{
+			TargetReference:   obj.Spec.TargetReference.DeepCopy(),
+			Containers:        containers,
+			MaxUnavailable:    obj.Spec.MaxUnavailable,
+			MaxSurge:          obj.Spec.MaxSurge,
+			Ordering:          obj.Spec.Ordering,
+			UpdateStrategy:    obj.Spec.UpdateStrategy,
+			Atomic:            obj.Spec.Atomic,
+			Autoscalers:       obj.Spec.Autoscalers,
+			FailurePolicy:     obj.Spec.FailurePolicy,
+			ConcurrencyPolicy: v1.ConcurrencyPolicyQueue,
+			Supersede:         true,
+			Reason:            fmt.Sprintf("analysis of InplaceUpdate %s failed", obj.Name),
+			ChangeRef:         obj.Spec.ChangeRef,
+		},
+	}
+	if err := r.Client.Create(ctx, rollback); err != nil && !apierrors.IsAlreadyExists(err) {
+		return "", err
+	}
+	return rollback.Name, nil
+}
@@ -0,0 +1,211 @@
+package inplaceupdate
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+// fakePrometheus answers instant queries with the response registered for the query
+func fakePrometheus(t *testing.T, responses map[string]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/prom/api/v1/query" {
+			http.NotFound(w, req)
+			return
+		}
+		query := req.FormValue("query")
+		response, exist := responses[query]
+		if !exist {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"status":"error","errorType":"bad_data","error":"unexpected query %s"}`, query)
+			return
+		}
+		fmt.Fprint(w, response)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func vector(values ...string) string {
+	var series []string
+	for _, value := range values {
+		series = append(series, fmt.Sprintf(`{"metric":{},"value":[1700000000.123,%q]}`, value))
+	}
+	return fmt.Sprintf(`{"status":"success","data":{"resultType":"vector","result":[%s]}}`, strings.Join(series, ","))
+}
+
+func TestPrometheusQuery(t *testing.T) {
+	server := fakePrometheus(t, map[string]string{
+		"vector": vector("0.25"),
+		"scalar": `{"status":"success","data":{"resultType":"scalar","result":[1700000000.123,"3"]}}`,
+		"nan":    vector("NaN"),
+		"empty":  vector(),
+		"many":   vector("1", "2"),
+		"matrix": `{"status":"success","data":{"resultType":"matrix","result":[]}}`,
+	})
+	tests := []struct {
+		query string
+		value float64
+		err   string
+	}{
+		{query: "vector", value: 0.25},
+		{query: "scalar", value: 3},
+		{query: "nan", value: math.NaN()},
+		{query: "empty", err: "query returned 0 series, expected 1"},
+		{query: "many", err: "query returned 2 series, expected 1"},
+		{query: "matrix", err: "query returned a matrix, expected a scalar or a vector"},
+		{query: "unknown", err: "query failed with bad_data: unexpected query unknown"},
+	}
+	client := newPrometheusClient()
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			value, err := client.Query(context.Background(), server.URL+"/prom", tt.query, time.Now())
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value != tt.value && !(math.IsNaN(value) && math.IsNaN(tt.value)) {
+				t.Errorf("expected %v, got %v", tt.value, value)
+			}
+		})
+	}
+}
+
+func TestEvaluateThresholds(t *testing.T) {
+	both := v1.AnalysisMetric{
+		SuccessThreshold: &v1.MetricThreshold{Operator: v1.ThresholdOperatorLessThan, Value: "0.01"},
+		FailureThreshold: &v1.MetricThreshold{Operator: v1.ThresholdOperatorGreaterThanOrEqual, Value: "0.05"},
+	}
+	successOnly := v1.AnalysisMetric{SuccessThreshold: both.SuccessThreshold}
+	failureOnly := v1.AnalysisMetric{FailureThreshold: both.FailureThreshold}
+	tests := []struct {
+		name   string
+		metric v1.AnalysisMetric
+		value  float64
+		phase  v1.MeasurementPhase
+	}{
+		{name: "success", metric: both, value: 0.001, phase: v1.MeasurementPhaseSuccessful},
+		{name: "between thresholds", metric: both, value: 0.02, phase: v1.MeasurementPhaseInconclusive},
+		{name: "failure", metric: both, value: 0.05, phase: v1.MeasurementPhaseFailed},
+		{name: "NaN", metric: both, value: math.NaN(), phase: v1.MeasurementPhaseInconclusive},
+		{name: "success threshold not met", metric: successOnly, value: 0.02, phase: v1.MeasurementPhaseFailed},
+		{name: "failure threshold not met", metric: failureOnly, value: 0.02, phase: v1.MeasurementPhaseSuccessful},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if phase := evaluateThresholds(tt.metric, tt.value); phase != tt.phase {
+				t.Errorf("expected %s, got %s", tt.phase, phase)
+			}
+		})
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	const errorRate = `error_rate{namespace="{{ .Namespace }}",deployment="{{ .Target }}",pod=~"{{ .Pods }}"}`
+	rendered := `error_rate{namespace="default",deployment="nginx",pod=~"nginx-a|nginx-b\.1"}`
+	tests := []struct {
+		name     string
+		value    string
+		rounds   int
+		phase    v1.AnalysisPhase
+		counters [3]int32
+	}{
+		{name: "passes after count rounds", value: "0.001", rounds: 2, phase: v1.AnalysisPhaseSuccessful, counters: [3]int32{2, 0, 0}},
+		{name: "fails beyond the failure limit", value: "0.2", rounds: 2, phase: v1.AnalysisPhaseFailed, counters: [3]int32{0, 2, 0}},
+		{name: "keeps running while inconclusive", value: "0.02", rounds: 3, phase: v1.AnalysisPhaseRunning, counters: [3]int32{0, 0, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fakePrometheus(t, map[string]string{rendered: vector(tt.value)})
+			obj := &v1.InplaceUpdate{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "nginx-update"},
+				Spec: v1.InplaceUpdateSpec{
+					TargetReference: &v1.TargetReference{Name: "nginx"},
+					Analysis: &v1.Analysis{
+						Metrics: []v1.AnalysisMetric{{
+							Name:             "error-rate",
+							Prometheus:       &v1.PrometheusMetric{Address: server.URL + "/prom", Query: errorRate},
+							SuccessThreshold: &v1.MetricThreshold{Operator: v1.ThresholdOperatorLessThan, Value: "0.01"},
+							FailureThreshold: &v1.MetricThreshold{Operator: v1.ThresholdOperatorGreaterThan, Value: "0.1"},
+						}},
+						IntervalSeconds: ptr.To[int32](60),
+						Count:           ptr.To[int32](2),
+						FailureLimit:    ptr.To[int32](1),
+					},
+				},
+			}
+			completed := metav1.NewTime(time.Now().Add(-time.Hour))
+			status := &v1.InplaceUpdateStatus{Waves: []v1.InplaceUpdateWave{
+				{Number: 1, Pods: []string{"nginx-b.1"}, CompletionTime: &completed},
+				{Number: 2, Pods: []string{"nginx-a"}, CompletionTime: &completed},
+			}}
+			r := &RealDeploymentControl{prometheus: newPrometheusClient()}
+			now := completed
+			for round := 0; round < tt.rounds; round++ {
+				wave := waveUnderAnalysis(obj, status)
+				if wave == nil || wave.Number != 2 {
+					t.Fatalf("expected wave 2 to be under analysis in round %d, got %v", round, wave)
+				}
+				// a round is only taken once the interval has passed
+				r.analyze(context.Background(), obj, status, wave, nil, metav1.NewTime(now.Add(59*time.Second)))
+				now = metav1.NewTime(now.Add(time.Minute))
+				r.analyze(context.Background(), obj, status, wave, nil, now)
+			}
+			analysis := status.Waves[1].Analysis
+			if analysis.Phase != tt.phase {
+				t.Errorf("expected phase %s, got %s", tt.phase, analysis.Phase)
+			}
+			if got := [3]int32{analysis.Successful, analysis.Failed, analysis.Inconclusive}; got != tt.counters {
+				t.Errorf("expected successful, failed and inconclusive rounds %v, got %v", tt.counters, got)
+			}
+			if len(analysis.Measurements) != tt.rounds || analysis.Measurements[0].Value != tt.value {
+				t.Errorf("expected %d measurements of %s, got %+v", tt.rounds, tt.value, analysis.Measurements)
+			}
+			if passed := AnalysisPassed(obj, status); passed != (tt.phase == v1.AnalysisPhaseSuccessful) {
+				t.Errorf("expected analysis passed to be %v", !passed)
+			}
+			if failed := failedAnalysis(status); (failed != nil) != (tt.phase == v1.AnalysisPhaseFailed) {
+				t.Errorf("expected failed analysis, got %v", failed)
+			}
+		})
+	}
+}
+
+func TestAnalyzeQueryError(t *testing.T) {
+	obj := &v1.InplaceUpdate{
+		Spec: v1.InplaceUpdateSpec{
+			TargetReference: &v1.TargetReference{Name: "nginx"},
+			Analysis: &v1.Analysis{Metrics: []v1.AnalysisMetric{{
+				Name:             "error-rate",
+				Prometheus:       &v1.PrometheusMetric{Address: "http://127.0.0.1:0", Query: "up"},
+				FailureThreshold: &v1.MetricThreshold{Operator: v1.ThresholdOperatorGreaterThan, Value: "0"},
+			}}},
+		},
+	}
+	completed := metav1.NewTime(time.Now().Add(-time.Hour))
+	status := &v1.InplaceUpdateStatus{Waves: []v1.InplaceUpdateWave{{Number: 1, Pods: []string{"nginx-a"}, CompletionTime: &completed}}}
+	r := &RealDeploymentControl{prometheus: newPrometheusClient()}
+	r.analyze(context.Background(), obj, status, &status.Waves[0], nil, metav1.Now())
+	analysis := status.Waves[0].Analysis
+	if analysis.Phase != v1.AnalysisPhaseRunning || analysis.Inconclusive != 1 {
+		t.Errorf("expected an unreachable endpoint to be inconclusive, got %+v", analysis)
+	}
+	if m := analysis.Measurements[0]; m.Phase != v1.MeasurementPhaseError || m.Message == "" {
+		t.Errorf("expected a measurement error, got %+v", m)
+	}
+}
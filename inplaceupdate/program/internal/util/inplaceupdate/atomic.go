@@ -0,0 +1,166 @@
+package inplaceupdate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+	"github.com/Forget-C/demo/inplaceupdate/program/internal/util"
+)
+
+// failedWaitingReasons are the reasons a container waits for that tell it does not come up on its image
+var failedWaitingReasons = sets.New("ErrImagePull", "ImagePullBackOff", "InvalidImageName", "CrashLoopBackOff",
+	"CreateContainerConfigError", "CreateContainerError", "RunContainerError")
+
+// podUpdateState returns the state annotation of the pod, nil if it has none
+func podUpdateState(pod *corev1.Pod) *UpdateState {
+	value, exist := pod.Annotations[AnnotationStateKey]
+	if !exist {
+		return nil
+	}
+	state := &UpdateState{}
+	if err := json.Unmarshal([]byte(value), state); err != nil {
+		return nil
+	}
+	return state
+}
+
+// nextContainers returns the targets to be patched now in the pod: the containers not running their new image
+// yet whose dependencies run their new image and are ready. Without dependsOn these are all the containers left.
+func nextContainers(targets []v1.InplaceUpdateArgs, pod *corev1.Pod, state *UpdateState) []v1.InplaceUpdateArgs {
+	var next []v1.InplaceUpdateArgs
+	for _, target := range targets {
+		container, err := FindTargetContainer(target, pod.Spec)
+		if err != nil || container.Image == target.Image {
+			continue
+		}
+		ready := true
+		for _, name := range target.DependsOn {
+			if !dependencyUpdated(targets, name, pod, state) {
+				ready = false
+				break
+			}
+		}
+		if ready {
+			next = append(next, target)
+		}
+	}
+	return next
+}
+
+// dependencyUpdated reports whether the named target runs its new image and is ready. The container runs the new
+// image once its image ID differs from the one in the snapshot of the state, or without a snapshot once its status
+// reports the image. A dependency missing from the update or from the pod does not hold anything.
+func dependencyUpdated(targets []v1.InplaceUpdateArgs, name string, pod *corev1.Pod, state *UpdateState) bool {
+	var dependency *v1.InplaceUpdateArgs
+	for idx := range targets {
+		if targets[idx].Name == name {
+			dependency = &targets[idx]
+		}
+	}
+	if dependency == nil {
+		return true
+	}
+	container, err := FindTargetContainer(*dependency, pod.Spec)
+	if err != nil {
+		return true
+	}
+	if container.Image != dependency.Image {
+		return false
+	}
+	status := util.FindContainerStatus(name, util.AllContainerStatuses(pod.Status))
+	if status == nil || !status.Ready {
+		return false
+	}
+	if state != nil {
+		if last := state.LastContainerStatuses[name]; last != nil && last.ImageID != "" {
+			return status.ImageID != last.ImageID
+		}
+	}
+	return status.Image == dependency.Image
+}
+
+// failedContainer returns the name of a container of the pod that does not come up on its new image, if any
+func failedContainer(targets []v1.InplaceUpdateArgs, pod *corev1.Pod) string {
+	statuses := util.AllContainerStatuses(pod.Status)
+	for _, target := range targets {
+		container, err := FindTargetContainer(target, pod.Spec)
+		if err != nil || container.Image != target.Image {
+			continue
+		}
+		status := util.FindContainerStatus(target.Name, statuses)
+		if status != nil && status.State.Waiting != nil && failedWaitingReasons.Has(status.State.Waiting.Reason) {
+			return target.Name
+		}
+	}
+	return ""
+}
+
+// revertedPodNames returns the names of the pods reverted by the update
+func revertedPodNames(status *v1.InplaceUpdateStatus) sets.Set[string] {
+	names := sets.New[string]()
+	for _, wave := range status.Waves {
+		names.Insert(wave.RevertedPods...)
+	}
+	return names
+}
+
+// revertPods sets all the containers of the update in the pods back to the images recorded in the snapshot
+// of their state annotation, and records them in the wave in progress. The pods that could not be reverted
+// are left as they are and tried again on the next round.
+// The templates are not reverted here, as they are propagated again on every round; an update with reverted
+// pods always fails, and its teardown puts the previous template images back.
+func (r *RealDeploymentControl) revertPods(i *v1.InplaceUpdate, status *v1.InplaceUpdateStatus, pods []*corev1.Pod) []*corev1.Pod {
+	var revertedPods []*corev1.Pod
+	for _, pod := range pods {
+		if err := r.revertPod(i, pod); err != nil && !apierrors.IsNotFound(err) {
+			log.Log.Error(err, "failed to revert pod", "pod", client.ObjectKeyFromObject(pod))
+			continue
+		}
+		revertedPods = append(revertedPods, pod)
+	}
+	if len(revertedPods) == 0 {
+		return nil
+	}
+	if wave := WaveInProgress(status); wave != nil {
+		wave.RevertedPods = append(wave.RevertedPods, util.PodNameList(revertedPods)...)
+	}
+	r.recorder.AnnotatedEventf(i, auditAnnotations(i), corev1.EventTypeWarning, "PodsReverted", "%s",
+		withAudit(i, fmt.Sprintf("containers of pods %s did not come up on their new images and were reverted", util.PodNames(revertedPods))))
+	return revertedPods
+}
+
+func (r *RealDeploymentControl) revertPod(i *v1.InplaceUpdate, pod *corev1.Pod) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		live := &corev1.Pod{}
+		if err := r.Client.Get(context.TODO(), client.ObjectKeyFromObject(pod), live); err != nil {
+			return err
+		}
+		state := podUpdateState(live)
+		if state == nil {
+			return fmt.Errorf("pod %s has no snapshot of its containers", live.Name)
+		}
+		desired := live.DeepCopy()
+		for _, target := range i.Spec.Containers {
+			last := state.LastContainerStatuses[target.Name]
+			container, err := FindTargetContainer(target, desired.Spec)
+			if last == nil || last.Image == "" || err != nil {
+				continue
+			}
+			container.Image = last.Image
+		}
+		patch, err := newPodImagePatch(live, desired)
+		if err != nil {
+			return err
+		}
+		return r.Client.Patch(context.TODO(), live, patch)
+	})
+}
@@ -0,0 +1,114 @@
+package inplaceupdate
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+	"github.com/Forget-C/demo/inplaceupdate/program/internal/util"
+)
+
+func TestAtomic(t *testing.T) {
+	f := newFixture(1, corev1.Container{Name: "envoy", Image: "envoy:v1"}, corev1.Container{Name: "nginx", Image: "nginx:1.24"})
+	pod := f.pod("nginx-a")
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{
+		{Name: "envoy", Image: "envoy:v1", ImageID: "envoy@sha256:1", Ready: true},
+		{Name: "nginx", Image: "nginx:1.24", ImageID: "nginx@sha256:1", Ready: true},
+	}
+	// nginx is only updated once envoy runs its new image and is ready
+	update := f.update
+	update.Spec.Containers = []v1.InplaceUpdateArgs{
+		{Name: "envoy", Image: "envoy:v2"}, {Name: "nginx", Image: "nginx:1.25", DependsOn: []string{"envoy"}},
+	}
+	update.Spec.MaxUnavailable = ptr.To(intstr.FromInt32(1))
+	update.Spec.Atomic = true
+	c := f.client(pod)
+	r := NewRealDeploymentControl(c, &record.FakeRecorder{}, nil)
+	ctx := context.Background()
+	step := func() []*corev1.Pod {
+		t.Helper()
+		status := newStatusFrom(update)
+		newPods, _, err := r.ownerRefPatchedPods(ctx, update, f.deployment(t, c), status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.sync(update, newPods, nil, status); err != nil {
+			t.Fatal(err)
+		}
+		update.Status = *status
+		return newPods
+	}
+	setStatus := func(mutate func(pod *corev1.Pod)) *corev1.Pod {
+		t.Helper()
+		live := &corev1.Pod{}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(pod), live); err != nil {
+			t.Fatal(err)
+		}
+		mutate(live)
+		if err := c.Status().Update(ctx, live); err != nil {
+			t.Fatal(err)
+		}
+		return live
+	}
+	image := func(pod *corev1.Pod, name string) string {
+		return util.FindContainer(name, pod.Spec).Image
+	}
+
+	// the wave starts with envoy alone
+	newPods := step()
+	if len(newPods) != 1 || image(newPods[0], "envoy") != "envoy:v2" || image(newPods[0], "nginx") != "nginx:1.24" {
+		t.Fatalf("expected only envoy to be patched, got %+v", newPods)
+	}
+	if len(update.Status.Waves) != 1 || update.Status.UpdatedReplicas != 0 {
+		t.Fatalf("expected a wave with the pod not updated yet, got %+v, %d updated", update.Status.Waves, update.Status.UpdatedReplicas)
+	}
+	// nginx waits while envoy still runs the old image
+	if newPods := step(); len(newPods) != 0 {
+		t.Fatalf("expected nginx to wait for envoy, got %d pods patched", len(newPods))
+	}
+	setStatus(func(pod *corev1.Pod) {
+		pod.Status.ContainerStatuses[0] = corev1.ContainerStatus{Name: "envoy", Image: "envoy:v2", ImageID: "envoy@sha256:2", Ready: true}
+	})
+	newPods = step()
+	if len(newPods) != 1 || image(newPods[0], "nginx") != "nginx:1.25" {
+		t.Fatalf("expected nginx to be patched once envoy is ready, got %+v", newPods)
+	}
+	if state := podUpdateState(newPods[0]); state == nil || state.LastContainerStatuses["envoy"].Image != "envoy:v1" {
+		t.Fatalf("expected the snapshot taken when the pod joined the wave to be kept, got %+v", state)
+	}
+
+	// nginx does not come up on its new image, both containers are reverted
+	setStatus(func(pod *corev1.Pod) {
+		pod.Status.Conditions[0].Status = corev1.ConditionFalse
+		pod.Status.ContainerStatuses[1] = corev1.ContainerStatus{Name: "nginx", Image: "nginx:1.25",
+			State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}}}
+	})
+	step()
+	live := &corev1.Pod{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(pod), live); err != nil {
+		t.Fatal(err)
+	}
+	if image(live, "envoy") != "envoy:v1" || image(live, "nginx") != "nginx:1.24" {
+		t.Errorf("expected the pod to be reverted, got %s and %s", image(live, "envoy"), image(live, "nginx"))
+	}
+	if wave := update.Status.Waves[0]; len(wave.RevertedPods) != 1 || update.Status.RevertedReplicas != 1 || wave.CompletionTime != nil {
+		t.Errorf("expected the wave to wait for the reverted pod, got %+v, %d reverted", wave, update.Status.RevertedReplicas)
+	}
+
+	// once ready again the reverted pod completes the wave and is left out of the update
+	setStatus(func(pod *corev1.Pod) {
+		pod.Status.Conditions[0].Status = corev1.ConditionTrue
+	})
+	if newPods := step(); len(newPods) != 0 {
+		t.Errorf("expected the reverted pod not to be updated again, got %d pods patched", len(newPods))
+	}
+	if wave := update.Status.Waves[0]; wave.CompletionTime == nil || update.Status.RevertedReplicas+update.Status.UpdatedReplicas != update.Status.Replicas {
+		t.Errorf("expected the wave to complete with the pod reverted, got %+v", update.Status)
+	}
+}
@@ -0,0 +1,71 @@
+package inplaceupdate
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+// AnnotationChangeRef on the events of an update is its spec.changeRef
+const AnnotationChangeRef = "demo.cyisme.top/change-ref"
+
+// auditAnnotations are set on the events of the update, so they can be told apart by who made the update
+func auditAnnotations(i *v1.InplaceUpdate) map[string]string {
+	annotations := map[string]string{}
+	if value := initiator(i); value != "" {
+		annotations[v1.AnnotationInitiator] = value
+	}
+	if value := i.Annotations[v1.AnnotationInitiatorGroups]; value != "" {
+		annotations[v1.AnnotationInitiatorGroups] = value
+	}
+	if i.Spec.ChangeRef != "" {
+		annotations[AnnotationChangeRef] = i.Spec.ChangeRef
+	}
+	return annotations
+}
+
+// auditMessage tells who made the update and why, e.g. "initiator alice, change CHG-1234, reason: fix CVE-2024-1234"
+func auditMessage(i *v1.InplaceUpdate) string {
+	var parts []string
+	if value := initiator(i); value != "" {
+		parts = append(parts, "initiator "+value)
+	}
+	if i.Spec.ChangeRef != "" {
+		parts = append(parts, "change "+i.Spec.ChangeRef)
+	}
+	if i.Spec.Reason != "" {
+		parts = append(parts, "reason: "+i.Spec.Reason)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// withAudit appends the audit message to the message of an event
+func withAudit(i *v1.InplaceUpdate, message string) string {
+	if audit := auditMessage(i); audit != "" {
+		return fmt.Sprintf("%s (%s)", message, audit)
+	}
+	return message
+}
+
+func (r *RealDeploymentControl) startedEvent(i *v1.InplaceUpdate) {
+	r.recorder.AnnotatedEventf(i, auditAnnotations(i), corev1.EventTypeNormal, "Started", "%s",
+		withAudit(i, fmt.Sprintf("update of %s %s started", i.Spec.TargetReference.Kind, i.Spec.TargetReference.Name)))
+}
+
+// completedEvent reports the end of the update, a failed update is reported with the reason it failed for
+func (r *RealDeploymentControl) completedEvent(i *v1.InplaceUpdate, status *v1.InplaceUpdateStatus) {
+	if status.Phase == v1.InplaceUpdatePhaseFinished {
+		r.recorder.AnnotatedEventf(i, auditAnnotations(i), corev1.EventTypeNormal, "Finished", "%s",
+			withAudit(i, fmt.Sprintf("%d/%d pods updated", status.UpdatedReplicas, status.Replicas)))
+		return
+	}
+	reason, message := "Failed", "update failed"
+	if degraded := meta.FindStatusCondition(status.Conditions, v1.InplaceUpdateConditionDegraded); degraded != nil {
+		reason, message = degraded.Reason, degraded.Message
+	}
+	r.recorder.AnnotatedEventf(i, auditAnnotations(i), corev1.EventTypeWarning, reason, "%s", withAudit(i, message))
+}
@@ -0,0 +1,310 @@
+package inplaceupdate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+// AnnotationHeldByKey is set on an autoscaler to the name of the InplaceUpdate holding it
+const AnnotationHeldByKey = "demo.cyisme.top/held-by"
+
+// AnnotationHeldSettingsKey is set on an autoscaler to its settings before it was held, as JSON
+const AnnotationHeldSettingsKey = "demo.cyisme.top/held-settings"
+
+// verticalPodAutoscalerGVK is read as unstructured, the VerticalPodAutoscaler is a CRD that may not be installed
+var verticalPodAutoscalerGVK = schema.GroupVersionKind{Group: "autoscaling.k8s.io", Version: "v1", Kind: "VerticalPodAutoscaler"}
+
+// horizontalPodAutoscalerSettings are the settings of a HorizontalPodAutoscaler changed while it is held
+type horizontalPodAutoscalerSettings struct {
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+	MaxReplicas int32  `json:"maxReplicas"`
+}
+
+// verticalPodAutoscalerSettings are the settings of a VerticalPodAutoscaler changed while it is held
+type verticalPodAutoscalerSettings struct {
+	UpdateMode *string `json:"updateMode,omitempty"`
+}
+
+// holdAutoscalers holds the autoscalers of the deployment as set in spec.autoscalers. Their settings are saved
+// on them before they are changed, autoscalers already held by the update are left as they are.
+// The update already has FinalizerTeardown, so the settings are restored even if it is deleted while holding them.
+func (r *RealDeploymentControl) holdAutoscalers(ctx context.Context, i *v1.InplaceUpdate, d *appsv1.Deployment) error {
+	coordination := i.Spec.Autoscalers
+	if coordination == nil || (coordination.HorizontalPodAutoscaler == "" && coordination.VerticalPodAutoscaler == "") {
+		return nil
+	}
+	var held []string
+	if coordination.HorizontalPodAutoscaler != "" {
+		hpas, err := r.getHorizontalPodAutoscalers(ctx, d.Namespace)
+		if err != nil {
+			return err
+		}
+		for _, hpa := range hpas {
+			ref := hpa.Spec.ScaleTargetRef
+			if !isDeploymentRef(ref.APIVersion, ref.Kind, ref.Name, d) {
+				continue
+			}
+			changed, err := r.holdHorizontalPodAutoscaler(ctx, i, hpa, *d.Spec.Replicas)
+			if err != nil {
+				return fmt.Errorf("failed to hold the HorizontalPodAutoscaler %s: %v", hpa.Name, err)
+			}
+			if changed {
+				held = append(held, "HorizontalPodAutoscaler "+hpa.Name)
+			}
+		}
+	}
+	if coordination.VerticalPodAutoscaler != "" {
+		vpas, err := r.getVerticalPodAutoscalers(ctx, d.Namespace)
+		if err != nil {
+			return err
+		}
+		for _, vpa := range vpas {
+			apiVersion, _, _ := unstructured.NestedString(vpa.Object, "spec", "targetRef", "apiVersion")
+			kind, _, _ := unstructured.NestedString(vpa.Object, "spec", "targetRef", "kind")
+			name, _, _ := unstructured.NestedString(vpa.Object, "spec", "targetRef", "name")
+			if !isDeploymentRef(apiVersion, kind, name, d) {
+				continue
+			}
+			changed, err := r.holdVerticalPodAutoscaler(ctx, i, vpa)
+			if err != nil {
+				return fmt.Errorf("failed to hold the VerticalPodAutoscaler %s: %v", vpa.GetName(), err)
+			}
+			if changed {
+				held = append(held, "VerticalPodAutoscaler "+vpa.GetName())
+			}
+		}
+	}
+	if len(held) != 0 {
+		r.recorder.AnnotatedEventf(i, auditAnnotations(i), corev1.EventTypeNormal, "AutoscalersHeld", "%s",
+			withAudit(i, fmt.Sprintf("%s held during the update", strings.Join(held, ", "))))
+	}
+	return nil
+}
+
+// releaseAutoscalers restores the settings of the autoscalers held by the update
+func (r *RealDeploymentControl) releaseAutoscalers(ctx context.Context, i *v1.InplaceUpdate) error {
+	var restored []string
+	hpas, err := r.getHorizontalPodAutoscalers(ctx, i.Namespace)
+	if err != nil {
+		return err
+	}
+	for _, hpa := range hpas {
+		if hpa.Annotations[AnnotationHeldByKey] != i.Name {
+			continue
+		}
+		if err := r.restoreHorizontalPodAutoscaler(ctx, i, hpa); err != nil {
+			return fmt.Errorf("failed to restore the HorizontalPodAutoscaler %s: %v", hpa.Name, err)
+		}
+		restored = append(restored, "HorizontalPodAutoscaler "+hpa.Name)
+	}
+	vpas, err := r.getVerticalPodAutoscalers(ctx, i.Namespace)
+	if err != nil {
+		return err
+	}
+	for _, vpa := range vpas {
+		if vpa.GetAnnotations()[AnnotationHeldByKey] != i.Name {
+			continue
+		}
+		if err := r.restoreVerticalPodAutoscaler(ctx, i, vpa); err != nil {
+			return fmt.Errorf("failed to restore the VerticalPodAutoscaler %s: %v", vpa.GetName(), err)
+		}
+		restored = append(restored, "VerticalPodAutoscaler "+vpa.GetName())
+	}
+	if len(restored) != 0 {
+		r.recorder.AnnotatedEventf(i, auditAnnotations(i), corev1.EventTypeNormal, "AutoscalersRestored", "%s",
+			withAudit(i, fmt.Sprintf("settings of %s restored", strings.Join(restored, ", "))))
+	}
+	return nil
+}
+
+// holdHorizontalPodAutoscaler applies the policy to the settings the HorizontalPodAutoscaler had before it was held.
+// An autoscaler left held by an update that went away keeps the settings saved by that update.
+func (r *RealDeploymentControl) holdHorizontalPodAutoscaler(ctx context.Context, i *v1.InplaceUpdate, hpa *autoscalingv2.HorizontalPodAutoscaler, replicas int32) (held bool, err error) {
+	if replicas < 1 {
+		return false, nil
+	}
+	err = retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		held = false
+		latest := &autoscalingv2.HorizontalPodAutoscaler{}
+		if err := r.Client.Get(ctx, client.ObjectKeyFromObject(hpa), latest); err != nil {
+			return err
+		}
+		if latest.Annotations[AnnotationHeldByKey] == i.Name {
+			return nil
+		}
+		patch := client.MergeFromWithOptions(latest.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		settings := horizontalPodAutoscalerSettings{MinReplicas: latest.Spec.MinReplicas, MaxReplicas: latest.Spec.MaxReplicas}
+		if saved, ok := latest.Annotations[AnnotationHeldSettingsKey]; ok {
+			if err := json.Unmarshal([]byte(saved), &settings); err != nil {
+				return fmt.Errorf("invalid annotation %s: %v", AnnotationHeldSettingsKey, err)
+			}
+		} else {
+			value, err := json.Marshal(settings)
+			if err != nil {
+				return err
+			}
+			setAnnotation(latest, AnnotationHeldSettingsKey, string(value))
+		}
+		setAnnotation(latest, AnnotationHeldByKey, i.Name)
+		switch i.Spec.Autoscalers.HorizontalPodAutoscaler {
+		case v1.HorizontalPodAutoscalerHold:
+			latest.Spec.MinReplicas = &replicas
+			latest.Spec.MaxReplicas = replicas
+		case v1.HorizontalPodAutoscalerNoScaleDown:
+			minReplicas := replicas
+			if settings.MinReplicas != nil {
+				minReplicas = max(replicas, *settings.MinReplicas)
+			}
+			minReplicas = min(minReplicas, settings.MaxReplicas)
+			latest.Spec.MinReplicas = &minReplicas
+			latest.Spec.MaxReplicas = settings.MaxReplicas
+		}
+		held = true
+		return r.Client.Patch(ctx, latest, patch)
+	})
+	return held, err
+}
+
+func (r *RealDeploymentControl) restoreHorizontalPodAutoscaler(ctx context.Context, i *v1.InplaceUpdate, hpa *autoscalingv2.HorizontalPodAutoscaler) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		latest := &autoscalingv2.HorizontalPodAutoscaler{}
+		if err := r.Client.Get(ctx, client.ObjectKeyFromObject(hpa), latest); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		if latest.Annotations[AnnotationHeldByKey] != i.Name {
+			return nil
+		}
+		patch := client.MergeFromWithOptions(latest.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		var settings horizontalPodAutoscalerSettings
+		if err := json.Unmarshal([]byte(latest.Annotations[AnnotationHeldSettingsKey]), &settings); err != nil {
+			return fmt.Errorf("invalid annotation %s: %v", AnnotationHeldSettingsKey, err)
+		}
+		latest.Spec.MinReplicas = settings.MinReplicas
+		latest.Spec.MaxReplicas = settings.MaxReplicas
+		delete(latest.Annotations, AnnotationHeldByKey)
+		delete(latest.Annotations, AnnotationHeldSettingsKey)
+		return r.Client.Patch(ctx, latest, patch)
+	})
+}
+
+// holdVerticalPodAutoscaler sets the update mode of the VerticalPodAutoscaler to Off
+func (r *RealDeploymentControl) holdVerticalPodAutoscaler(ctx context.Context, i *v1.InplaceUpdate, vpa *unstructured.Unstructured) (held bool, err error) {
+	err = retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		held = false
+		latest := &unstructured.Unstructured{}
+		latest.SetGroupVersionKind(verticalPodAutoscalerGVK)
+		if err := r.Client.Get(ctx, client.ObjectKeyFromObject(vpa), latest); err != nil {
+			return err
+		}
+		if latest.GetAnnotations()[AnnotationHeldByKey] == i.Name {
+			return nil
+		}
+		patch := client.MergeFromWithOptions(latest.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		if _, ok := latest.GetAnnotations()[AnnotationHeldSettingsKey]; !ok {
+			var settings verticalPodAutoscalerSettings
+			if mode, found, _ := unstructured.NestedString(latest.Object, "spec", "updatePolicy", "updateMode"); found {
+				settings.UpdateMode = &mode
+			}
+			value, err := json.Marshal(settings)
+			if err != nil {
+				return err
+			}
+			setAnnotation(latest, AnnotationHeldSettingsKey, string(value))
+		}
+		setAnnotation(latest, AnnotationHeldByKey, i.Name)
+		if err := unstructured.SetNestedField(latest.Object, string(i.Spec.Autoscalers.VerticalPodAutoscaler), "spec", "updatePolicy", "updateMode"); err != nil {
+			return err
+		}
+		held = true
+		return r.Client.Patch(ctx, latest, patch)
+	})
+	return held, err
+}
+
+func (r *RealDeploymentControl) restoreVerticalPodAutoscaler(ctx context.Context, i *v1.InplaceUpdate, vpa *unstructured.Unstructured) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		latest := &unstructured.Unstructured{}
+		latest.SetGroupVersionKind(verticalPodAutoscalerGVK)
+		if err := r.Client.Get(ctx, client.ObjectKeyFromObject(vpa), latest); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		annotations := latest.GetAnnotations()
+		if annotations[AnnotationHeldByKey] != i.Name {
+			return nil
+		}
+		patch := client.MergeFromWithOptions(latest.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		var settings verticalPodAutoscalerSettings
+		if err := json.Unmarshal([]byte(annotations[AnnotationHeldSettingsKey]), &settings); err != nil {
+			return fmt.Errorf("invalid annotation %s: %v", AnnotationHeldSettingsKey, err)
+		}
+		if settings.UpdateMode != nil {
+			if err := unstructured.SetNestedField(latest.Object, *settings.UpdateMode, "spec", "updatePolicy", "updateMode"); err != nil {
+				return err
+			}
+		} else {
+			unstructured.RemoveNestedField(latest.Object, "spec", "updatePolicy", "updateMode")
+		}
+		delete(annotations, AnnotationHeldByKey)
+		delete(annotations, AnnotationHeldSettingsKey)
+		latest.SetAnnotations(annotations)
+		return r.Client.Patch(ctx, latest, patch)
+	})
+}
+
+func (r *RealDeploymentControl) getHorizontalPodAutoscalers(ctx context.Context, namespace string) ([]*autoscalingv2.HorizontalPodAutoscaler, error) {
+	hpaList := autoscalingv2.HorizontalPodAutoscalerList{}
+	if err := r.Client.List(ctx, &hpaList, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	hpas := make([]*autoscalingv2.HorizontalPodAutoscaler, 0, len(hpaList.Items))
+	for idx := range hpaList.Items {
+		hpas = append(hpas, &hpaList.Items[idx])
+	}
+	return hpas, nil
+}
+
+// getVerticalPodAutoscalers returns none if the VerticalPodAutoscaler CRD is not installed
+func (r *RealDeploymentControl) getVerticalPodAutoscalers(ctx context.Context, namespace string) ([]*unstructured.Unstructured, error) {
+	vpaList := &unstructured.UnstructuredList{}
+	vpaList.SetGroupVersionKind(verticalPodAutoscalerGVK.GroupVersion().WithKind(verticalPodAutoscalerGVK.Kind + "List"))
+	if err := r.Client.List(ctx, vpaList, client.InNamespace(namespace)); err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	vpas := make([]*unstructured.Unstructured, 0, len(vpaList.Items))
+	for idx := range vpaList.Items {
+		vpas = append(vpas, &vpaList.Items[idx])
+	}
+	return vpas, nil
+}
+
+// isDeploymentRef reports whether the reference of an autoscaler points to the deployment
+func isDeploymentRef(apiVersion, kind, name string, d *appsv1.Deployment) bool {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	return err == nil && gv.Group == appsv1.GroupName && kind == "Deployment" && name == d.Name
+}
+
+// setAnnotation sets an annotation of the object, typed or unstructured
+func setAnnotation(obj client.Object, key, value string) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[key] = value
+	obj.SetAnnotations(annotations)
+}
@@ -0,0 +1,120 @@
+package inplaceupdate
+
+import (
+	"context"
+	"testing"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+func TestAutoscalers(t *testing.T) {
+	targetRef := autoscalingv2.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "nginx"}
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "nginx"},
+		Spec:       autoscalingv2.HorizontalPodAutoscalerSpec{ScaleTargetRef: targetRef, MinReplicas: ptr.To[int32](2), MaxReplicas: 10},
+	}
+	otherRef := targetRef
+	otherRef.Name = "redis"
+	other := &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "redis"},
+		Spec:       autoscalingv2.HorizontalPodAutoscalerSpec{ScaleTargetRef: otherRef, MaxReplicas: 3},
+	}
+	vpa := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"targetRef": map[string]interface{}{"apiVersion": "apps/v1", "kind": "Deployment", "name": "nginx"},
+		},
+	}}
+	vpa.SetGroupVersionKind(verticalPodAutoscalerGVK)
+	vpa.SetNamespace("default")
+	vpa.SetName("nginx")
+
+	tests := []struct {
+		name        string
+		policy      v1.HorizontalPodAutoscalerPolicy
+		minReplicas int32
+		maxReplicas int32
+	}{
+		{name: "hold", policy: v1.HorizontalPodAutoscalerHold, minReplicas: 4, maxReplicas: 4},
+		{name: "no scale down", policy: v1.HorizontalPodAutoscalerNoScaleDown, minReplicas: 4, maxReplicas: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(4)
+			f.update.Spec.Autoscalers = &v1.AutoscalerCoordination{HorizontalPodAutoscaler: tt.policy, VerticalPodAutoscaler: v1.VerticalPodAutoscalerOff}
+			update, d := f.update, f.d
+			c := f.client(hpa.DeepCopy(), other.DeepCopy(), vpa.DeepCopy())
+			r := NewRealDeploymentControl(c, &record.FakeRecorder{}, nil)
+			ctx := context.Background()
+
+			if err := r.addTeardownFinalizer(ctx, update); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.holdAutoscalers(ctx, update, d); err != nil {
+				t.Fatal(err)
+			}
+			latestHpa := &autoscalingv2.HorizontalPodAutoscaler{}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(hpa), latestHpa); err != nil {
+				t.Fatal(err)
+			}
+			if *latestHpa.Spec.MinReplicas != tt.minReplicas || latestHpa.Spec.MaxReplicas != tt.maxReplicas || latestHpa.Annotations[AnnotationHeldByKey] != update.Name {
+				t.Errorf("expected the HorizontalPodAutoscaler to be held at %d-%d, got %d-%d", tt.minReplicas, tt.maxReplicas,
+					*latestHpa.Spec.MinReplicas, latestHpa.Spec.MaxReplicas)
+			}
+			latestOther := &autoscalingv2.HorizontalPodAutoscaler{}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(other), latestOther); err != nil {
+				t.Fatal(err)
+			}
+			if latestOther.Annotations[AnnotationHeldByKey] != "" {
+				t.Error("expected the HorizontalPodAutoscaler of another deployment to be left alone")
+			}
+			latestVpa := &unstructured.Unstructured{}
+			latestVpa.SetGroupVersionKind(verticalPodAutoscalerGVK)
+			if err := c.Get(ctx, client.ObjectKeyFromObject(vpa), latestVpa); err != nil {
+				t.Fatal(err)
+			}
+			if mode, _, _ := unstructured.NestedString(latestVpa.Object, "spec", "updatePolicy", "updateMode"); mode != "Off" {
+				t.Errorf("expected the VerticalPodAutoscaler to be Off, got %q", mode)
+			}
+
+			// holding again, e.g. after the deployment was scaled, keeps the settings of the first hold
+			scaled := d.DeepCopy()
+			scaled.Spec.Replicas = ptr.To[int32](6)
+			if err := r.holdAutoscalers(ctx, update, scaled); err != nil {
+				t.Fatal(err)
+			}
+
+			// the teardown of the update restores them
+			if err := r.teardown(ctx, update, update.Status.DeepCopy()); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(hpa), latestHpa); err != nil {
+				t.Fatal(err)
+			}
+			if *latestHpa.Spec.MinReplicas != 2 || latestHpa.Spec.MaxReplicas != 10 || len(latestHpa.Annotations) != 0 {
+				t.Errorf("expected the HorizontalPodAutoscaler to be restored to 2-10, got %d-%d, %v",
+					*latestHpa.Spec.MinReplicas, latestHpa.Spec.MaxReplicas, latestHpa.Annotations)
+			}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(vpa), latestVpa); err != nil {
+				t.Fatal(err)
+			}
+			if _, found, _ := unstructured.NestedString(latestVpa.Object, "spec", "updatePolicy", "updateMode"); found || len(latestVpa.GetAnnotations()) != 0 {
+				t.Errorf("expected the update mode of the VerticalPodAutoscaler to be unset again, got %v", latestVpa.Object)
+			}
+			latest := &v1.InplaceUpdate{}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(update), latest); err != nil {
+				t.Fatal(err)
+			}
+			if controllerutil.ContainsFinalizer(latest, FinalizerTeardown) {
+				t.Error("expected the finalizer to be removed")
+			}
+		})
+	}
+}
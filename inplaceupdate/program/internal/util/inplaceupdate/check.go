@@ -4,7 +4,7 @@ import v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
 
 func IsCompleted(obj *v1.InplaceUpdate) bool {
 	switch obj.Status.Phase {
-	case v1.InplaceUpdatePhaseFinished, v1.InplaceUpdatePhaseFailed:
+	case v1.InplaceUpdatePhaseFinished, v1.InplaceUpdatePhaseFailed, v1.InplaceUpdatePhaseSuperseded:
 		return true
 	}
 	return false
@@ -13,3 +13,11 @@ func IsCompleted(obj *v1.InplaceUpdate) bool {
 func IsRunning(obj *v1.InplaceUpdate) bool {
 	return obj.Status.Phase == v1.InplaceUpdatePhaseRunning
 }
+
+func IsClusterCompleted(obj *v1.ClusterInplaceUpdate) bool {
+	switch obj.Status.Phase {
+	case v1.InplaceUpdatePhaseFinished, v1.InplaceUpdatePhaseFailed:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package inplaceupdate
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	deploymentutil "k8s.io/kubernetes/pkg/controller/deployment/util"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+func TestChurn(t *testing.T) {
+	// the deployment is scaled to 3 replicas while its replicaset still has 2 pods
+	f := newFixture(2)
+	f.d.Spec.Replicas = ptr.To[int32](3)
+	f.update.Spec.MaxUnavailable = ptr.To(intstr.FromInt32(2))
+	newPod := func(name, image string) *corev1.Pod {
+		pod := f.pod(name)
+		pod.Spec.Containers[0].Image = image
+		return pod
+	}
+	podA := newPod("nginx-a", "nginx:1.24")
+	c := f.client(podA, newPod("nginx-b", "nginx:1.24"))
+	r := NewRealDeploymentControl(c, &record.FakeRecorder{}, nil)
+	ctx := context.Background()
+	update, rs := f.update, f.rs
+	deployment := func() *appsv1.Deployment { return f.deployment(t, c) }
+
+	// the scale up in progress does not fail the update, the missing pod is unavailable
+	status := newStatusFrom(update)
+	wave, _, err := r.ownerRefPatchedPods(ctx, update, deployment(), status)
+	if err != nil || len(wave) != 1 || wave[0].Name != "nginx-a" {
+		t.Fatalf("expected nginx-a to be updated, got %d pods, %v", len(wave), err)
+	}
+	if status.Replicas != 2 || status.UnavailableReplicas != 1 {
+		t.Errorf("expected 2 pods and 1 unavailable, got %d and %d", status.Replicas, status.UnavailableReplicas)
+	}
+	latestRs := &appsv1.ReplicaSet{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(rs), latestRs); err != nil {
+		t.Fatal(err)
+	}
+	latest := deployment()
+	if latestRs.Spec.Template.Spec.Containers[0].Image != "nginx:1.25" || latest.Spec.Template.Spec.Containers[0].Image != "nginx:1.25" || latest.Spec.Paused {
+		t.Errorf("expected the images to be set in the templates of the resumed deployment, got %s and %s, paused %v",
+			latestRs.Spec.Template.Spec.Containers[0].Image, latest.Spec.Template.Spec.Containers[0].Image, latest.Spec.Paused)
+	}
+
+	// nginx-a is evicted before it is patched, it leaves the update without failing it
+	if err := c.Delete(ctx, podA); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.sync(update, wave, nil, status); err != nil {
+		t.Fatal(err)
+	}
+	latestUpdate := &v1.InplaceUpdate{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(update), latestUpdate); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Waves) != 0 || latestUpdate.Annotations[AnnotationFailedKey] != "" {
+		t.Errorf("expected no wave and no failed pod, got %+v and %q", status.Waves, latestUpdate.Annotations[AnnotationFailedKey])
+	}
+
+	// the pods created by the replicaset run the new images, only nginx-b is left to update
+	for _, pod := range []*corev1.Pod{newPod("nginx-c", "nginx:1.25"), newPod("nginx-d", "nginx:1.25")} {
+		if err := c.Create(ctx, pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	status = newStatusFrom(update)
+	wave, _, err = r.ownerRefPatchedPods(ctx, update, deployment(), status)
+	if err != nil || len(wave) != 1 || wave[0].Name != "nginx-b" {
+		t.Fatalf("expected nginx-b to be updated, got %d pods, %v", len(wave), err)
+	}
+	if status.Replicas != 3 || status.UpdatedReplicas != 2 || status.UnavailableReplicas != 0 {
+		t.Errorf("expected 2/3 pods updated and none unavailable, got %d/%d and %d", status.UpdatedReplicas, status.Replicas, status.UnavailableReplicas)
+	}
+}
+
+func TestResumeSetsImages(t *testing.T) {
+	f := newFixture(2)
+	update, d := f.update, f.d
+	// the images were set in the template of the replicaset but not yet in the one of the deployment
+	d.Spec.Paused = true
+	d.Annotations = map[string]string{AnnotationPausedByKey: update.Name}
+	f.rs.Spec.Template.Spec.Containers[0].Image = "nginx:1.25"
+	r := NewRealDeploymentControl(f.client(), &record.FakeRecorder{}, nil)
+
+	if err := r.setDeploymentPaused(d, update, false); err != nil {
+		t.Fatal(err)
+	}
+	latest := f.deployment(t, r.Client)
+	if latest.Spec.Paused || latest.Spec.Template.Spec.Containers[0].Image != "nginx:1.25" {
+		t.Errorf("expected the deployment to be resumed with the images of its replicaset, got paused %v, image %s",
+			latest.Spec.Paused, latest.Spec.Template.Spec.Containers[0].Image)
+	}
+}
+
+func TestRestoreTemplateImages(t *testing.T) {
+	tests := []struct {
+		name  string
+		phase v1.InplaceUpdatePhase
+		image string
+	}{
+		{name: "failed", phase: v1.InplaceUpdatePhaseFailed, image: "nginx:1.24"},
+		{name: "finished", phase: v1.InplaceUpdatePhaseFinished, image: "nginx:1.25"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(2)
+			c := f.client(f.pod("nginx-a"), f.pod("nginx-b"))
+			r := NewRealDeploymentControl(c, &record.FakeRecorder{}, nil)
+			ctx := context.Background()
+			update := f.update
+
+			// the images are set in the templates as the update starts
+			status := newStatusFrom(update)
+			if _, _, err := r.ownerRefPatchedPods(ctx, update, f.deployment(t, c), status); err != nil {
+				t.Fatal(err)
+			}
+			if d := f.deployment(t, c); d.Spec.Template.Spec.Containers[0].Image != "nginx:1.25" || d.Annotations[AnnotationTemplateImagesKey] == "" {
+				t.Fatalf("expected the images of the update and the previous ones saved, got %s %v", d.Spec.Template.Spec.Containers[0].Image, d.Annotations)
+			}
+
+			// the previous images are put back unless the update finished
+			status.Phase = tt.phase
+			if err := r.updateStatus(update, f.deployment(t, c), status); err != nil {
+				t.Fatal(err)
+			}
+			latestRs := &appsv1.ReplicaSet{}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(f.rs), latestRs); err != nil {
+				t.Fatal(err)
+			}
+			d := f.deployment(t, c)
+			if d.Spec.Template.Spec.Containers[0].Image != tt.image || latestRs.Spec.Template.Spec.Containers[0].Image != tt.image {
+				t.Errorf("expected the templates to run %s, got %s and %s", tt.image,
+					d.Spec.Template.Spec.Containers[0].Image, latestRs.Spec.Template.Spec.Containers[0].Image)
+			}
+			if _, exist := d.Annotations[AnnotationTemplateImagesKey]; exist || latestRs.Annotations[AnnotationTemplateImagesKey] != "" {
+				t.Errorf("expected the saved images to be dropped, got %v %v", d.Annotations, latestRs.Annotations)
+			}
+			// the templates still match, the deployment keeps its replicaset and is resumed
+			if d.Spec.Paused || deploymentutil.FindNewReplicaSet(d, []*appsv1.ReplicaSet{latestRs}) == nil {
+				t.Errorf("expected the resumed deployment to keep its replicaset, got paused %v", d.Spec.Paused)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package inplaceupdate
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+// SetCondition adds or updates a condition, LastTransitionTime is only bumped when the status changes
+func SetCondition(conditions *[]metav1.Condition, generation int64, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+func newStatusFrom(obj *v1.InplaceUpdate) *v1.InplaceUpdateStatus {
+	status := &v1.InplaceUpdateStatus{
+		StartTime:          obj.Status.StartTime,
+		ObservedGeneration: obj.Generation,
+		Conditions:         append([]metav1.Condition(nil), obj.Status.Conditions...),
+		Rollback:           obj.Status.Rollback.DeepCopy(),
+		RecreatedReplicas:  obj.Status.RecreatedReplicas,
+	}
+	for _, wave := range obj.Status.Waves {
+		status.Waves = append(status.Waves, *wave.DeepCopy())
+	}
+	return status
+}
+
+func markFailed(obj *v1.InplaceUpdate, status *v1.InplaceUpdateStatus, reason, message string) {
+	now := metav1.Now()
+	status.Phase = v1.InplaceUpdatePhaseFailed
+	status.CompletionTime = &now
+	SetCondition(&status.Conditions, obj.Generation, v1.InplaceUpdateConditionDegraded, metav1.ConditionTrue, reason, message)
+	SetCondition(&status.Conditions, obj.Generation, v1.InplaceUpdateConditionProgressing, metav1.ConditionFalse, reason, message)
+}
+
+func markProgress(obj *v1.InplaceUpdate, status *v1.InplaceUpdateStatus) {
+	generation := obj.Generation
+	switch status.Phase {
+	case v1.InplaceUpdatePhaseFinished:
+		SetCondition(&status.Conditions, generation, v1.InplaceUpdateConditionProgressing, metav1.ConditionFalse, "UpdateCompleted", "all pods are updated")
+	default:
+		SetCondition(&status.Conditions, generation, v1.InplaceUpdateConditionProgressing, metav1.ConditionTrue, "PodsUpdating", "")
+	}
+	if status.UnavailableReplicas == 0 {
+		SetCondition(&status.Conditions, generation, v1.InplaceUpdateConditionAvailable, metav1.ConditionTrue, "PodsReady", "")
+	} else {
+		SetCondition(&status.Conditions, generation, v1.InplaceUpdateConditionAvailable, metav1.ConditionFalse, "PodsUnavailable", "")
+	}
+	SetCondition(&status.Conditions, generation, v1.InplaceUpdateConditionDegraded, metav1.ConditionFalse, "AsExpected", "")
+}
@@ -0,0 +1,106 @@
+package inplaceupdate
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+func TestSetCondition(t *testing.T) {
+	earlier := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	tests := []struct {
+		name       string
+		status     metav1.ConditionStatus
+		transition bool
+	}{
+		{name: "same status keeps the transition time", status: metav1.ConditionTrue},
+		{name: "new status bumps the transition time", status: metav1.ConditionFalse, transition: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conditions := []metav1.Condition{{Type: v1.InplaceUpdateConditionAvailable, Status: metav1.ConditionTrue,
+				ObservedGeneration: 1, Reason: "PodsReady", LastTransitionTime: earlier}}
+			SetCondition(&conditions, 2, v1.InplaceUpdateConditionAvailable, tt.status, "Reason", "message")
+			if len(conditions) != 1 {
+				t.Fatalf("expected the condition to be updated in place, got %+v", conditions)
+			}
+			got := conditions[0]
+			if got.Status != tt.status || got.ObservedGeneration != 2 || got.Reason != "Reason" || got.Message != "message" {
+				t.Errorf("unexpected condition %+v", got)
+			}
+			if transitioned := !got.LastTransitionTime.Equal(&earlier); transitioned != tt.transition {
+				t.Errorf("expected transition %v, got %v", tt.transition, got.LastTransitionTime)
+			}
+		})
+	}
+}
+
+func TestMarkProgress(t *testing.T) {
+	tests := []struct {
+		name        string
+		phase       v1.InplaceUpdatePhase
+		unavailable int32
+		progressing metav1.ConditionStatus
+		reason      string
+		available   metav1.ConditionStatus
+	}{
+		{name: "running", phase: v1.InplaceUpdatePhaseRunning, unavailable: 1,
+			progressing: metav1.ConditionTrue, reason: "PodsUpdating", available: metav1.ConditionFalse},
+		{name: "running with all pods ready", phase: v1.InplaceUpdatePhaseRunning,
+			progressing: metav1.ConditionTrue, reason: "PodsUpdating", available: metav1.ConditionTrue},
+		{name: "finished", phase: v1.InplaceUpdatePhaseFinished,
+			progressing: metav1.ConditionFalse, reason: "UpdateCompleted", available: metav1.ConditionTrue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &v1.InplaceUpdate{ObjectMeta: metav1.ObjectMeta{Generation: 3}}
+			status := &v1.InplaceUpdateStatus{Phase: tt.phase, UnavailableReplicas: tt.unavailable}
+			markProgress(obj, status)
+			progressing := meta.FindStatusCondition(status.Conditions, v1.InplaceUpdateConditionProgressing)
+			if progressing == nil || progressing.Status != tt.progressing || progressing.Reason != tt.reason || progressing.ObservedGeneration != 3 {
+				t.Errorf("unexpected Progressing condition %+v", progressing)
+			}
+			if available := meta.FindStatusCondition(status.Conditions, v1.InplaceUpdateConditionAvailable); available == nil || available.Status != tt.available {
+				t.Errorf("unexpected Available condition %+v", available)
+			}
+			if !meta.IsStatusConditionFalse(status.Conditions, v1.InplaceUpdateConditionDegraded) {
+				t.Error("expected the update not to be degraded")
+			}
+		})
+	}
+}
+
+func TestMarkFailed(t *testing.T) {
+	tests := []struct {
+		name       string
+		conditions []metav1.Condition
+	}{
+		{name: "without conditions"},
+		{name: "after progress", conditions: []metav1.Condition{
+			{Type: v1.InplaceUpdateConditionProgressing, Status: metav1.ConditionTrue, Reason: "PodsUpdating"},
+			{Type: v1.InplaceUpdateConditionDegraded, Status: metav1.ConditionFalse, Reason: "AsExpected"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &v1.InplaceUpdate{ObjectMeta: metav1.ObjectMeta{Generation: 2}}
+			status := &v1.InplaceUpdateStatus{Phase: v1.InplaceUpdatePhaseRunning, Conditions: tt.conditions}
+			markFailed(obj, status, "PodUpdateFailed", "pod nginx-a not found")
+			if status.Phase != v1.InplaceUpdatePhaseFailed || status.CompletionTime == nil {
+				t.Errorf("expected the update to be failed and completed, got %s at %v", status.Phase, status.CompletionTime)
+			}
+			degraded := meta.FindStatusCondition(status.Conditions, v1.InplaceUpdateConditionDegraded)
+			if degraded == nil || degraded.Status != metav1.ConditionTrue || degraded.Reason != "PodUpdateFailed" || degraded.Message != "pod nginx-a not found" {
+				t.Errorf("unexpected Degraded condition %+v", degraded)
+			}
+			if progressing := meta.FindStatusCondition(status.Conditions, v1.InplaceUpdateConditionProgressing); progressing == nil ||
+				progressing.Status != metav1.ConditionFalse || progressing.Reason != "PodUpdateFailed" {
+				t.Errorf("unexpected Progressing condition %+v", progressing)
+			}
+		})
+	}
+}
@@ -0,0 +1,312 @@
+package inplaceupdate
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+	"github.com/Forget-C/demo/inplaceupdate/program/internal/util"
+)
+
+const LabelClusterInplaceUpdateKey = "demo.cyisme.top/cluster-inplaceupdate"
+
+type RealClusterControl struct {
+	Client client.Client
+	Scheme *runtime.Scheme
+}
+
+func NewRealClusterControl(client client.Client, scheme *runtime.Scheme) *RealClusterControl {
+	return &RealClusterControl{Client: client, Scheme: scheme}
+}
+
+type clusterTarget struct {
+	deployment *appsv1.Deployment
+	args       []v1.InplaceUpdateArgs
+}
+
+func (r *RealClusterControl) Reconcile(ctx context.Context, name string) (ctrl.Result, error) {
+	c := &v1.ClusterInplaceUpdate{}
+	if err := r.Client.Get(ctx, types.NamespacedName{Name: name}, c); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if IsClusterCompleted(c) {
+		return ctrl.Result{}, nil
+	}
+	targets, err := r.selectTargets(ctx, c)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	childList := &v1.InplaceUpdateList{}
+	if err := r.Client.List(ctx, childList, client.MatchingLabels{LabelClusterInplaceUpdateKey: c.Name}); err != nil {
+		return ctrl.Result{}, err
+	}
+	children := make(map[types.NamespacedName]*v1.InplaceUpdate, len(childList.Items))
+	for i := range childList.Items {
+		child := &childList.Items[i]
+		if !metav1.IsControlledBy(child, c) || child.Spec.TargetReference == nil {
+			continue
+		}
+		children[types.NamespacedName{Namespace: child.Namespace, Name: child.Spec.TargetReference.Name}] = child
+	}
+
+	newStatus := &v1.ClusterInplaceUpdateStatus{
+		StartTime:          c.Status.StartTime,
+		ObservedGeneration: c.Generation,
+		Conditions:         append([]metav1.Condition(nil), c.Status.Conditions...),
+	}
+	if newStatus.StartTime == nil {
+		now := metav1.Now()
+		newStatus.StartTime = &now
+	}
+	var pending []clusterTarget
+	for _, target := range targets {
+		key := types.NamespacedName{Namespace: target.deployment.Namespace, Name: target.deployment.Name}
+		if _, exist := children[key]; !exist {
+			pending = append(pending, target)
+		}
+	}
+	for _, child := range children {
+		addClusterChildStatus(newStatus, child)
+	}
+	newStatus.Pending = int32(len(pending))
+
+	maxConcurrency, failureBudget := clusterLimits(c)
+	var errorList []error
+	for _, target := range pending {
+		if newStatus.Running >= maxConcurrency || newStatus.Failed > failureBudget {
+			break
+		}
+		child, err := r.createChild(ctx, c, target)
+		if err != nil {
+			errorList = append(errorList, err)
+			continue
+		}
+		addClusterChildStatus(newStatus, child)
+		newStatus.Pending--
+	}
+	sort.Slice(newStatus.Children, func(i, j int) bool {
+		a, b := newStatus.Children[i], newStatus.Children[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	newStatus.Workloads = int32(len(newStatus.Children)) + newStatus.Pending
+
+	switch {
+	case newStatus.Failed > failureBudget:
+		SetCondition(&newStatus.Conditions, c.Generation, v1.InplaceUpdateConditionDegraded, metav1.ConditionTrue, "FailureBudgetExceeded",
+			fmt.Sprintf("%d child updates failed, budget is %d", newStatus.Failed, failureBudget))
+		if newStatus.Running == 0 {
+			newStatus.Phase = v1.InplaceUpdatePhaseFailed
+			SetCondition(&newStatus.Conditions, c.Generation, v1.InplaceUpdateConditionProgressing, metav1.ConditionFalse, "FailureBudgetExceeded", "")
+		} else {
+			newStatus.Phase = v1.InplaceUpdatePhaseRunning
+			SetCondition(&newStatus.Conditions, c.Generation, v1.InplaceUpdateConditionProgressing, metav1.ConditionTrue, "DrainingChildren", "waiting for running child updates")
+		}
+	case newStatus.Pending == 0 && newStatus.Running == 0:
+		newStatus.Phase = v1.InplaceUpdatePhaseFinished
+		SetCondition(&newStatus.Conditions, c.Generation, v1.InplaceUpdateConditionDegraded, metav1.ConditionFalse, "AsExpected", "")
+		SetCondition(&newStatus.Conditions, c.Generation, v1.InplaceUpdateConditionProgressing, metav1.ConditionFalse, "UpdateCompleted", "all workloads are updated")
+	default:
+		newStatus.Phase = v1.InplaceUpdatePhaseRunning
+		SetCondition(&newStatus.Conditions, c.Generation, v1.InplaceUpdateConditionDegraded, metav1.ConditionFalse, "AsExpected", "")
+		SetCondition(&newStatus.Conditions, c.Generation, v1.InplaceUpdateConditionProgressing, metav1.ConditionTrue, "WorkloadsUpdating", "")
+	}
+	if newStatus.Phase != v1.InplaceUpdatePhaseRunning {
+		now := metav1.Now()
+		newStatus.CompletionTime = &now
+	}
+	if err := r.updateStatus(ctx, c, newStatus); err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(errorList) != 0 || newStatus.Phase == v1.InplaceUpdatePhaseRunning {
+		return ctrl.Result{RequeueAfter: defaultRequeueAfter}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+func (r *RealClusterControl) selectTargets(ctx context.Context, c *v1.ClusterInplaceUpdate) ([]clusterTarget, error) {
+	nsSelector := labels.Everything()
+	if c.Spec.NamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(c.Spec.NamespaceSelector)
+		if err != nil {
+			return nil, fmt.Errorf("clusterinplaceupdate %s has invalid namespaceSelector: %v", c.Name, err)
+		}
+		nsSelector = selector
+	}
+	workloadSelector := labels.Everything()
+	if c.Spec.WorkloadSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(c.Spec.WorkloadSelector)
+		if err != nil {
+			return nil, fmt.Errorf("clusterinplaceupdate %s has invalid workloadSelector: %v", c.Name, err)
+		}
+		workloadSelector = selector
+	}
+	nsList := &corev1.NamespaceList{}
+	if err := r.Client.List(ctx, nsList, &client.ListOptions{LabelSelector: nsSelector}); err != nil {
+		return nil, err
+	}
+	var targets []clusterTarget
+	for _, ns := range nsList.Items {
+		if ns.DeletionTimestamp != nil {
+			continue
+		}
+		dList := &appsv1.DeploymentList{}
+		if err := r.Client.List(ctx, dList, &client.ListOptions{Namespace: ns.Name, LabelSelector: workloadSelector}); err != nil {
+			return nil, err
+		}
+		for i := range dList.Items {
+			d := &dList.Items[i]
+			if d.DeletionTimestamp != nil {
+				continue
+			}
+			args := MatchClusterContainers(d.Spec.Template.Spec, c.Spec.Containers)
+			if len(args) == 0 {
+				continue
+			}
+			targets = append(targets, clusterTarget{deployment: d, args: args})
+		}
+	}
+	return targets, nil
+}
+
+func (r *RealClusterControl) createChild(ctx context.Context, c *v1.ClusterInplaceUpdate, target clusterTarget) (*v1.InplaceUpdate, error) {
+	d := target.deployment
+	child := &v1.InplaceUpdate{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      clusterChildName(c.Name, d.Name),
+			Namespace: d.Namespace,
+			Labels:    map[string]string{LabelClusterInplaceUpdateKey: c.Name},
+		},
+		Spec: v1.InplaceUpdateSpec{
+			TargetReference: &v1.TargetReference{
+				TypeMeta: metav1.TypeMeta{APIVersion: appsv1.SchemeGroupVersion.String(), Kind: "Deployment"},
+				Name:     d.Name,
+			},
+			Containers:     target.args,
+			MaxUnavailable: c.Spec.MaxUnavailable,
+			MaxSurge:       c.Spec.MaxSurge,
+			Ordering:       c.Spec.Ordering,
+			UpdateStrategy: c.Spec.UpdateStrategy,
+			Atomic:         c.Spec.Atomic,
+			Autoscalers:    c.Spec.Autoscalers,
+			Delay:          c.Spec.Delay,
+			FailurePolicy:  c.Spec.FailurePolicy,
+			// workloads already being updated by others are waited for instead of failing the fleet update
+			ConcurrencyPolicy: v1.ConcurrencyPolicyQueue,
+		},
+	}
+	if err := controllerutil.SetControllerReference(c, child, r.Scheme); err != nil {
+		return nil, err
+	}
+	if err := r.Client.Create(ctx, child); err != nil {
+		return nil, err
+	}
+	return child, nil
+}
+
+func (r *RealClusterControl) updateStatus(ctx context.Context, obj *v1.ClusterInplaceUpdate, status *v1.ClusterInplaceUpdateStatus) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		clone := &v1.ClusterInplaceUpdate{}
+		if err := r.Client.Get(ctx, types.NamespacedName{Name: obj.Name}, clone); err != nil {
+			return err
+		}
+		patch := client.MergeFromWithOptions(clone.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		clone.Status = *status
+		return r.Client.Status().Patch(ctx, clone, patch)
+	})
+}
+
+func addClusterChildStatus(status *v1.ClusterInplaceUpdateStatus, child *v1.InplaceUpdate) {
+	status.Children = append(status.Children, v1.ClusterInplaceUpdateChildStatus{
+		Namespace: child.Namespace,
+		Name:      child.Name,
+		Target:    child.Spec.TargetReference.Name,
+		Phase:     child.Status.Phase,
+	})
+	switch child.Status.Phase {
+	// a superseded child left the workload to a newer update of the same workload
+	case v1.InplaceUpdatePhaseFinished, v1.InplaceUpdatePhaseSuperseded:
+		status.Finished++
+	case v1.InplaceUpdatePhaseFailed:
+		status.Failed++
+	default:
+		status.Running++
+	}
+}
+
+func clusterLimits(c *v1.ClusterInplaceUpdate) (maxConcurrency, failureBudget int32) {
+	maxConcurrency = 1
+	if c.Spec.MaxConcurrency != nil && *c.Spec.MaxConcurrency > 0 {
+		maxConcurrency = *c.Spec.MaxConcurrency
+	}
+	if c.Spec.FailureBudget != nil && *c.Spec.FailureBudget > 0 {
+		failureBudget = *c.Spec.FailureBudget
+	}
+	return
+}
+
+// MatchClusterContainers returns the update args for the containers and native sidecars in spec
+// selected by containers. Containers already running the new image are skipped.
+func MatchClusterContainers(spec corev1.PodSpec, containers []v1.ClusterInplaceUpdateContainer) []v1.InplaceUpdateArgs {
+	var args []v1.InplaceUpdateArgs
+	match := func(container corev1.Container, typ v1.ContainerType) {
+		for _, sel := range containers {
+			if sel.Name == "" && sel.ImagePattern == "" {
+				continue
+			}
+			if sel.Name != "" && sel.Name != container.Name {
+				continue
+			}
+			if sel.ImagePattern != "" {
+				// the pattern is validated by the webhook, an invalid one matches nothing
+				if matched, _ := v1.MatchImagePattern(sel.ImagePattern, container.Image); !matched {
+					continue
+				}
+			}
+			if container.Image != sel.Image {
+				args = append(args, v1.InplaceUpdateArgs{Name: container.Name, Image: sel.Image, Type: typ})
+			}
+			return
+		}
+	}
+	for _, container := range spec.Containers {
+		match(container, v1.ContainerTypeContainer)
+	}
+	for i := range spec.InitContainers {
+		if util.IsRestartableInitContainer(&spec.InitContainers[i]) {
+			match(spec.InitContainers[i], v1.ContainerTypeInitContainer)
+		}
+	}
+	return args
+}
+
+func clusterChildName(parent, target string) string {
+	name := fmt.Sprintf("%s-%s", parent, target)
+	if len(name) <= validation.DNS1123SubdomainMaxLength {
+		return name
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name))
+	suffix := fmt.Sprintf("-%08x", h.Sum32())
+	return name[:validation.DNS1123SubdomainMaxLength-len(suffix)] + suffix
+}
@@ -0,0 +1,45 @@
+package inplaceupdate
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+func TestMatchClusterContainers(t *testing.T) {
+	always := corev1.ContainerRestartPolicyAlways
+	spec := corev1.PodSpec{
+		InitContainers: []corev1.Container{{Name: "proxy", Image: "docker.io/envoyproxy/envoy:v1.28.0", RestartPolicy: &always}},
+		Containers: []corev1.Container{
+			{Name: "nginx", Image: "nginx:1.24"},
+			{Name: "envoy", Image: "docker.io/envoyproxy/envoy:v1.28.0"},
+		},
+	}
+	tests := []struct {
+		name       string
+		containers []v1.ClusterInplaceUpdateContainer
+		want       []v1.InplaceUpdateArgs
+	}{
+		{name: "by name", containers: []v1.ClusterInplaceUpdateContainer{{Name: "nginx", Image: "nginx:1.25"}},
+			want: []v1.InplaceUpdateArgs{{Name: "nginx", Image: "nginx:1.25", Type: v1.ContainerTypeContainer}}},
+		{name: "registry qualified image", containers: []v1.ClusterInplaceUpdateContainer{{ImagePattern: "*envoy*", Image: "envoyproxy/envoy:v1.29.1"}},
+			want: []v1.InplaceUpdateArgs{
+				{Name: "envoy", Image: "envoyproxy/envoy:v1.29.1", Type: v1.ContainerTypeContainer},
+				{Name: "proxy", Image: "envoyproxy/envoy:v1.29.1", Type: v1.ContainerTypeInitContainer},
+			}},
+		{name: "name and pattern", containers: []v1.ClusterInplaceUpdateContainer{{Name: "proxy", ImagePattern: "*/envoyproxy/*", Image: "envoyproxy/envoy:v1.29.1"}},
+			want: []v1.InplaceUpdateArgs{{Name: "proxy", Image: "envoyproxy/envoy:v1.29.1", Type: v1.ContainerTypeInitContainer}}},
+		{name: "already on the image", containers: []v1.ClusterInplaceUpdateContainer{{Name: "nginx", Image: "nginx:1.24"}}},
+		{name: "invalid pattern", containers: []v1.ClusterInplaceUpdateContainer{{ImagePattern: "envoy[", Image: "envoyproxy/envoy:v1.29.1"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchClusterContainers(spec, tt.containers); !equality.Semantic.DeepEqual(got, tt.want) {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
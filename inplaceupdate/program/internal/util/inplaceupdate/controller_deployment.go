@@ -2,7 +2,10 @@ package inplaceupdate
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
@@ -11,9 +14,14 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	podutil "k8s.io/kubernetes/pkg/api/v1/pod"
 	deploymentutil "k8s.io/kubernetes/pkg/controller/deployment/util"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
@@ -29,21 +37,34 @@ type RealDeploymentControl struct {
 	patchPodFunc     func(obj *corev1.Pod, latestStatus map[string]*corev1.ContainerStatus, updateSpc *UpdateSpce) (*corev1.Pod, error)
 	patchProcessFunc func(obj *v1.InplaceUpdate, finishedPods, failedPods []*corev1.Pod) (*v1.InplaceUpdate, error)
 	podUpdater       PodUpdater
+	scheduler        *TargetScheduler
+	prometheus       *prometheusClient
+	httpClient       *http.Client
+	recorder         record.EventRecorder
 }
 
-func NewRealDeploymentControl(client client.Client) *RealDeploymentControl {
+func NewRealDeploymentControl(client client.Client, recorder record.EventRecorder, notifier *Notifier) *RealDeploymentControl {
 	controller := &RealDeploymentControl{
 		Client:           client,
-		statusUpdater:    newStatusUpdater(client),
+		recorder:         recorder,
+		statusUpdater:    newStatusUpdater(client, notifier),
 		patchPodFunc:     DefaultPatchPodFunc,
 		podUpdater:       newPodUpdater(client),
 		patchProcessFunc: DefaultPatchProcessFunc,
+		scheduler:        NewTargetScheduler(client),
+		prometheus:       newPrometheusClient(),
+		httpClient:       &http.Client{},
 	}
 	controller.reconcileFunc = controller.doReconcile
 	return controller
 }
 
+// Reconcile runs the update once the scheduler admits it, updates of the same target run one at a time
 func (r *RealDeploymentControl) Reconcile(ctx context.Context, inplaceUpdate types.NamespacedName) (ctrl.Result, error) {
+	admitted, result, err := r.scheduler.Schedule(ctx, inplaceUpdate)
+	if !admitted || err != nil {
+		return result, err
+	}
 	return r.reconcileFunc(ctx, inplaceUpdate)
 }
 
@@ -55,178 +76,424 @@ func (r *RealDeploymentControl) doReconcile(ctx context.Context, inplaceUpdate t
 		}
 		return ctrl.Result{}, err
 	}
-	if IsCompleted(i) {
+	// the target is given back and the autoscalers are restored once the update is completed, or when it is deleted before
+	if IsCompleted(i) || i.DeletionTimestamp != nil {
+		if controllerutil.ContainsFinalizer(i, FinalizerTeardown) {
+			return ctrl.Result{}, r.teardown(ctx, i, i.Status.DeepCopy())
+		}
 		return ctrl.Result{}, nil
 	}
-	if i.Spec.Delay != nil && *i.Spec.Delay > 0 && i.Status.StartTime == nil {
-		return ctrl.Result{RequeueAfter: time.Duration(*i.Spec.Delay) * time.Second}, nil
+	if i.Spec.Abort {
+		return ctrl.Result{}, r.abort(ctx, i)
 	}
-	d := &appsv1.Deployment{}
-	newStatus := &v1.InplaceUpdateStatus{
-		StartTime: &metav1.Time{},
+	if i.Spec.RollbackTo != nil {
+		if resolved, err := r.resolveRollback(ctx, i); !resolved || err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if i.Spec.DryRun {
+		return r.plan(ctx, i)
+	}
+	if i.Spec.Paused {
+		newStatus := i.Status.DeepCopy()
+		newStatus.ObservedGeneration = i.Generation
+		newStatus.QueuePosition = 0
+		newStatus.BlockedBy = ""
+		SetCondition(&newStatus.Conditions, i.Generation, v1.InplaceUpdateConditionPaused, metav1.ConditionTrue, "PausedBySpec", "spec.paused is set")
+		SetCondition(&newStatus.Conditions, i.Generation, v1.InplaceUpdateConditionProgressing, metav1.ConditionFalse, "Paused", "")
+		return ctrl.Result{}, r.statusUpdater.Update(i, newStatus)
 	}
+	if remaining := RemainingDelay(i, time.Now()); remaining > 0 {
+		return ctrl.Result{RequeueAfter: remaining}, nil
+	}
+	d := &appsv1.Deployment{}
+	newStatus := newStatusFrom(i)
 	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: i.Namespace, Name: i.Spec.TargetReference.Name}, d); err != nil {
 		if apierrors.IsNotFound(err) {
-			newStatus.Phase = v1.InplaceUpdatePhaseFailed
-			newStatus.CompletionTime = &metav1.Time{}
-			newStatus.Conditions = append(newStatus.Conditions, v1.InplaceUpdateCondition{
-				Type:    v1.InplaceUpdateConditionFailedOwner,
-				Status:  corev1.ConditionTrue,
-				Reason:  "NotFound",
-				Message: err.Error(),
-			})
+			markFailed(i, newStatus, "TargetNotFound", err.Error())
+			r.completedEvent(i, newStatus)
 			return ctrl.Result{}, r.statusUpdater.Update(i, newStatus)
 		}
 		return ctrl.Result{}, err
 	}
-	if abort := r.preCheck(d, newStatus); abort {
+	nextSchedule, err := NextScheduleTime(i, time.Now())
+	if err != nil {
+		markFailed(i, newStatus, "InvalidSchedule", err.Error())
+		return ctrl.Result{}, r.updateStatus(i, d, newStatus)
+	}
+	if nextSchedule.IsZero() {
+		SetCondition(&newStatus.Conditions, i.Generation, v1.InplaceUpdateConditionPaused, metav1.ConditionFalse, "NotPaused", "")
+	} else {
+		// outside the schedule the update does not start, and once started it does not start new waves
+		next := metav1.NewTime(nextSchedule)
+		newStatus.NextScheduleTime = &next
+		message := fmt.Sprintf("waiting for spec.schedule until %s", nextSchedule.Format(time.RFC3339))
+		SetCondition(&newStatus.Conditions, i.Generation, v1.InplaceUpdateConditionPaused, metav1.ConditionTrue, "OutsideSchedule", message)
+		if newStatus.StartTime == nil {
+			newStatus.Phase = v1.InplaceUpdatePhasePending
+			SetCondition(&newStatus.Conditions, i.Generation, v1.InplaceUpdateConditionProgressing, metav1.ConditionFalse, "OutsideSchedule", message)
+			return ctrl.Result{RequeueAfter: time.Until(nextSchedule)}, r.statusUpdater.Update(i, newStatus)
+		}
+	}
+	if abort := r.preCheck(i, d, newStatus); abort {
 		return ctrl.Result{RequeueAfter: defaultRequeueAfter}, r.statusUpdater.Update(i, newStatus)
 	}
 	var errorList []error
 	for _, target := range i.Spec.Containers {
-		container := util.FindContainer(target.Name, d.Spec.Template.Spec)
-		if container == nil {
-			errorList = append(errorList, fmt.Errorf("container %s not found in deployment %s/%s", target.Name, d.Namespace, d.Name))
-			continue
+		if err := checkTargetContainer(i.Spec, target, d.Spec.Template.Spec); err != nil {
+			errorList = append(errorList, fmt.Errorf("deployment %s/%s: %v", d.Namespace, d.Name, err))
 		}
 	}
 	if len(errorList) != 0 {
-		newStatus.Phase = v1.InplaceUpdatePhaseFailed
-		newStatus.CompletionTime = &metav1.Time{}
-		newStatus.Conditions = append(newStatus.Conditions, v1.InplaceUpdateCondition{
-			Reason:  "NotFound",
-			Message: utilerrors.NewAggregate(errorList).Error(),
-			Type:    v1.InplaceUpdateConditionFailedPods,
-			Status:  corev1.ConditionTrue,
-		})
-		return ctrl.Result{}, r.statusUpdater.Update(i, newStatus)
+		markFailed(i, newStatus, "ContainerNotFound", utilerrors.NewAggregate(errorList).Error())
+		return ctrl.Result{}, r.updateStatus(i, d, newStatus)
 	}
-	newPods, err := r.ownerRefPatchedPods(i, d, newStatus)
+	holder, err := r.acquireTargetLock(i, d)
 	if err != nil {
-		newStatus.Phase = v1.InplaceUpdatePhaseFailed
-		newStatus.CompletionTime = &metav1.Time{}
-		newStatus.Conditions = append(newStatus.Conditions, v1.InplaceUpdateCondition{
-			Type:    v1.InplaceUpdateConditionFailedPods,
-			Status:  corev1.ConditionTrue,
-			Reason:  "Failed",
-			Message: err.Error(),
-		})
-		return ctrl.Result{}, r.statusUpdater.Update(i, newStatus)
+		return ctrl.Result{}, err
+	}
+	if holder != "" {
+		SetCondition(&newStatus.Conditions, i.Generation, v1.InplaceUpdateConditionBlocked, metav1.ConditionTrue, "TargetLocked",
+			fmt.Sprintf("InplaceUpdate %s is updating the target", holder))
+		return ctrl.Result{RequeueAfter: defaultRequeueAfter}, r.statusUpdater.Update(i, newStatus)
+	}
+	if err := r.addTeardownFinalizer(ctx, i); err != nil {
+		return ctrl.Result{}, err
+	}
+	if newStatus.StartTime == nil {
+		now := metav1.Now()
+		newStatus.StartTime = &now
+		r.startedEvent(i)
+	}
+	if err := r.holdAutoscalers(ctx, i, d); err != nil {
+		return ctrl.Result{}, err
 	}
-	syncErr := r.sync(i, newPods, newStatus)
-	if newStatus.UpdatedReplicas == d.Status.Replicas {
+	if i.Spec.Analysis != nil && i.Spec.Analysis.OnFailure == v1.AnalysisFailurePolicyPause {
+		restartFailedAnalysis(newStatus)
+	}
+	newPods, recreatePods, err := r.ownerRefPatchedPods(ctx, i, d, newStatus)
+	var failure *podUpdateError
+	if errors.As(err, &failure) {
+		markFailed(i, newStatus, "PodUpdateFailed", err.Error())
+		return ctrl.Result{}, r.updateStatus(i, d, newStatus)
+	}
+	// other errors, e.g. of the API server or a quota, are retried
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	syncErr := r.sync(i, newPods, recreatePods, newStatus)
+	// pods patched or recreated in this round are only counted as finished once they are observed ready
+	// the pods reverted by an atomic update are left out, they fail the update once the other pods are updated
+	if len(newPods) == 0 && len(recreatePods) == 0 && newStatus.UpdatedReplicas+newStatus.RevertedReplicas == newStatus.Replicas &&
+		newStatus.UnavailableReplicas == 0 && AnalysisPassed(i, newStatus) {
+		now := metav1.Now()
 		newStatus.Phase = v1.InplaceUpdatePhaseFinished
-		newStatus.CompletionTime = &metav1.Time{}
+		newStatus.CompletionTime = &now
 	} else {
 		newStatus.Phase = v1.InplaceUpdatePhaseRunning
 	}
-	err = r.statusUpdater.Update(i, newStatus)
+	markProgress(i, newStatus)
+	if failed := failedAnalysis(newStatus); failed != nil && newStatus.Phase == v1.InplaceUpdatePhaseRunning {
+		message := analysisMessage(failed)
+		if i.Spec.Analysis.OnFailure == v1.AnalysisFailurePolicyPause {
+			if err := r.pause(ctx, i); err != nil {
+				return ctrl.Result{}, err
+			}
+			message += ", the analysis starts over once the update is resumed"
+			SetCondition(&newStatus.Conditions, i.Generation, v1.InplaceUpdateConditionDegraded, metav1.ConditionTrue, "AnalysisFailed", message)
+			SetCondition(&newStatus.Conditions, i.Generation, v1.InplaceUpdateConditionProgressing, metav1.ConditionFalse, "AnalysisFailed", message)
+			SetCondition(&newStatus.Conditions, i.Generation, v1.InplaceUpdateConditionPaused, metav1.ConditionTrue, "AnalysisFailed", message)
+			return ctrl.Result{}, r.statusUpdater.Update(i, newStatus)
+		}
+		if i.Spec.Analysis.OnFailure != v1.AnalysisFailurePolicyAbort {
+			rollback, err := r.rollback(ctx, i, d, newStatus)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			if rollback != "" {
+				message += fmt.Sprintf(", rolled back by InplaceUpdate %s", rollback)
+			}
+		}
+		markFailed(i, newStatus, "AnalysisFailed", message)
+	} else if newStatus.Phase == v1.InplaceUpdatePhaseFinished && newStatus.RevertedReplicas != 0 {
+		markFailed(i, newStatus, "PodsReverted", fmt.Sprintf("pods %s did not come up on the new images and were reverted",
+			strings.Join(sets.List(revertedPodNames(newStatus)), ",")))
+	} else if newStatus.Phase == v1.InplaceUpdatePhaseRunning && newStatus.NextScheduleTime != nil {
+		SetCondition(&newStatus.Conditions, i.Generation, v1.InplaceUpdateConditionProgressing, metav1.ConditionFalse, "OutsideSchedule",
+			"no new wave starts until the next schedule window opens")
+	} else if newStatus.Phase == v1.InplaceUpdatePhaseRunning && ProgressDeadlineExceeded(i, newStatus, time.Now()) {
+		markFailed(i, newStatus, "ProgressDeadlineExceeded",
+			fmt.Sprintf("update has not made progress for more than %ds", *i.Spec.ProgressDeadlineSeconds))
+	}
+	err = r.updateStatus(i, d, newStatus)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
-	if syncErr != nil {
+	if syncErr != nil || newStatus.Phase == v1.InplaceUpdatePhaseRunning {
 		return ctrl.Result{RequeueAfter: defaultRequeueAfter}, nil
 	}
 	return ctrl.Result{}, nil
 }
 
-func (r *RealDeploymentControl) preCheck(d *appsv1.Deployment, status *v1.InplaceUpdateStatus) (abort bool) {
-	status.Phase = v1.InplaceUpdatePhasePending
-	condition := v1.InplaceUpdateCondition{
-		Type:   v1.InplaceUpdateConditionFailedOwner,
-		Status: corev1.ConditionTrue,
-		Reason: "OwnerUnavailable",
+// updateStatus records the revision, gives the target back and reports the end of the update once it is
+// completed, then updates the status
+func (r *RealDeploymentControl) updateStatus(i *v1.InplaceUpdate, d *appsv1.Deployment, status *v1.InplaceUpdateStatus) error {
+	if status.Phase == v1.InplaceUpdatePhaseFinished || status.Phase == v1.InplaceUpdatePhaseFailed {
+		if err := r.recordRevision(context.TODO(), i, d, status); err != nil {
+			return err
+		}
+		if err := r.teardown(context.TODO(), i, status); err != nil {
+			return err
+		}
+		r.completedEvent(i, status)
 	}
+	return r.statusUpdater.Update(i, status)
+}
+
+func (r *RealDeploymentControl) preCheck(i *v1.InplaceUpdate, d *appsv1.Deployment, status *v1.InplaceUpdateStatus) (abort bool) {
+	status.Phase = v1.InplaceUpdatePhasePending
 	if d.DeletionTimestamp != nil {
-		condition.Message = "deployment is being deleted"
-		status.Conditions = append(status.Conditions, condition)
+		SetCondition(&status.Conditions, i.Generation, v1.InplaceUpdateConditionBlocked, metav1.ConditionTrue, "TargetDeleting", "deployment is being deleted")
 		return true
 	}
-	if !deploymentutil.DeploymentComplete(d, &d.Status) {
-		condition.Message = "deployment is not complete"
-		status.Conditions = append(status.Conditions, condition)
+	// pods restarted by a running update make the deployment incomplete, the wave selection
+	// accounts for them through maxUnavailable
+	if !IsRunning(i) && !deploymentutil.DeploymentComplete(d, &d.Status) {
+		SetCondition(&status.Conditions, i.Generation, v1.InplaceUpdateConditionBlocked, metav1.ConditionTrue, "TargetNotComplete", "deployment is not complete")
 		return true
 	}
 	return false
 }
 
-func (r *RealDeploymentControl) sync(i *v1.InplaceUpdate, pods []*corev1.Pod, status *v1.InplaceUpdateStatus) error {
+// sync patches the pods and recreates the pods that can not be updated in place, with InPlaceIfPossible
+// the pods whose patch is rejected are recreated too
+func (r *RealDeploymentControl) sync(i *v1.InplaceUpdate, pods, recreatePods []*corev1.Pod, status *v1.InplaceUpdateStatus) error {
 	finishedPods, failedPods, err := r.podUpdater.Update(pods)
 	if err != nil {
 		return err
 	}
-	containerNames := ContainerNames(i.Spec)
+	if Recreates(i.Spec) {
+		recreatePods = append(recreatePods, failedPods...)
+		failedPods = nil
+	}
+	recreatedPods, notRecreatedPods := r.recreatePods(i, recreatePods)
+	failedPods = append(failedPods, notRecreatedPods...)
 	for _, pod := range finishedPods {
-		finishedContainers := util.FindContainers(containerNames, pod.Spec)
+		// with dependsOn the pod may have containers left to a later round
+		finishedContainers := FindTargetContainers(i.Spec.Containers, pod.Spec)
+		if NeedsUpdate(i.Spec.Containers, finishedContainers) {
+			continue
+		}
 		status.UpdatedContainerNumber += int32(len(finishedContainers))
+		status.UpdatedReplicas++
 	}
 
-	newObj, err := r.patchProcessFunc(i, finishedPods, failedPods)
+	err = retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		latest := &v1.InplaceUpdate{}
+		if err := r.Client.Get(context.Background(), client.ObjectKeyFromObject(i), latest); err != nil {
+			return err
+		}
+		newObj, err := r.patchProcessFunc(latest, finishedPods, failedPods)
+		if err != nil {
+			return err
+		}
+		return r.Client.Patch(context.Background(), newObj, client.MergeFromWithOptions(latest, client.MergeFromWithOptimisticLock{}))
+	})
 	if err != nil {
 		return err
 	}
-	if err := r.Client.Patch(context.Background(), newObj, client.MergeFrom(i)); err != nil {
-		return err
+	status.RecreatedReplicas += int32(len(recreatedPods))
+	if wave := WaveInProgress(status); wave != nil {
+		// the pods of the wave in progress moved on to their next containers
+		wave.RecreatedPods = append(wave.RecreatedPods, util.PodNameList(recreatedPods)...)
+	} else {
+		startWave(status, finishedPods, recreatedPods)
 	}
-	status.UpdatedReplicas += int32(len(finishedPods))
 	return nil
 
 }
 
-func (r *RealDeploymentControl) ownerRefPatchedPods(i *v1.InplaceUpdate, d *appsv1.Deployment, status *v1.InplaceUpdateStatus) ([]*corev1.Pod, error) {
+// podUpdateError is returned by ownerRefPatchedPods when the update can not go on and fails
+type podUpdateError struct {
+	err error
+}
+
+func (e *podUpdateError) Error() string {
+	return e.err.Error()
+}
+
+// ownerRefPatchedPods selects the pods of the next wave, it returns them patched with the new images,
+// and with InPlaceIfPossible the pods of the wave that can not be updated in place apart. While a wave is
+// in progress its pods are returned patched with their next containers, as set by dependsOn.
+// Errors failing the update are a *podUpdateError, the others are transient.
+func (r *RealDeploymentControl) ownerRefPatchedPods(ctx context.Context, i *v1.InplaceUpdate, d *appsv1.Deployment, status *v1.InplaceUpdateStatus) (newPods, recreatePods []*corev1.Pod, err error) {
 	accusedReplicaSets, err := r.getReplicaSetsForDeployment(d)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	curRs := deploymentutil.FindNewReplicaSet(d, accusedReplicaSets)
+	curRs := findNewReplicaSet(i, d, accusedReplicaSets)
 	if curRs == nil {
-		return nil, fmt.Errorf("deployment %s/%s has no new replicaset", d.Namespace, d.Name)
+		return nil, nil, &podUpdateError{fmt.Errorf("deployment %s/%s has no new replicaset", d.Namespace, d.Name)}
 	}
-	if *curRs.Spec.Replicas != *d.Spec.Replicas {
-		return nil, fmt.Errorf("deployment %s/%s has updated replicas, expect %d, got %d", d.Namespace, d.Name, *d.Spec.Replicas, *curRs.Spec.Replicas)
+	// the deployment is paused while the pods are patched, and stays paused while surge pods run
+	// so that no rollout starts alongside them
+	owned, err := r.ownsDeploymentPause(i, d)
+	if err != nil {
+		return nil, nil, err
 	}
-	if !d.Spec.Paused {
-		deployPaused := d.DeepCopy()
-		deployPaused.Spec.Paused = true
-		err = r.Client.Patch(context.Background(), d, client.MergeFrom(deployPaused))
-		if err != nil {
-			return nil, err
+	if owned {
+		if err := r.setDeploymentPaused(d, i, true); err != nil {
+			return nil, nil, err
 		}
+		// on an error the deployment stays paused until the retry, the teardown resumes it in any case
 		defer func() {
-			resumed := deployPaused.DeepCopy()
-			resumed.Spec.Paused = false
-			if err := r.Client.Patch(context.Background(), resumed, client.MergeFrom(deployPaused)); err != nil {
-				log.Log.Error(err, "failed to resume deployment", "deployment", resumed)
+			if err != nil || status.SurgeReplicas != 0 {
+				return
+			}
+			if err := r.setDeploymentPaused(d, i, false); err != nil {
+				log.Log.Error(err, "failed to resume deployment", "deployment", client.ObjectKeyFromObject(d))
 			}
 		}()
 	}
+	// pods created to scale the deployment or to replace deleted pods run the new images
+	if err := r.propagateImages(i, d, curRs); err != nil {
+		return nil, nil, err
+	}
+	// the pods are judged as they are now, the deployment may be scaling and pods may come and go
 	accusedPods, err := r.getPodsForReplicaSet(curRs)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	if len(accusedPods) != int(curRs.Status.Replicas) {
-		return nil, fmt.Errorf("replicaset %s/%s has %d pods, expect %d", curRs.Namespace, curRs.Name, len(accusedPods), curRs.Status.Replicas)
+	var candidates, failedPods []*corev1.Pod
+	livePods, donePods := sets.New[string](), sets.New[string]()
+	revertedPods, wavePods := revertedPodNames(status), sets.New[string]()
+	if wave := WaveInProgress(status); wave != nil {
+		wavePods.Insert(wave.Pods...)
 	}
-	newPods := make([]*corev1.Pod, 0, len(accusedPods))
-	containerNames := ContainerNames(i.Spec)
-	var errorList []error
 	for _, pod := range accusedPods {
-		latestStatus := util.GetLatestContainerStatusMap(pod.Status.ContainerStatuses, containerNames...)
-		accusedContainers := util.FindContainers(containerNames, pod.Spec)
+		livePods.Insert(pod.Name)
+		accusedContainers := updateContainers(i.Spec, pod.Spec)
 		status.ContainerNumber += int32(len(accusedContainers))
-		newPod, err := r.patchPodFunc(pod, latestStatus, &UpdateSpce{Args: i.Spec.Containers, Containers: accusedContainers})
+		ready := podutil.IsPodReady(pod)
+		if !ready {
+			status.UnavailableReplicas++
+		}
+		// a reverted pod is left out of the rest of the update
+		if revertedPods.Has(pod.Name) {
+			status.RevertedReplicas++
+			if ready {
+				donePods.Insert(pod.Name)
+			}
+			continue
+		}
+		// with atomic a pod of the wave is reverted as soon as one of its containers fails, updated or not
+		if i.Spec.Atomic && wavePods.Has(pod.Name) && failedContainer(i.Spec.Containers, pod) != "" {
+			failedPods = append(failedPods, pod)
+			continue
+		}
+		if !NeedsUpdate(i.Spec.Containers, accusedContainers) {
+			status.UpdatedReplicas++
+			status.UpdatedContainerNumber += int32(len(accusedContainers))
+			if ready {
+				donePods.Insert(pod.Name)
+			}
+			continue
+		}
+		candidates = append(candidates, pod)
+	}
+	status.Replicas = int32(len(accusedPods))
+	// pods not created yet, to scale up or to replace deleted pods, are unavailable
+	if missing := *d.Spec.Replicas - status.Replicas; missing > 0 {
+		status.UnavailableReplicas += missing
+	}
+	if len(failedPods) != 0 {
+		reverted := r.revertPods(i, status, failedPods)
+		status.RevertedReplicas += int32(len(reverted))
+		if len(reverted) != 0 && i.Spec.FailurePolicy == v1.FailurePolicyAbort {
+			return nil, nil, &podUpdateError{fmt.Errorf("pods %s did not come up on the new images and were reverted", util.PodNames(reverted))}
+		}
+	}
+	// a wave that recreated pods only completes once the replicaset has replaced them with ready pods
+	if wave := WaveInProgress(status); wave == nil || len(wave.RecreatedPods) == 0 || status.UnavailableReplicas == 0 {
+		completeWave(status, livePods, donePods)
+	}
+	if wave := waveUnderAnalysis(i, status); wave != nil {
+		r.analyze(ctx, i, status, wave, accusedPods, metav1.Now())
+	}
+	surgePods, err := r.getSurgePods(i)
+	if err != nil {
+		return nil, nil, err
+	}
+	status.SurgeReplicas = int32(len(surgePods))
+	containerNames := ContainerNames(i.Spec)
+	if WaveInProgress(status) != nil {
+		// the pods of the wave move on to the containers whose dependencies have come up on their new images
+		for _, pod := range candidates {
+			if !wavePods.Has(pod.Name) {
+				continue
+			}
+			next := nextContainers(i.Spec.Containers, pod, podUpdateState(pod))
+			if len(next) == 0 {
+				continue
+			}
+			latestStatus := util.GetLatestContainerStatusMap(util.AllContainerStatuses(pod.Status), containerNames...)
+			newPod, err := r.patchPodFunc(pod, latestStatus, &UpdateSpce{Args: next, Containers: FindTargetContainers(next, pod.Spec)})
+			if err != nil {
+				log.Log.Error(err, "failed to patch pod", "pod", client.ObjectKeyFromObject(pod))
+				continue
+			}
+			// the snapshot taken when the pod joined the wave is kept, an atomic update reverts to it
+			if state, exist := pod.Annotations[AnnotationStateKey]; exist {
+				newPod.Annotations[AnnotationStateKey] = state
+			}
+			newPods = append(newPods, newPod)
+		}
+		return newPods, nil, nil
+	}
+	// a new wave only starts once the previous one has completed and passed its analysis, and inside the schedule
+	if !AnalysisPassed(i, status) || status.NextScheduleTime != nil {
+		return nil, nil, nil
+	}
+	// the surge pods are kept from the first wave to the last one
+	if len(candidates) == 0 {
+		return nil, nil, r.removeSurge(i, status)
+	}
+	if ready, err := r.scaleSurge(i, curRs, surgePods, status); !ready || err != nil {
+		return nil, nil, err
+	}
+	groups, err := r.orderCandidates(i, candidates)
+	if err != nil {
+		return nil, nil, err
+	}
+	wave, err := r.selectWave(i, groups[0], status, MaxSurge(i.Spec, status.Replicas))
+	if err != nil {
+		return nil, nil, err
+	}
+	newPods = make([]*corev1.Pod, 0, len(wave))
+	var errorList []error
+	for _, pod := range wave {
+		if Recreates(i.Spec) && recreateReason(i.Spec.Containers, pod, curRs.Spec.Template.Spec) != "" {
+			recreatePods = append(recreatePods, pod)
+			continue
+		}
+		// with dependsOn only the containers that depend on no other container left are patched first
+		next := nextContainers(i.Spec.Containers, pod, nil)
+		latestStatus := util.GetLatestContainerStatusMap(util.AllContainerStatuses(pod.Status), containerNames...)
+		newPod, err := r.patchPodFunc(pod, latestStatus, &UpdateSpce{Args: next, Containers: FindTargetContainers(next, pod.Spec)})
 		if err != nil {
+			if Recreates(i.Spec) {
+				recreatePods = append(recreatePods, pod)
+				continue
+			}
 			errorList = append(errorList, err)
-			status.UnavailableReplicas++
 			continue
 		}
 		newPods = append(newPods, newPod)
 	}
-	status.Replicas = int32(len(newPods))
 	if len(errorList) != 0 && i.Spec.FailurePolicy == v1.FailurePolicyAbort {
-		return nil, utilerrors.NewAggregate(errorList)
+		return nil, nil, &podUpdateError{utilerrors.NewAggregate(errorList)}
 	}
-	return newPods, nil
+	return newPods, recreatePods, nil
 }
 
 func (r *RealDeploymentControl) getReplicaSetsForDeployment(deploy *appsv1.Deployment) ([]*appsv1.ReplicaSet, error) {
@@ -239,10 +506,11 @@ func (r *RealDeploymentControl) getReplicaSetsForDeployment(deploy *appsv1.Deplo
 		return nil, err
 	}
 	var accused []*appsv1.ReplicaSet
-	for _, rs := range rsList.Items {
-		controllerRef := metav1.GetControllerOf(&rs)
+	for idx := range rsList.Items {
+		rs := &rsList.Items[idx]
+		controllerRef := metav1.GetControllerOf(rs)
 		if controllerRef != nil && controllerRef.UID == deploy.UID && rs.DeletionTimestamp == nil {
-			accused = append(accused, &rs)
+			accused = append(accused, rs)
 		}
 	}
 	return accused, nil
@@ -258,10 +526,11 @@ func (r *RealDeploymentControl) getPodsForReplicaSet(rs *appsv1.ReplicaSet) ([]*
 		return nil, err
 	}
 	var accused []*corev1.Pod
-	for _, pod := range podList.Items {
-		controllerRef := metav1.GetControllerOf(&pod)
+	for idx := range podList.Items {
+		pod := &podList.Items[idx]
+		controllerRef := metav1.GetControllerOf(pod)
 		if controllerRef != nil && controllerRef.UID == rs.UID && pod.DeletionTimestamp == nil {
-			accused = append(accused, &pod)
+			accused = append(accused, pod)
 		}
 	}
 	return accused, nil
@@ -1,6 +1,13 @@
 package inplaceupdate
 
-import v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+	"github.com/Forget-C/demo/inplaceupdate/program/internal/util"
+)
 
 func ContainerNames(spec v1.InplaceUpdateSpec) []string {
 	names := make([]string, 0, len(spec.Containers))
@@ -9,3 +16,44 @@ func ContainerNames(spec v1.InplaceUpdateSpec) []string {
 	}
 	return names
 }
+
+func IsInitContainer(target v1.InplaceUpdateArgs) bool {
+	return target.Type == v1.ContainerTypeInitContainer
+}
+
+// FindTargetContainer returns the container addressed by target.
+// An error is returned if the container does not exist or can not be updated in place.
+func FindTargetContainer(target v1.InplaceUpdateArgs, spec corev1.PodSpec) (*corev1.Container, error) {
+	switch target.Type {
+	case "", v1.ContainerTypeContainer:
+		if container := util.FindContainer(target.Name, spec); container != nil {
+			return container, nil
+		}
+		return nil, fmt.Errorf("container %s not found", target.Name)
+	case v1.ContainerTypeInitContainer:
+		container := util.FindInitContainer(target.Name, spec)
+		if container == nil {
+			return nil, fmt.Errorf("init container %s not found", target.Name)
+		}
+		if !util.IsRestartableInitContainer(container) {
+			return nil, fmt.Errorf("init container %s is not a restartable sidecar, its image can not be updated in place", target.Name)
+		}
+		return container, nil
+	case v1.ContainerTypeEphemeralContainer:
+		return nil, fmt.Errorf("ephemeral container %s can not be updated in place", target.Name)
+	default:
+		return nil, fmt.Errorf("container %s has unknown type %s", target.Name, target.Type)
+	}
+}
+
+// FindTargetContainers returns the containers addressed by targets, keyed by container name.
+// Containers that can not be updated in place are left out.
+func FindTargetContainers(targets []v1.InplaceUpdateArgs, spec corev1.PodSpec) map[string]*corev1.Container {
+	containers := make(map[string]*corev1.Container)
+	for _, target := range targets {
+		if container, err := FindTargetContainer(target, spec); err == nil {
+			containers[target.Name] = container
+		}
+	}
+	return containers
+}
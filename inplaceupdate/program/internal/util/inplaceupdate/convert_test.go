@@ -0,0 +1,82 @@
+package inplaceupdate
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+func TestFindTargetContainer(t *testing.T) {
+	spec := corev1.PodSpec{
+		InitContainers: []corev1.Container{
+			{Name: "migrate", Image: "migrate:v1"},
+			{Name: "envoy", Image: "envoy:v1", RestartPolicy: ptr.To(corev1.ContainerRestartPolicyAlways)},
+		},
+		Containers: []corev1.Container{{Name: "nginx", Image: "nginx:1.24"}},
+		EphemeralContainers: []corev1.EphemeralContainer{
+			{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debug", Image: "busybox"}},
+		},
+	}
+	tests := []struct {
+		name    string
+		target  v1.InplaceUpdateArgs
+		want    string
+		wantErr bool
+	}{
+		{name: "container without type", target: v1.InplaceUpdateArgs{Name: "nginx"}, want: "nginx:1.24"},
+		{name: "container", target: v1.InplaceUpdateArgs{Name: "nginx", Type: v1.ContainerTypeContainer}, want: "nginx:1.24"},
+		{name: "missing container", target: v1.InplaceUpdateArgs{Name: "envoy"}, wantErr: true},
+		{name: "native sidecar", target: v1.InplaceUpdateArgs{Name: "envoy", Type: v1.ContainerTypeInitContainer}, want: "envoy:v1"},
+		{name: "init container that is not a sidecar", target: v1.InplaceUpdateArgs{Name: "migrate", Type: v1.ContainerTypeInitContainer}, wantErr: true},
+		{name: "missing init container", target: v1.InplaceUpdateArgs{Name: "nginx", Type: v1.ContainerTypeInitContainer}, wantErr: true},
+		{name: "ephemeral container", target: v1.InplaceUpdateArgs{Name: "debug", Type: v1.ContainerTypeEphemeralContainer}, wantErr: true},
+		{name: "unknown type", target: v1.InplaceUpdateArgs{Name: "nginx", Type: "Sidecar"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			container, err := FindTargetContainer(tt.target, spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err == nil && container.Image != tt.want {
+				t.Errorf("expected image %s, got %s", tt.want, container.Image)
+			}
+		})
+	}
+}
+
+func TestDefaultPatchPodFuncInitContainer(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx-a"},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{
+				{Name: "migrate", Image: "migrate:v1"},
+				{Name: "envoy", Image: "envoy:v1", RestartPolicy: ptr.To(corev1.ContainerRestartPolicyAlways)},
+			},
+			Containers: []corev1.Container{{Name: "envoy", Image: "envoy:v1"}, {Name: "nginx", Image: "nginx:1.24"}},
+		},
+	}
+	targets := []v1.InplaceUpdateArgs{{Name: "envoy", Image: "envoy:v2", Type: v1.ContainerTypeInitContainer}}
+	latestStatus := map[string]*corev1.ContainerStatus{"envoy": {Name: "envoy", Image: "envoy:v1"}}
+	patched, err := DefaultPatchPodFunc(pod, latestStatus, &UpdateSpce{Args: targets, Containers: FindTargetContainers(targets, pod.Spec)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the sidecar changes, the container of the same name and the order of the init containers are kept
+	if got := patched.Spec.InitContainers; got[0].Name != "migrate" || got[1].Name != "envoy" || got[1].Image != "envoy:v2" {
+		t.Errorf("expected the envoy sidecar to run envoy:v2 in place, got %+v", got)
+	}
+	if got := patched.Spec.Containers[0].Image; got != "envoy:v1" {
+		t.Errorf("expected the envoy container to be left alone, got %s", got)
+	}
+	if pod.Spec.InitContainers[1].Image != "envoy:v1" {
+		t.Error("expected the pod passed in to be left alone")
+	}
+	if state := podUpdateState(patched); state == nil || state.LastContainerStatuses["envoy"].Image != "envoy:v1" {
+		t.Errorf("expected the state annotation to record the previous image, got %+v", state)
+	}
+}
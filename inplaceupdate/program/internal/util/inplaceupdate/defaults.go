@@ -2,6 +2,7 @@ package inplaceupdate
 
 import (
 	"encoding/json"
+	"sort"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -19,20 +20,21 @@ const (
 
 func DefaultPatchPodFunc(obj *corev1.Pod, latestStatus map[string]*corev1.ContainerStatus, updateSpc *UpdateSpce) (*corev1.Pod, error) {
 	clone := obj.DeepCopy()
-	var containers []corev1.Container
 	for _, target := range updateSpc.Args {
-		container, exist := updateSpc.Containers[target.Name]
-		if !exist || container.Image == target.Image {
+		if _, exist := updateSpc.Containers[target.Name]; !exist {
 			continue
 		}
-		newContainer := container.DeepCopy()
-		newContainer.Image = target.Image
-		containers = append(containers, *newContainer)
+		// the container is looked up again in the clone, so the image is changed in place
+		// and the container order is preserved
+		container, err := FindTargetContainer(target, clone.Spec)
+		if err != nil {
+			return nil, err
+		}
+		container.Image = target.Image
 	}
-	clone.Spec.Containers = util.ContainerMerge(clone.Spec.Containers, containers)
 	state := UpdateState{
 		Revision:              clone.Annotations[deploymentutil.RevisionAnnotation],
-		UpdateTimestamp:       metav1.Time{},
+		UpdateTimestamp:       metav1.Now(),
 		LastContainerStatuses: latestStatus,
 	}
 	stateBytes, _ := json.Marshal(state)
@@ -52,3 +54,33 @@ func DefaultPatchProcessFunc(obj *v1.InplaceUpdate, finishedPods, failedPods []*
 	clone.Annotations[AnnotationFailedKey] = util.PodNames(failedPods)
 	return clone, nil
 }
+
+// PreviousImages returns the images the containers ran before the latest in-place update,
+// as recorded in the state annotation of the pods
+func PreviousImages(pods []*corev1.Pod) []v1.InplaceUpdateArgs {
+	var latest *UpdateState
+	for _, pod := range pods {
+		value, exist := pod.Annotations[AnnotationStateKey]
+		if !exist {
+			continue
+		}
+		state := &UpdateState{}
+		if err := json.Unmarshal([]byte(value), state); err != nil {
+			continue
+		}
+		if latest == nil || latest.UpdateTimestamp.Before(&state.UpdateTimestamp) {
+			latest = state
+		}
+	}
+	if latest == nil {
+		return nil
+	}
+	var containers []v1.InplaceUpdateArgs
+	for name, status := range latest.LastContainerStatuses {
+		if status != nil && status.Image != "" {
+			containers = append(containers, v1.InplaceUpdateArgs{Name: name, Image: status.Image})
+		}
+	}
+	sort.Slice(containers, func(a, b int) bool { return containers[a].Name < containers[b].Name })
+	return containers
+}
@@ -0,0 +1,94 @@
+package inplaceupdate
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+// fixture is the deployment nginx of the default namespace, its replicaset nginx-1 and the update nginx-update
+// setting nginx to nginx:1.25. Tests change what they check before building the client.
+type fixture struct {
+	d      *appsv1.Deployment
+	rs     *appsv1.ReplicaSet
+	update *v1.InplaceUpdate
+}
+
+// newFixture returns a deployment of replicas pods running the containers, nginx:1.24 if none are given
+func newFixture(replicas int32, containers ...corev1.Container) *fixture {
+	if len(containers) == 0 {
+		containers = []corev1.Container{{Name: "nginx", Image: "nginx:1.24"}}
+	}
+	labels := map[string]string{"app": "nginx"}
+	template := corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: labels},
+		Spec:       corev1.PodSpec{Containers: containers},
+	}
+	d := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "nginx", UID: "deployment-uid"},
+		Spec:       appsv1.DeploymentSpec{Replicas: ptr.To(replicas), Selector: &metav1.LabelSelector{MatchLabels: labels}, Template: template},
+	}
+	rsTemplate := template.DeepCopy()
+	rsTemplate.Labels = map[string]string{"app": "nginx", appsv1.DefaultDeploymentUniqueLabelKey: "1"}
+	rs := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "nginx-1", UID: "rs-uid", Labels: rsTemplate.Labels,
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(d, appsv1.SchemeGroupVersion.WithKind("Deployment"))}},
+		Spec: appsv1.ReplicaSetSpec{Replicas: ptr.To(replicas), Selector: &metav1.LabelSelector{MatchLabels: rsTemplate.Labels},
+			Template: *rsTemplate},
+		Status: appsv1.ReplicaSetStatus{Replicas: replicas},
+	}
+	update := &v1.InplaceUpdate{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "nginx-update", UID: "update-uid"},
+		Spec: v1.InplaceUpdateSpec{
+			TargetReference: &v1.TargetReference{
+				TypeMeta: metav1.TypeMeta{APIVersion: v1.TargetAPIVersionDeployment, Kind: v1.TargetKindDeployment}, Name: "nginx"},
+			Containers: []v1.InplaceUpdateArgs{{Name: "nginx", Image: "nginx:1.25"}},
+		},
+	}
+	return &fixture{d: d, rs: rs, update: update}
+}
+
+// pod returns a ready pod of the replicaset running its template
+func (f *fixture) pod(name string) *corev1.Pod {
+	template := f.rs.Spec.Template.DeepCopy()
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Labels: template.Labels,
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(f.rs, appsv1.SchemeGroupVersion.WithKind("ReplicaSet"))}},
+		Spec:   template.Spec,
+		Status: corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}},
+	}
+}
+
+// client returns a fake client holding the deployment, the replicaset, the update and objs
+func (f *fixture) client(objs ...client.Object) client.Client {
+	return f.builder(objs...).Build()
+}
+
+// builder returns the builder of the fake client, to intercept its calls
+func (f *fixture) builder(objs ...client.Object) *fake.ClientBuilder {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = v1.AddToScheme(scheme)
+	objs = append([]client.Object{f.d, f.rs, f.update}, objs...)
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).WithStatusSubresource(f.update)
+}
+
+// deployment returns the deployment as it is now
+func (f *fixture) deployment(t *testing.T, c client.Client) *appsv1.Deployment {
+	t.Helper()
+	latest := &appsv1.Deployment{}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(f.d), latest); err != nil {
+		t.Fatal(err)
+	}
+	return latest
+}
@@ -0,0 +1,83 @@
+package inplaceupdate
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+// AnnotationTargetLockKey is set on the target workload to the name of the InplaceUpdate updating it,
+// so updates of the same workload are serialized even if the admission webhook is bypassed
+const AnnotationTargetLockKey = "demo.cyisme.top/inplaceupdate-lock"
+
+// acquireTargetLock takes the lock of the target for the update. If the lock is held by another
+// update that is not completed, the name of the holder is returned and the lock is not taken.
+// A lock left by an update that is completed or deleted is taken over.
+func (r *RealDeploymentControl) acquireTargetLock(i *v1.InplaceUpdate, d *appsv1.Deployment) (holder string, err error) {
+	err = retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		holder = ""
+		latest := &appsv1.Deployment{}
+		if err := r.Client.Get(context.Background(), client.ObjectKeyFromObject(d), latest); err != nil {
+			return err
+		}
+		current := latest.Annotations[AnnotationTargetLockKey]
+		if current == i.Name {
+			return nil
+		}
+		if current != "" {
+			held, err := r.isLockHeld(i.Namespace, current)
+			if err != nil {
+				return err
+			}
+			if held {
+				holder = current
+				return nil
+			}
+		}
+		patch := client.MergeFromWithOptions(latest.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		if latest.Annotations == nil {
+			latest.Annotations = make(map[string]string)
+		}
+		latest.Annotations[AnnotationTargetLockKey] = i.Name
+		return r.Client.Patch(context.Background(), latest, patch)
+	})
+	return holder, err
+}
+
+// releaseTargetLock removes the lock of the target if it is held by the update
+func (r *RealDeploymentControl) releaseTargetLock(i *v1.InplaceUpdate, d *appsv1.Deployment) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		latest := &appsv1.Deployment{}
+		if err := r.Client.Get(context.Background(), client.ObjectKeyFromObject(d), latest); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		if latest.Annotations[AnnotationTargetLockKey] != i.Name {
+			return nil
+		}
+		patch := client.MergeFromWithOptions(latest.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		delete(latest.Annotations, AnnotationTargetLockKey)
+		return r.Client.Patch(context.Background(), latest, patch)
+	})
+}
+
+// isLockHeld reports whether the named update still exists and is not completed
+func (r *RealDeploymentControl) isLockHeld(namespace, name string) (bool, error) {
+	holder := &v1.InplaceUpdate{}
+	if err := r.Client.Get(context.Background(), types.NamespacedName{Namespace: namespace, Name: name}, holder); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get the holder %s of the target lock: %v", name, err)
+	}
+	return !IsCompleted(holder), nil
+}
@@ -0,0 +1,45 @@
+package inplaceupdate
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+var (
+	// updateInfo has one series per update telling who made it and for which change, the free text reason
+	// is left to the events and the revisions
+	updateInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "inplaceupdate_info",
+		Help: "Information about an InplaceUpdate, who made it and for which change. The value is always 1.",
+	}, []string{"namespace", "name", "target", "initiator", "change_ref"})
+	// revisionsRecorded outlives the updates, so it is only keyed by labels of bounded cardinality
+	revisionsRecorded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "inplaceupdate_revisions_total",
+		Help: "Number of InplaceUpdateRevisions recorded for completed updates, by result.",
+	}, []string{"namespace", "target", "result", "initiator"})
+	notificationsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "inplaceupdate_notifications_total",
+		Help: "Number of notifications sent to the sinks of NotificationConfigs, by result after the retries.",
+	}, []string{"namespace", "config", "sink", "result"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(updateInfo, revisionsRecorded, notificationsSent)
+}
+
+// ObserveMetrics sets the info metric of the update
+func ObserveMetrics(i *v1.InplaceUpdate) {
+	var target string
+	if i.Spec.TargetReference != nil {
+		target = i.Spec.TargetReference.Name
+	}
+	updateInfo.WithLabelValues(i.Namespace, i.Name, target, initiator(i), i.Spec.ChangeRef).Set(1)
+}
+
+// DeleteMetrics drops the info metric of a deleted update
+func DeleteMetrics(inplaceUpdate types.NamespacedName) {
+	updateInfo.DeletePartialMatch(prometheus.Labels{"namespace": inplaceUpdate.Namespace, "name": inplaceUpdate.Name})
+}
@@ -0,0 +1,426 @@
+package inplaceupdate
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+// defaultWebhookTemplate is a Slack compatible message
+const defaultWebhookTemplate = `{"text": {{ json .Summary }}}`
+
+// the deliveries run in the background, a hanging sink must not pile them up
+const notificationTimeout = 10 * time.Second
+
+// Notification is a transition of an update, it is the data of the webhook templates
+// and the body of the CloudEvents
+type Notification struct {
+	Event     v1.NotificationEvent  `json:"event"`
+	Namespace string                `json:"namespace"`
+	Name      string                `json:"name"`
+	Target    string                `json:"target"`
+	Phase     v1.InplaceUpdatePhase `json:"phase"`
+	// Reason is the reason of the condition behind the event, e.g. AnalysisFailed
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message"`
+	// UpdatedReplicas and Replicas are the progress of the update
+	UpdatedReplicas int32 `json:"updatedReplicas"`
+	Replicas        int32 `json:"replicas"`
+	// Initiator, ChangeRef and UpdateReason tell who made the update and why
+	Initiator    string    `json:"initiator,omitempty"`
+	ChangeRef    string    `json:"changeRef,omitempty"`
+	UpdateReason string    `json:"updateReason,omitempty"`
+	Time         time.Time `json:"time"`
+
+	uid types.UID
+}
+
+// Summary is a one line description of the notification
+func (n Notification) Summary() string {
+	s := fmt.Sprintf("InplaceUpdate %s/%s of %s %s", n.Namespace, n.Name, n.Target, n.Event)
+	if n.Message != "" {
+		s += ": " + n.Message
+	}
+	var audit []string
+	if n.Initiator != "" {
+		audit = append(audit, "initiator "+n.Initiator)
+	}
+	if n.ChangeRef != "" {
+		audit = append(audit, "change "+n.ChangeRef)
+	}
+	if n.UpdateReason != "" {
+		audit = append(audit, "reason: "+n.UpdateReason)
+	}
+	if len(audit) != 0 {
+		s += fmt.Sprintf(" (%s)", strings.Join(audit, ", "))
+	}
+	return s
+}
+
+// notifications returns the notified transitions between the status read before the update
+// and the status of the update as written
+func notifications(i *v1.InplaceUpdate, previous *v1.InplaceUpdateStatus) []Notification {
+	status := &i.Status
+	var result []Notification
+	add := func(event v1.NotificationEvent, reason, message string) {
+		n := Notification{
+			Event:           event,
+			Namespace:       i.Namespace,
+			Name:            i.Name,
+			Phase:           status.Phase,
+			Reason:          reason,
+			Message:         message,
+			UpdatedReplicas: status.UpdatedReplicas,
+			Replicas:        status.Replicas,
+			Initiator:       initiator(i),
+			ChangeRef:       i.Spec.ChangeRef,
+			UpdateReason:    i.Spec.Reason,
+			Time:            time.Now(),
+			uid:             i.UID,
+		}
+		if i.Spec.TargetReference != nil {
+			n.Target = i.Spec.TargetReference.Name
+		}
+		result = append(result, n)
+	}
+	if previous.StartTime == nil && status.StartTime != nil {
+		add(v1.NotificationEventStarted, "", "")
+	}
+	if paused := meta.FindStatusCondition(status.Conditions, v1.InplaceUpdateConditionPaused); paused != nil && paused.Status == metav1.ConditionTrue &&
+		!meta.IsStatusConditionTrue(previous.Conditions, v1.InplaceUpdateConditionPaused) {
+		add(v1.NotificationEventPaused, paused.Reason, paused.Message)
+	}
+	if status.Phase != previous.Phase {
+		switch status.Phase {
+		case v1.InplaceUpdatePhaseFailed:
+			reason, message := "", ""
+			if degraded := meta.FindStatusCondition(status.Conditions, v1.InplaceUpdateConditionDegraded); degraded != nil {
+				reason, message = degraded.Reason, degraded.Message
+			}
+			add(v1.NotificationEventFailed, reason, message)
+		case v1.InplaceUpdatePhaseFinished:
+			add(v1.NotificationEventFinished, "", fmt.Sprintf("%d/%d pods updated", status.UpdatedReplicas, status.Replicas))
+		}
+	}
+	return result
+}
+
+// Notifier sends the notifications of the updates to the sinks of the NotificationConfigs of their namespace.
+// It is shared by the reconciles, so a notification sent to a sink is remembered for the dedupe window.
+type Notifier struct {
+	Client     client.Client
+	httpClient *http.Client
+	sendMail   func(ctx context.Context, addr string, a smtp.Auth, from string, to []string, msg []byte) error
+	sleep      func(time.Duration)
+
+	lock sync.Mutex
+	// sent is when the dedupe window of a notification sent to a sink ends
+	sent map[string]time.Time
+}
+
+func NewNotifier(client client.Client) *Notifier {
+	return &Notifier{
+		Client:     client,
+		httpClient: &http.Client{Timeout: notificationTimeout},
+		sendMail:   sendMail,
+		sleep:      time.Sleep,
+		sent:       make(map[string]time.Time),
+	}
+}
+
+// Notify sends the notifications to the sinks of the routes matching the update, the deliveries and their
+// retries run in the background
+func (n *Notifier) Notify(ctx context.Context, i *v1.InplaceUpdate, notifications []Notification) {
+	if len(notifications) == 0 {
+		return
+	}
+	list := &v1.NotificationConfigList{}
+	if err := n.Client.List(ctx, list, client.InNamespace(i.Namespace)); err != nil {
+		log.Log.Error(err, "failed to list the notification configs", "namespace", i.Namespace)
+		return
+	}
+	for idx := range list.Items {
+		config := &list.Items[idx]
+		for _, notification := range notifications {
+			for _, sink := range n.route(config, i.Labels, notification, time.Now()) {
+				go n.deliverWithRetry(config, sink, notification)
+			}
+		}
+	}
+}
+
+// route returns the sinks of the config the notification is sent to. A sink that got the same event
+// of the same update within the dedupe window is left out.
+func (n *Notifier) route(config *v1.NotificationConfig, updateLabels map[string]string, notification Notification, now time.Time) []v1.NotificationSink {
+	names := sets.New[string]()
+	for _, route := range config.Spec.Routes {
+		if len(route.Events) != 0 && !sets.New(route.Events...).Has(notification.Event) {
+			continue
+		}
+		if route.Selector != nil {
+			selector, err := metav1.LabelSelectorAsSelector(route.Selector)
+			if err != nil || !selector.Matches(labels.Set(updateLabels)) {
+				continue
+			}
+		}
+		names.Insert(route.Sinks...)
+	}
+	window := time.Duration(ptr.Deref(config.Spec.DedupeSeconds, v1.DefaultNotificationDedupeSeconds)) * time.Second
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	for key, end := range n.sent {
+		if !now.Before(end) {
+			delete(n.sent, key)
+		}
+	}
+	var sinks []v1.NotificationSink
+	for _, sink := range config.Spec.Sinks {
+		if !names.Has(sink.Name) {
+			continue
+		}
+		key := fmt.Sprintf("%s/%s/%s/%s/%s", config.Namespace, config.Name, sink.Name, notification.uid, notification.Event)
+		if _, exist := n.sent[key]; exist {
+			continue
+		}
+		if window > 0 {
+			n.sent[key] = now.Add(window)
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks
+}
+
+// deliverWithRetry delivers the notification, failed deliveries are retried with an exponential backoff
+func (n *Notifier) deliverWithRetry(config *v1.NotificationConfig, sink v1.NotificationSink, notification Notification) {
+	attempts, backoff := int32(v1.DefaultNotificationRetryAttempts), int32(v1.DefaultNotificationBackoffSeconds)
+	if retry := config.Spec.Retry; retry != nil {
+		attempts = ptr.Deref(retry.Attempts, attempts)
+		backoff = ptr.Deref(retry.BackoffSeconds, backoff)
+	}
+	wait := time.Duration(backoff) * time.Second
+	var err error
+	for attempt := int32(1); ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), notificationTimeout)
+		err = n.deliver(ctx, config.Namespace, sink, notification)
+		cancel()
+		if err == nil {
+			notificationsSent.WithLabelValues(config.Namespace, config.Name, sink.Name, "Delivered").Inc()
+			return
+		}
+		if attempt >= attempts {
+			break
+		}
+		n.sleep(wait)
+		wait *= 2
+	}
+	notificationsSent.WithLabelValues(config.Namespace, config.Name, sink.Name, "Failed").Inc()
+	log.Log.Error(err, "failed to deliver the notification", "notificationconfig", client.ObjectKeyFromObject(config),
+		"sink", sink.Name, "inplaceupdate", types.NamespacedName{Namespace: notification.Namespace, Name: notification.Name},
+		"event", notification.Event, "attempts", attempts)
+}
+
+// deliver sends the notification to the sink once, the secrets are read from the namespace of the config
+func (n *Notifier) deliver(ctx context.Context, namespace string, sink v1.NotificationSink, notification Notification) error {
+	switch {
+	case sink.Webhook != nil:
+		return n.postWebhook(ctx, namespace, sink.Webhook, notification)
+	case sink.SMTP != nil:
+		return n.sendSMTP(ctx, namespace, sink.SMTP, notification)
+	case sink.CloudEvents != nil:
+		return n.postCloudEvent(ctx, sink.CloudEvents, notification)
+	}
+	return fmt.Errorf("sink %s has no webhook, smtp or cloudEvents", sink.Name)
+}
+
+func (n *Notifier) postWebhook(ctx context.Context, namespace string, sink *v1.WebhookSink, notification Notification) error {
+	url := sink.URL
+	if ref := sink.URLSecretRef; ref != nil {
+		value, err := secretValue(ctx, n.Client, namespace, ref)
+		if err != nil {
+			return err
+		}
+		url = strings.TrimSpace(string(value))
+	}
+	text := sink.Template
+	if text == "" {
+		text = defaultWebhookTemplate
+	}
+	body, err := renderNotification(text, notification)
+	if err != nil {
+		return err
+	}
+	return n.post(ctx, url, map[string]string{"Content-Type": "application/json"}, body)
+}
+
+// renderNotification renders the template of a webhook, the result must be a JSON document
+func renderNotification(text string, notification Notification) ([]byte, error) {
+	tmpl, err := template.New("webhook").Option("missingkey=error").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %v", err)
+	}
+	out := &bytes.Buffer{}
+	if err := tmpl.Execute(out, notification); err != nil {
+		return nil, fmt.Errorf("invalid template: %v", err)
+	}
+	if !json.Valid(out.Bytes()) {
+		return nil, fmt.Errorf("template did not render a JSON document: %s", out.String())
+	}
+	return out.Bytes(), nil
+}
+
+func (n *Notifier) sendSMTP(ctx context.Context, namespace string, sink *v1.SMTPSink, notification Notification) error {
+	var auth smtp.Auth
+	if sink.UsernameSecretRef != nil {
+		username, err := secretValue(ctx, n.Client, namespace, sink.UsernameSecretRef)
+		if err != nil {
+			return err
+		}
+		var password []byte
+		if sink.PasswordSecretRef != nil {
+			if password, err = secretValue(ctx, n.Client, namespace, sink.PasswordSecretRef); err != nil {
+				return err
+			}
+		}
+		host, _, err := net.SplitHostPort(sink.Address)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", strings.TrimSpace(string(username)), strings.TrimSpace(string(password)), host)
+	}
+	return n.sendMail(ctx, sink.Address, auth, sink.From, sink.To, mailMessage(sink, notification))
+}
+
+// sendMail is smtp.SendMail bounded by ctx, the connection is closed once ctx is done
+// so that a server that hangs does not hang the delivery
+func sendMail(ctx context.Context, addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if a != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("smtp server %s does not support AUTH", addr)
+		}
+		if err := c.Auth(a); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func mailMessage(sink *v1.SMTPSink, notification Notification) []byte {
+	body := &bytes.Buffer{}
+	fmt.Fprintf(body, "From: %s\r\n", sink.From)
+	fmt.Fprintf(body, "To: %s\r\n", strings.Join(sink.To, ", "))
+	fmt.Fprintf(body, "Subject: [inplaceupdate] %s/%s %s\r\n", notification.Namespace, notification.Name, notification.Event)
+	fmt.Fprintf(body, "Date: %s\r\n", notification.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(body, "MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(body, "%s\r\n\r\n", notification.Summary())
+	fmt.Fprintf(body, "Target: %s\r\nPhase: %s\r\nUpdated: %d/%d\r\n", notification.Target, notification.Phase,
+		notification.UpdatedReplicas, notification.Replicas)
+	return body.Bytes()
+}
+
+// postCloudEvent posts the notification as a CloudEvent in binary content mode
+func (n *Notifier) postCloudEvent(ctx context.Context, sink *v1.CloudEventsSink, notification Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	source := sink.Source
+	if source == "" {
+		source = fmt.Sprintf("/apis/%s/namespaces/%s/inplaceupdates/%s", v1.GroupVersion.String(), notification.Namespace, notification.Name)
+	}
+	return n.post(ctx, sink.URL, map[string]string{
+		"Content-Type":   "application/json",
+		"ce-specversion": "1.0",
+		"ce-id":          string(uuid.NewUUID()),
+		"ce-source":      source,
+		"ce-type":        "top.cyisme.demo.inplaceupdate." + strings.ToLower(string(notification.Event)),
+		"ce-subject":     notification.Target,
+		"ce-time":        notification.Time.UTC().Format(time.RFC3339),
+	}, body)
+}
+
+// post sends the body, any answer but 2xx is an error
+func (n *Notifier) post(ctx context.Context, url string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebMessageBytes))
+		return fmt.Errorf("%s %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
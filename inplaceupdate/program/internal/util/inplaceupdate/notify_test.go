@@ -0,0 +1,248 @@
+package inplaceupdate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+func TestNotifications(t *testing.T) {
+	now := metav1.Now()
+	i := &v1.InplaceUpdate{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "nginx-update", UID: "update-uid",
+			Annotations: map[string]string{v1.AnnotationInitiator: "alice"}},
+		Spec: v1.InplaceUpdateSpec{
+			ChangeRef: "CHG-1234",
+			TargetReference: &v1.TargetReference{
+				TypeMeta: metav1.TypeMeta{APIVersion: v1.TargetAPIVersionDeployment, Kind: v1.TargetKindDeployment}, Name: "nginx"},
+		},
+	}
+	paused := metav1.Condition{Type: v1.InplaceUpdateConditionPaused, Status: metav1.ConditionTrue, Reason: "AnalysisInconclusive", Message: "waiting for a decision"}
+	degraded := metav1.Condition{Type: v1.InplaceUpdateConditionDegraded, Status: metav1.ConditionTrue, Reason: "AnalysisFailed", Message: "error rate too high"}
+
+	tests := []struct {
+		name     string
+		previous v1.InplaceUpdateStatus
+		status   v1.InplaceUpdateStatus
+		events   []v1.NotificationEvent
+	}{
+		{name: "started", status: v1.InplaceUpdateStatus{Phase: v1.InplaceUpdatePhaseRunning, StartTime: &now},
+			events: []v1.NotificationEvent{v1.NotificationEventStarted}},
+		{name: "progress", previous: v1.InplaceUpdateStatus{Phase: v1.InplaceUpdatePhaseRunning, StartTime: &now},
+			status: v1.InplaceUpdateStatus{Phase: v1.InplaceUpdatePhaseRunning, StartTime: &now, UpdatedReplicas: 1}},
+		{name: "paused", previous: v1.InplaceUpdateStatus{Phase: v1.InplaceUpdatePhaseRunning, StartTime: &now},
+			status: v1.InplaceUpdateStatus{Phase: v1.InplaceUpdatePhaseRunning, StartTime: &now, Conditions: []metav1.Condition{paused}},
+			events: []v1.NotificationEvent{v1.NotificationEventPaused}},
+		{name: "still paused", previous: v1.InplaceUpdateStatus{Phase: v1.InplaceUpdatePhaseRunning, StartTime: &now, Conditions: []metav1.Condition{paused}},
+			status: v1.InplaceUpdateStatus{Phase: v1.InplaceUpdatePhaseRunning, StartTime: &now, Conditions: []metav1.Condition{paused}}},
+		{name: "failed", previous: v1.InplaceUpdateStatus{Phase: v1.InplaceUpdatePhaseRunning, StartTime: &now},
+			status: v1.InplaceUpdateStatus{Phase: v1.InplaceUpdatePhaseFailed, StartTime: &now, Conditions: []metav1.Condition{degraded}},
+			events: []v1.NotificationEvent{v1.NotificationEventFailed}},
+		{name: "finished", previous: v1.InplaceUpdateStatus{Phase: v1.InplaceUpdatePhaseRunning, StartTime: &now},
+			status: v1.InplaceUpdateStatus{Phase: v1.InplaceUpdatePhaseFinished, StartTime: &now, Replicas: 2, UpdatedReplicas: 2},
+			events: []v1.NotificationEvent{v1.NotificationEventFinished}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i.Status = tt.status
+			got := notifications(i, &tt.previous)
+			if len(got) != len(tt.events) {
+				t.Fatalf("expected events %v, got %+v", tt.events, got)
+			}
+			for idx, event := range tt.events {
+				if got[idx].Event != event || got[idx].Target != "nginx" || got[idx].Initiator != "alice" || got[idx].uid != "update-uid" {
+					t.Errorf("unexpected notification %+v", got[idx])
+				}
+			}
+		})
+	}
+
+	i.Status = v1.InplaceUpdateStatus{Phase: v1.InplaceUpdatePhaseFailed, Conditions: []metav1.Condition{degraded}}
+	failed := notifications(i, &v1.InplaceUpdateStatus{Phase: v1.InplaceUpdatePhaseRunning})
+	if summary := failed[0].Summary(); summary != "InplaceUpdate default/nginx-update of nginx Failed: error rate too high (initiator alice, change CHG-1234)" {
+		t.Errorf("unexpected summary %q", summary)
+	}
+}
+
+func TestRoute(t *testing.T) {
+	config := &v1.NotificationConfig{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "team"},
+		Spec: v1.NotificationConfigSpec{
+			Sinks: []v1.NotificationSink{
+				{Name: "slack", Webhook: &v1.WebhookSink{URL: "http://slack"}},
+				{Name: "oncall", SMTP: &v1.SMTPSink{Address: "smtp:25", From: "a@b", To: []string{"c@d"}}},
+			},
+			Routes: []v1.NotificationRoute{
+				{Sinks: []string{"slack"}},
+				{Events: []v1.NotificationEvent{v1.NotificationEventFailed},
+					Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "frontend"}}, Sinks: []string{"oncall"}},
+			},
+			DedupeSeconds: ptr.To[int32](60),
+		},
+	}
+	names := func(sinks []v1.NotificationSink) string {
+		var result []string
+		for _, sink := range sinks {
+			result = append(result, sink.Name)
+		}
+		return strings.Join(result, ",")
+	}
+	n := NewNotifier(nil)
+	now := time.Now()
+	frontend := map[string]string{"tier": "frontend"}
+
+	finished := Notification{Event: v1.NotificationEventFinished, uid: "a"}
+	if got := names(n.route(config, frontend, finished, now)); got != "slack" {
+		t.Errorf("expected finished to go to slack, got %q", got)
+	}
+	failed := Notification{Event: v1.NotificationEventFailed, uid: "a"}
+	if got := names(n.route(config, frontend, failed, now)); got != "slack,oncall" {
+		t.Errorf("expected failed to go to slack and oncall, got %q", got)
+	}
+	if got := names(n.route(config, frontend, failed, now.Add(30*time.Second))); got != "" {
+		t.Errorf("expected failed to be deduplicated, got %q", got)
+	}
+	if got := names(n.route(config, frontend, failed, now.Add(time.Minute))); got != "slack,oncall" {
+		t.Errorf("expected failed to be sent again after the dedupe window, got %q", got)
+	}
+	other := Notification{Event: v1.NotificationEventFailed, uid: "b"}
+	if got := names(n.route(config, map[string]string{"tier": "backend"}, other, now)); got != "slack" {
+		t.Errorf("expected failed of a backend update to go to slack only, got %q", got)
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	var received *http.Request
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, _ := io.ReadAll(req.Body)
+		received, body = req, string(data)
+		if req.URL.Path == "/down" {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "notifications", Labels: map[string]string{LabelAllowReferenceKey: "true"}},
+		Data: map[string][]byte{"url": []byte(server.URL + "/hooks/abc\n"),
+			"username": []byte("bot"), "password": []byte("p4ss")},
+	}
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	n := NewNotifier(fake.NewClientBuilder().WithScheme(scheme).WithObjects(secret).Build())
+	selector := func(key string) *corev1.SecretKeySelector {
+		return &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "notifications"}, Key: key}
+	}
+	notification := Notification{Event: v1.NotificationEventFinished, Namespace: "default", Name: "nginx-update", Target: "nginx",
+		Phase: v1.InplaceUpdatePhaseFinished, Message: "2/2 pods updated", UpdatedReplicas: 2, Replicas: 2,
+		Time: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	ctx := context.Background()
+
+	sink := v1.NotificationSink{Name: "slack", Webhook: &v1.WebhookSink{URLSecretRef: selector("url")}}
+	if err := n.deliver(ctx, "default", sink, notification); err != nil {
+		t.Fatal(err)
+	}
+	if received.URL.Path != "/hooks/abc" || body != `{"text": "InplaceUpdate default/nginx-update of nginx Finished: 2/2 pods updated"}` {
+		t.Errorf("unexpected webhook %s %s", received.URL.Path, body)
+	}
+	sink.Webhook = &v1.WebhookSink{URL: server.URL, Template: `{"target": {{ json .Target }}, "updated": {{ .UpdatedReplicas }}}`}
+	if err := n.deliver(ctx, "default", sink, notification); err != nil || body != `{"target": "nginx", "updated": 2}` {
+		t.Errorf("unexpected webhook %s %v", body, err)
+	}
+	sink.Webhook = &v1.WebhookSink{URL: server.URL, Template: `{"target": {{ .Target }}}`}
+	if err := n.deliver(ctx, "default", sink, notification); err == nil || !strings.Contains(err.Error(), "JSON") {
+		t.Errorf("expected a template rendering no JSON to fail, got %v", err)
+	}
+
+	sink = v1.NotificationSink{Name: "events", CloudEvents: &v1.CloudEventsSink{URL: server.URL}}
+	if err := n.deliver(ctx, "default", sink, notification); err != nil {
+		t.Fatal(err)
+	}
+	if received.Header.Get("ce-specversion") != "1.0" || received.Header.Get("ce-id") == "" ||
+		received.Header.Get("ce-type") != "top.cyisme.demo.inplaceupdate.finished" ||
+		received.Header.Get("ce-source") != "/apis/apps.demo.cyisme.top/v1/namespaces/default/inplaceupdates/nginx-update" ||
+		received.Header.Get("ce-subject") != "nginx" || received.Header.Get("ce-time") != "2024-05-01T12:00:00Z" ||
+		!strings.Contains(body, `"event":"Finished"`) {
+		t.Errorf("unexpected cloud event %v %s", received.Header, body)
+	}
+
+	var mail string
+	n.sendMail = func(ctx context.Context, addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		if addr != "smtp.example.com:587" || a == nil || from != "deploy@example.com" || len(to) != 2 {
+			return fmt.Errorf("unexpected mail %s %v %s %v", addr, a, from, to)
+		}
+		mail = string(msg)
+		return nil
+	}
+	sink = v1.NotificationSink{Name: "mail", SMTP: &v1.SMTPSink{Address: "smtp.example.com:587", From: "deploy@example.com",
+		To: []string{"sre@example.com", "dev@example.com"}, UsernameSecretRef: selector("username"), PasswordSecretRef: selector("password")}}
+	if err := n.deliver(ctx, "default", sink, notification); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(mail, "Subject: [inplaceupdate] default/nginx-update Finished\r\n") ||
+		!strings.Contains(mail, "To: sre@example.com, dev@example.com\r\n") || !strings.Contains(mail, "Updated: 2/2\r\n") {
+		t.Errorf("unexpected mail %q", mail)
+	}
+
+	var waits []time.Duration
+	n.sleep = func(d time.Duration) { waits = append(waits, d) }
+	config := &v1.NotificationConfig{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "team"},
+		Spec:       v1.NotificationConfigSpec{Retry: &v1.NotificationRetry{Attempts: ptr.To[int32](3), BackoffSeconds: ptr.To[int32](2)}},
+	}
+	sink = v1.NotificationSink{Name: "down", Webhook: &v1.WebhookSink{URL: server.URL + "/down"}}
+	n.deliverWithRetry(config, sink, notification)
+	if len(waits) != 2 || waits[0] != 2*time.Second || waits[1] != 4*time.Second {
+		t.Errorf("expected 2 retries with an exponential backoff, got %v", waits)
+	}
+	if got := testutil.ToFloat64(notificationsSent.WithLabelValues("default", "team", "down", "Failed")); got != 1 {
+		t.Errorf("expected the failed delivery to be counted once, got %v", got)
+	}
+}
+
+func TestSendMailTimeout(t *testing.T) {
+	// the server accepts the connection and never greets
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- sendMail(ctx, listener.Addr().String(), nil, "a@b", []string{"c@d"}, []byte("hello")) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected the mail to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the mail to give up once the context is done")
+	}
+}
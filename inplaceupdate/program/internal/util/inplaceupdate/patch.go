@@ -0,0 +1,65 @@
+package inplaceupdate
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/Forget-C/demo/inplaceupdate/program/internal/util"
+)
+
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// newPodImagePatch builds a JSON patch that moves the images of live to the images of desired
+// and carries over the inplace update state annotation. Containers are addressed by index and
+// every replace is guarded by a test on the container name, so the container order is never touched.
+// The resourceVersion of live is part of the patch, so the patch fails with a conflict
+// if the pod has changed since it was read.
+func newPodImagePatch(live, desired *corev1.Pod) (client.Patch, error) {
+	ops := []jsonPatchOperation{
+		{Op: "replace", Path: "/metadata/resourceVersion", Value: live.ResourceVersion},
+	}
+	ops = appendImageOperations(ops, "/spec/containers", live.Spec.Containers, desired.Spec.Containers)
+	ops = appendImageOperations(ops, "/spec/initContainers", live.Spec.InitContainers, desired.Spec.InitContainers)
+	if state, exist := desired.Annotations[AnnotationStateKey]; exist {
+		if live.Annotations == nil {
+			ops = append(ops, jsonPatchOperation{Op: "add", Path: "/metadata/annotations", Value: map[string]string{}})
+		}
+		ops = append(ops, jsonPatchOperation{Op: "add", Path: "/metadata/annotations/" + escapeJSONPointer(AnnotationStateKey), Value: state})
+	}
+	data, err := json.Marshal(ops)
+	if err != nil {
+		return nil, err
+	}
+	return client.RawPatch(types.JSONPatchType, data), nil
+}
+
+func appendImageOperations(ops []jsonPatchOperation, basePath string, live, desired []corev1.Container) []jsonPatchOperation {
+	for i := range live {
+		target := findContainerIn(live[i].Name, desired)
+		if target == nil || target.Image == live[i].Image {
+			continue
+		}
+		ops = append(ops,
+			jsonPatchOperation{Op: "test", Path: fmt.Sprintf("%s/%d/name", basePath, i), Value: live[i].Name},
+			jsonPatchOperation{Op: "replace", Path: fmt.Sprintf("%s/%d/image", basePath, i), Value: target.Image},
+		)
+	}
+	return ops
+}
+
+func findContainerIn(name string, containers []corev1.Container) *corev1.Container {
+	return util.FindContainer(name, corev1.PodSpec{Containers: containers})
+}
+
+func escapeJSONPointer(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
@@ -0,0 +1,54 @@
+package inplaceupdate
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestNewPodImagePatch(t *testing.T) {
+	live := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx-a", ResourceVersion: "42"},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "migrate", Image: "migrate:v1"}, {Name: "envoy", Image: "envoy:v1"}},
+			Containers:     []corev1.Container{{Name: "log", Image: "fluentbit:v1"}, {Name: "nginx", Image: "nginx:1.24"}},
+		},
+	}
+	desired := live.DeepCopy()
+	desired.Spec.InitContainers[1].Image = "envoy:v2"
+	// the order of desired does not matter, containers are matched by name
+	desired.Spec.Containers = []corev1.Container{{Name: "nginx", Image: "nginx:1.25"}, {Name: "log", Image: "fluentbit:v1"}}
+	desired.Annotations = map[string]string{AnnotationStateKey: `{"revision":"1"}`}
+
+	patch, err := newPodImagePatch(live, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patch.Type() != types.JSONPatchType {
+		t.Errorf("expected a JSON patch, got %s", patch.Type())
+	}
+	data, err := patch.Data(live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ops []jsonPatchOperation
+	if err := json.Unmarshal(data, &ops); err != nil {
+		t.Fatal(err)
+	}
+	want := []jsonPatchOperation{
+		{Op: "replace", Path: "/metadata/resourceVersion", Value: "42"},
+		{Op: "test", Path: "/spec/containers/1/name", Value: "nginx"},
+		{Op: "replace", Path: "/spec/containers/1/image", Value: "nginx:1.25"},
+		{Op: "test", Path: "/spec/initContainers/1/name", Value: "envoy"},
+		{Op: "replace", Path: "/spec/initContainers/1/image", Value: "envoy:v2"},
+		{Op: "add", Path: "/metadata/annotations", Value: map[string]interface{}{}},
+		{Op: "add", Path: "/metadata/annotations/demo.cyisme.top~1inplaceupdate-state", Value: `{"revision":"1"}`},
+	}
+	if !reflect.DeepEqual(ops, want) {
+		t.Errorf("unexpected patch\n got %+v\nwant %+v", ops, want)
+	}
+}
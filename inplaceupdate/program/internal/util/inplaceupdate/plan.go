@@ -0,0 +1,236 @@
+package inplaceupdate
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	podutil "k8s.io/kubernetes/pkg/api/v1/pod"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+	"github.com/Forget-C/demo/inplaceupdate/program/internal/util"
+)
+
+// plan goes through the same checks and pod selection as the update, without pausing the target
+// or patching any pod, and records the outcome in status.plan. The patch of every pod to be updated
+// is sent as a server-side dry run, so the API server and its admission webhooks confirm it would be accepted.
+func (r *RealDeploymentControl) plan(ctx context.Context, i *v1.InplaceUpdate) (ctrl.Result, error) {
+	status := newStatusFrom(i)
+	plan := &v1.InplaceUpdatePlan{}
+	status.Plan = plan
+	if err := r.makePlan(ctx, i, status); err != nil {
+		return ctrl.Result{}, err
+	}
+	status.Phase = v1.InplaceUpdatePhasePlanned
+	SetCondition(&status.Conditions, i.Generation, v1.InplaceUpdateConditionProgressing, metav1.ConditionFalse, "DryRun",
+		"no pod is updated while spec.dryRun is set")
+	// the plan time only moves when the plan changes, so an unchanged plan does not trigger another reconcile
+	if last := i.Status.Plan; last != nil {
+		plan.PlanTime = last.PlanTime
+	}
+	if plan.PlanTime == nil || !equality.Semantic.DeepEqual(plan, i.Status.Plan) {
+		now := metav1.Now()
+		plan.PlanTime = &now
+	}
+	return ctrl.Result{RequeueAfter: defaultRequeueAfter}, r.statusUpdater.Update(i, status)
+}
+
+func (r *RealDeploymentControl) makePlan(ctx context.Context, i *v1.InplaceUpdate, status *v1.InplaceUpdateStatus) error {
+	plan := status.Plan
+	d := &appsv1.Deployment{}
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: i.Namespace, Name: i.Spec.TargetReference.Name}, d); err != nil {
+		if apierrors.IsNotFound(err) {
+			plan.Blockers = append(plan.Blockers, fmt.Sprintf("deployment %s/%s not found", i.Namespace, i.Spec.TargetReference.Name))
+			return nil
+		}
+		return err
+	}
+	if abort := r.preCheck(i, d, status); abort {
+		if blocked := meta.FindStatusCondition(status.Conditions, v1.InplaceUpdateConditionBlocked); blocked != nil {
+			plan.Blockers = append(plan.Blockers, blocked.Message)
+		}
+	}
+	for _, target := range i.Spec.Containers {
+		if err := checkTargetContainer(i.Spec, target, d.Spec.Template.Spec); err != nil {
+			plan.Blockers = append(plan.Blockers, fmt.Sprintf("deployment %s/%s: %v", d.Namespace, d.Name, err))
+		}
+	}
+	nextSchedule, err := NextScheduleTime(i, time.Now())
+	if err != nil {
+		plan.Blockers = append(plan.Blockers, fmt.Sprintf("invalid schedule: %v", err))
+	} else if !nextSchedule.IsZero() {
+		plan.Blockers = append(plan.Blockers, fmt.Sprintf("spec.schedule holds the update until %s", nextSchedule.Format(time.RFC3339)))
+	}
+	blocker, err := r.concurrencyBlocker(ctx, i)
+	if err != nil {
+		return err
+	}
+	if blocker != "" {
+		plan.Blockers = append(plan.Blockers, blocker)
+	}
+
+	replicaSets, err := r.getReplicaSetsForDeployment(d)
+	if err != nil {
+		return err
+	}
+	curRs := findNewReplicaSet(i, d, replicaSets)
+	if curRs == nil {
+		plan.Blockers = append(plan.Blockers, fmt.Sprintf("deployment %s/%s has no new replicaset", d.Namespace, d.Name))
+		return nil
+	}
+	plan.ReplicaSet = curRs.Name
+	pods, err := r.getPodsForReplicaSet(curRs)
+	if err != nil {
+		return err
+	}
+	var candidates []*corev1.Pod
+	podPlans := make(map[string]int, len(pods))
+	for _, pod := range pods {
+		containers := updateContainers(i.Spec, pod.Spec)
+		status.ContainerNumber += int32(len(containers))
+		if !podutil.IsPodReady(pod) {
+			status.UnavailableReplicas++
+		}
+		changes := containerChanges(i.Spec.Containers, containers)
+		podPlans[pod.Name] = len(plan.Pods)
+		plan.Pods = append(plan.Pods, v1.InplaceUpdatePodPlan{Name: pod.Name, Changes: changes})
+		if len(changes) == 0 {
+			status.UpdatedReplicas++
+			status.UpdatedContainerNumber += int32(len(containers))
+			continue
+		}
+		candidates = append(candidates, pod)
+	}
+	status.Replicas = int32(len(pods))
+	plan.MaxUnavailable = MaxUnavailable(i.Spec, status.Replicas)
+	plan.MaxSurge = MaxSurge(i.Spec, status.Replicas)
+
+	groups, err := r.orderCandidates(i, candidates)
+	if err != nil {
+		return err
+	}
+	waves, err := r.planWaves(i, groups, status)
+	if err != nil {
+		return err
+	}
+	plan.Waves = int32(len(waves))
+	containerNames := ContainerNames(i.Spec)
+	for number, wave := range waves {
+		for _, pod := range wave {
+			podPlan := &plan.Pods[podPlans[pod.Name]]
+			podPlan.Wave = int32(number) + 1
+			if Recreates(i.Spec) {
+				if reason := recreateReason(i.Spec.Containers, pod, curRs.Spec.Template.Spec); reason != "" {
+					podPlan.Recreate, podPlan.Message = true, reason
+					continue
+				}
+			}
+			latestStatus := util.GetLatestContainerStatusMap(util.AllContainerStatuses(pod.Status), containerNames...)
+			desired, err := r.patchPodFunc(pod, latestStatus, &UpdateSpce{Args: i.Spec.Containers, Containers: FindTargetContainers(i.Spec.Containers, pod.Spec)})
+			if err != nil {
+				podPlan.Message = err.Error()
+				continue
+			}
+			patch, err := newPodImagePatch(pod, desired)
+			if err != nil {
+				podPlan.Message = err.Error()
+				continue
+			}
+			if err := r.Client.Patch(ctx, pod.DeepCopy(), patch, client.DryRunAll); err != nil {
+				podPlan.Message = err.Error()
+				podPlan.Recreate = Recreates(i.Spec)
+				continue
+			}
+			podPlan.Accepted = true
+		}
+	}
+	return nil
+}
+
+// planWaves splits the ordered groups of pods to be updated into waves. The first wave is selected as the
+// update would select it now with the surge pods ready, the next ones assume the pods of the previous wave
+// are ready again, so each of them takes up to maxUnavailable plus maxSurge pods of the same group.
+func (r *RealDeploymentControl) planWaves(i *v1.InplaceUpdate, groups [][]*corev1.Pod, status *v1.InplaceUpdateStatus) ([][]*corev1.Pod, error) {
+	if len(groups) == 0 {
+		return nil, nil
+	}
+	first, err := r.selectWave(i, groups[0], status, status.Plan.MaxSurge)
+	if err != nil {
+		return nil, err
+	}
+	if blocked := meta.FindStatusCondition(status.Conditions, v1.InplaceUpdateConditionBlocked); blocked != nil && blocked.Status == metav1.ConditionTrue {
+		status.Plan.Blockers = append(status.Plan.Blockers, blocked.Message)
+	}
+	var waves [][]*corev1.Pod
+	selected := make(map[string]bool, len(first))
+	if len(first) != 0 {
+		waves = append(waves, first)
+		for _, pod := range first {
+			selected[pod.Name] = true
+		}
+	}
+	size := int(status.Plan.MaxUnavailable + status.Plan.MaxSurge)
+	for _, group := range groups {
+		var wave []*corev1.Pod
+		for _, pod := range group {
+			if selected[pod.Name] {
+				continue
+			}
+			wave = append(wave, pod)
+			if len(wave) == size {
+				waves = append(waves, wave)
+				wave = nil
+			}
+		}
+		if len(wave) != 0 {
+			waves = append(waves, wave)
+		}
+	}
+	return waves, nil
+}
+
+// concurrencyBlocker describes the update the planned one would have to wait for, if any
+func (r *RealDeploymentControl) concurrencyBlocker(ctx context.Context, i *v1.InplaceUpdate) (string, error) {
+	queue, err := TargetQueue(ctx, r.Client, i.Namespace, i.Spec.TargetReference.Name)
+	if err != nil {
+		return "", err
+	}
+	if len(queue) == 0 {
+		return "", nil
+	}
+	if i.Spec.ConcurrencyPolicy == v1.ConcurrencyPolicyQueue || i.Spec.Supersede {
+		return fmt.Sprintf("InplaceUpdate %s is updating the target, the update would be queued", queue[0].Name), nil
+	}
+	return fmt.Sprintf("InplaceUpdate %s is updating the target, running the update would be denied unless spec.concurrencyPolicy is %s",
+		queue[0].Name, v1.ConcurrencyPolicyQueue), nil
+}
+
+func containerChanges(targets []v1.InplaceUpdateArgs, containers map[string]*corev1.Container) []v1.InplaceUpdateContainerChange {
+	var changes []v1.InplaceUpdateContainerChange
+	for _, target := range targets {
+		container, exist := containers[target.Name]
+		if !exist || container.Image == target.Image {
+			continue
+		}
+		changeType := target.Type
+		if changeType == "" {
+			changeType = v1.ContainerTypeContainer
+		}
+		changes = append(changes, v1.InplaceUpdateContainerChange{
+			Name: target.Name,
+			Type: changeType,
+			From: container.Image,
+			To:   target.Image,
+		})
+	}
+	return changes
+}
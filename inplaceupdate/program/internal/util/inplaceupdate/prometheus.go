@@ -0,0 +1,95 @@
+package inplaceupdate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// prometheusClient runs instant queries against a Prometheus compatible HTTP API
+type prometheusClient struct {
+	httpClient *http.Client
+}
+
+func newPrometheusClient() *prometheusClient {
+	return &prometheusClient{httpClient: &http.Client{Timeout: 30 * time.Second}}
+}
+
+type prometheusResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Data      struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+// Query evaluates the query at the given time, the result must be a scalar or a vector with a single sample
+func (c *prometheusClient) Query(ctx context.Context, address, query string, at time.Time) (float64, error) {
+	endpoint, err := url.Parse(address)
+	if err != nil {
+		return 0, err
+	}
+	endpoint = endpoint.JoinPath("api", "v1", "query")
+	form := url.Values{
+		"query": {query},
+		"time":  {strconv.FormatFloat(float64(at.UnixMilli())/1000, 'f', 3, 64)},
+	}
+	// the query is posted as a form, the pod regular expression can make it too long for a URL
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body := &prometheusResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(body); err != nil {
+		return 0, fmt.Errorf("unexpected response %s from %s", resp.Status, endpoint.Redacted())
+	}
+	if body.Status != "success" {
+		return 0, fmt.Errorf("query failed with %s: %s", body.ErrorType, body.Error)
+	}
+	switch body.Data.ResultType {
+	case "scalar":
+		var sample []interface{}
+		if err := json.Unmarshal(body.Data.Result, &sample); err != nil {
+			return 0, err
+		}
+		return sampleValue(sample)
+	case "vector":
+		var series []struct {
+			Value []interface{} `json:"value"`
+		}
+		if err := json.Unmarshal(body.Data.Result, &series); err != nil {
+			return 0, err
+		}
+		if len(series) != 1 {
+			return 0, fmt.Errorf("query returned %d series, expected 1", len(series))
+		}
+		return sampleValue(series[0].Value)
+	default:
+		return 0, fmt.Errorf("query returned a %s, expected a scalar or a vector", body.Data.ResultType)
+	}
+}
+
+// sampleValue parses a [timestamp, "value"] pair, the value can be NaN or +Inf
+func sampleValue(sample []interface{}) (float64, error) {
+	if len(sample) != 2 {
+		return 0, fmt.Errorf("invalid sample %v", sample)
+	}
+	value, ok := sample[1].(string)
+	if !ok {
+		return 0, fmt.Errorf("invalid sample value %v", sample[1])
+	}
+	return strconv.ParseFloat(value, 64)
+}
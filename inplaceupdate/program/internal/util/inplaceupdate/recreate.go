@@ -0,0 +1,111 @@
+package inplaceupdate
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+	"github.com/Forget-C/demo/inplaceupdate/program/internal/util"
+)
+
+// Recreates reports whether the pods that can not be updated in place are recreated
+func Recreates(spec v1.InplaceUpdateSpec) bool {
+	return spec.UpdateStrategy == v1.UpdateStrategyInPlaceIfPossible
+}
+
+// recreateContainer returns the init container addressed by target if it is not a restartable sidecar,
+// its image is only changed by recreating the pod
+func recreateContainer(target v1.InplaceUpdateArgs, spec corev1.PodSpec) *corev1.Container {
+	if target.Type != v1.ContainerTypeInitContainer {
+		return nil
+	}
+	container := util.FindInitContainer(target.Name, spec)
+	if container == nil || util.IsRestartableInitContainer(container) {
+		return nil
+	}
+	return container
+}
+
+// checkTargetContainer returns an error if the container addressed by target can not be updated.
+// With InPlaceIfPossible the init containers that are not restartable sidecars are updated by recreating the pods.
+func checkTargetContainer(spec v1.InplaceUpdateSpec, target v1.InplaceUpdateArgs, podSpec corev1.PodSpec) error {
+	_, err := FindTargetContainer(target, podSpec)
+	if err != nil && Recreates(spec) && recreateContainer(target, podSpec) != nil {
+		return nil
+	}
+	return err
+}
+
+// updateContainers returns the containers addressed by the update keyed by container name. With InPlaceIfPossible
+// they include the init containers that are only updated by recreating the pod.
+func updateContainers(spec v1.InplaceUpdateSpec, podSpec corev1.PodSpec) map[string]*corev1.Container {
+	containers := FindTargetContainers(spec.Containers, podSpec)
+	if Recreates(spec) {
+		for _, target := range spec.Containers {
+			if container := recreateContainer(target, podSpec); container != nil {
+				containers[target.Name] = container
+			}
+		}
+	}
+	return containers
+}
+
+// recreateReason tells why the pod can not be updated in place, empty if it can: an init container that is not
+// a restartable sidecar runs another image, or a container differs from the template in fields other than the image
+func recreateReason(targets []v1.InplaceUpdateArgs, pod *corev1.Pod, template corev1.PodSpec) string {
+	for _, target := range targets {
+		if container := recreateContainer(target, pod.Spec); container != nil {
+			if container.Image != target.Image {
+				return fmt.Sprintf("init container %s is not a restartable sidecar", target.Name)
+			}
+			continue
+		}
+		container, err := FindTargetContainer(target, pod.Spec)
+		if err != nil {
+			continue
+		}
+		templateContainer, err := FindTargetContainer(target, template)
+		if err != nil {
+			continue
+		}
+		if !equalButImage(container, templateContainer) {
+			return fmt.Sprintf("container %s differs from the template in fields other than the image", target.Name)
+		}
+	}
+	return ""
+}
+
+// equalButImage compares the fields of the containers a recreated pod would change. Fields defaulted or
+// injected into the pods by admission, e.g. the resources or the volume mounts, are left out.
+func equalButImage(a, b *corev1.Container) bool {
+	return equality.Semantic.DeepEqual(a.Command, b.Command) &&
+		equality.Semantic.DeepEqual(a.Args, b.Args) &&
+		a.WorkingDir == b.WorkingDir &&
+		equality.Semantic.DeepEqual(a.Env, b.Env) &&
+		equality.Semantic.DeepEqual(a.Ports, b.Ports)
+}
+
+// recreatePods deletes the pods so that the replicaset recreates them from its template, which runs the new
+// images. The pods that could not be deleted are returned as failed.
+func (r *RealDeploymentControl) recreatePods(i *v1.InplaceUpdate, pods []*corev1.Pod) (recreatedPods, failedPods []*corev1.Pod) {
+	for _, pod := range pods {
+		uid := pod.UID
+		if err := r.Client.Delete(context.TODO(), pod, client.Preconditions{UID: &uid}); err != nil && !apierrors.IsNotFound(err) {
+			log.Log.Error(err, "failed to recreate pod", "pod", client.ObjectKeyFromObject(pod))
+			failedPods = append(failedPods, pod)
+			continue
+		}
+		recreatedPods = append(recreatedPods, pod)
+	}
+	if len(recreatedPods) != 0 {
+		r.recorder.AnnotatedEventf(i, auditAnnotations(i), corev1.EventTypeNormal, "PodsRecreated", "%s",
+			withAudit(i, fmt.Sprintf("pods %s can not be updated in place and were recreated", util.PodNames(recreatedPods))))
+	}
+	return recreatedPods, failedPods
+}
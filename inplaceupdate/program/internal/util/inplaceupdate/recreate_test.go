@@ -0,0 +1,101 @@
+package inplaceupdate
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+func TestRecreateReason(t *testing.T) {
+	always := corev1.ContainerRestartPolicyAlways
+	template := corev1.PodSpec{
+		InitContainers: []corev1.Container{{Name: "migrate", Image: "migrate:v1"}, {Name: "envoy", Image: "envoy:v1", RestartPolicy: &always}},
+		Containers:     []corev1.Container{{Name: "nginx", Image: "nginx:1.24", Env: []corev1.EnvVar{{Name: "MODE", Value: "a"}}}},
+	}
+	drifted := template.DeepCopy()
+	drifted.Containers[0].Env[0].Value = "b"
+	tests := []struct {
+		name     string
+		target   v1.InplaceUpdateArgs
+		pod      *corev1.PodSpec
+		recreate bool
+	}{
+		{name: "image only", target: v1.InplaceUpdateArgs{Name: "nginx", Image: "nginx:1.25"}, pod: &template},
+		{name: "env differs", target: v1.InplaceUpdateArgs{Name: "nginx", Image: "nginx:1.25"}, pod: drifted, recreate: true},
+		{name: "init container", target: v1.InplaceUpdateArgs{Name: "migrate", Image: "migrate:v2", Type: v1.ContainerTypeInitContainer}, pod: &template, recreate: true},
+		{name: "init container up to date", target: v1.InplaceUpdateArgs{Name: "migrate", Image: "migrate:v1", Type: v1.ContainerTypeInitContainer}, pod: &template},
+		{name: "sidecar", target: v1.InplaceUpdateArgs{Name: "envoy", Image: "envoy:v2", Type: v1.ContainerTypeInitContainer}, pod: &template},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := recreateReason([]v1.InplaceUpdateArgs{tt.target}, &corev1.Pod{Spec: *tt.pod}, template)
+			if (reason != "") != tt.recreate {
+				t.Errorf("expected recreate %v, got %q", tt.recreate, reason)
+			}
+		})
+	}
+}
+
+func TestRecreate(t *testing.T) {
+	f := newFixture(2)
+	f.update.Spec.MaxUnavailable = ptr.To(intstr.FromInt32(2))
+	f.update.Spec.UpdateStrategy = v1.UpdateStrategyInPlaceIfPossible
+	// nginx-b runs another command than the template, it can not be made to match the template in place
+	podA, podB := f.pod("nginx-a"), f.pod("nginx-b")
+	podB.Spec.Containers[0].Command = []string{"nginx", "-g", "daemon off;"}
+	c := f.client(podA, podB)
+	r := NewRealDeploymentControl(c, &record.FakeRecorder{}, nil)
+	ctx := context.Background()
+	update, rs := f.update, f.rs
+	deployment := func() *appsv1.Deployment { return f.deployment(t, c) }
+
+	// nginx-a is patched in place, nginx-b is recreated
+	status := newStatusFrom(update)
+	newPods, recreatePods, err := r.ownerRefPatchedPods(ctx, update, deployment(), status)
+	if err != nil || len(newPods) != 1 || newPods[0].Name != "nginx-a" || len(recreatePods) != 1 || recreatePods[0].Name != "nginx-b" {
+		t.Fatalf("expected nginx-a to be patched and nginx-b to be recreated, got %d and %d pods, %v", len(newPods), len(recreatePods), err)
+	}
+	// the images are in both templates, which still match
+	latestRs := &appsv1.ReplicaSet{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(rs), latestRs); err != nil {
+		t.Fatal(err)
+	}
+	latest := deployment()
+	if latestRs.Spec.Template.Spec.Containers[0].Image != "nginx:1.25" || latest.Spec.Template.Spec.Containers[0].Image != "nginx:1.25" {
+		t.Errorf("expected the images to be set in the templates, got %s and %s",
+			latestRs.Spec.Template.Spec.Containers[0].Image, latest.Spec.Template.Spec.Containers[0].Image)
+	}
+	if found := findNewReplicaSet(update, latest, []*appsv1.ReplicaSet{latestRs}); found == nil {
+		t.Error("expected the replicaset to stay the new replicaset of the deployment")
+	}
+
+	if err := r.sync(update, newPods, recreatePods, status); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(podB), &corev1.Pod{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected nginx-b to be deleted, got %v", err)
+	}
+	if len(status.Waves) != 1 || len(status.Waves[0].Pods) != 2 || len(status.Waves[0].RecreatedPods) != 1 ||
+		status.Waves[0].RecreatedPods[0] != "nginx-b" || status.RecreatedReplicas != 1 {
+		t.Errorf("expected a wave of both pods with nginx-b recreated, got %+v", status.Waves)
+	}
+
+	// the wave waits for the replicaset to replace nginx-b, the missing pod counts as unavailable
+	update.Status = *status
+	status = newStatusFrom(update)
+	if _, _, err := r.ownerRefPatchedPods(ctx, update, deployment(), status); err != nil {
+		t.Fatal(err)
+	}
+	if status.UnavailableReplicas != 1 || WaveInProgress(status) == nil || status.RecreatedReplicas != 1 {
+		t.Errorf("expected the wave to wait for the recreated pod, got %d unavailable, waves %+v", status.UnavailableReplicas, status.Waves)
+	}
+}
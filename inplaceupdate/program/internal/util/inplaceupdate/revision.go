@@ -0,0 +1,204 @@
+package inplaceupdate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	deploymentutil "k8s.io/kubernetes/pkg/controller/deployment/util"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+// ListRevisions returns the revisions of the target, oldest first
+func ListRevisions(ctx context.Context, c client.Reader, namespace, target string) ([]v1.InplaceUpdateRevision, error) {
+	list := &v1.InplaceUpdateRevisionList{}
+	if err := c.List(ctx, list, client.InNamespace(namespace), client.MatchingLabels{v1.LabelTarget: target}); err != nil {
+		return nil, err
+	}
+	sort.Slice(list.Items, func(a, b int) bool { return list.Items[a].Spec.Revision < list.Items[b].Spec.Revision })
+	return list.Items, nil
+}
+
+// FindRevision returns the revision with the number, 0 is the latest revision
+func FindRevision(revisions []v1.InplaceUpdateRevision, number int64) *v1.InplaceUpdateRevision {
+	if number == 0 && len(revisions) != 0 {
+		return &revisions[len(revisions)-1]
+	}
+	for idx := range revisions {
+		if revisions[idx].Spec.Revision == number {
+			return &revisions[idx]
+		}
+	}
+	return nil
+}
+
+// UpdateContainers returns the containers the update sets, taken from the resolved rollback
+// for updates with spec.rollbackTo
+func UpdateContainers(obj *v1.InplaceUpdate) []v1.InplaceUpdateArgs {
+	if obj.Spec.RollbackTo != nil && obj.Status.Rollback != nil {
+		return obj.Status.Rollback.Containers
+	}
+	return obj.Spec.Containers
+}
+
+// resolveRollback sets the containers of an update with spec.rollbackTo to the images the containers
+// ran before the revision. The revision is resolved once and kept in the status, so the update
+// does not move to another revision recorded while it runs. The containers are only set in memory.
+func (r *RealDeploymentControl) resolveRollback(ctx context.Context, i *v1.InplaceUpdate) (resolved bool, err error) {
+	if i.Status.Rollback != nil {
+		i.Spec.Containers = i.Status.Rollback.Containers
+		return true, nil
+	}
+	revisions, err := ListRevisions(ctx, r.Client, i.Namespace, i.Spec.TargetReference.Name)
+	if err != nil {
+		return false, err
+	}
+	status := newStatusFrom(i)
+	revision := FindRevision(revisions, *i.Spec.RollbackTo)
+	if revision == nil {
+		markFailed(i, status, "RevisionNotFound", fmt.Sprintf("target %s has no revision %d", i.Spec.TargetReference.Name, *i.Spec.RollbackTo))
+		r.completedEvent(i, status)
+		return false, r.statusUpdater.Update(i, status)
+	}
+	var containers []v1.InplaceUpdateArgs
+	for _, c := range revision.Spec.Containers {
+		if c.From != "" {
+			containers = append(containers, v1.InplaceUpdateArgs{Name: c.Name, Image: c.From, Type: c.Type})
+		}
+	}
+	if len(containers) == 0 {
+		markFailed(i, status, "RevisionNotFound", fmt.Sprintf("revision %d of target %s has no images to restore", revision.Spec.Revision, i.Spec.TargetReference.Name))
+		r.completedEvent(i, status)
+		return false, r.statusUpdater.Update(i, status)
+	}
+	i.Spec.Containers = containers
+	i.Status.Rollback = &v1.InplaceUpdateRollback{Revision: revision.Spec.Revision, Containers: containers}
+	return true, nil
+}
+
+// recordRevision creates the revision of a completed update. Updates that failed before
+// starting a wave changed nothing and are not recorded.
+func (r *RealDeploymentControl) recordRevision(ctx context.Context, i *v1.InplaceUpdate, d *appsv1.Deployment, status *v1.InplaceUpdateStatus) error {
+	if status.Phase == v1.InplaceUpdatePhaseFailed && len(status.Waves) == 0 {
+		return nil
+	}
+	revisions, err := ListRevisions(ctx, r.Client, i.Namespace, d.Name)
+	if err != nil {
+		return err
+	}
+	var number int64 = 1
+	for _, revision := range revisions {
+		if revision.Labels[v1.LabelInplaceUpdateUID] == string(i.UID) {
+			return nil
+		}
+		if revision.Spec.Revision >= number {
+			number = revision.Spec.Revision + 1
+		}
+	}
+	pods, err := r.updatedTargetPods(d, status)
+	if err != nil {
+		return err
+	}
+	from := make(map[string]string)
+	for _, previous := range PreviousImages(pods) {
+		from[previous.Name] = previous.Image
+	}
+	containers := make([]v1.RevisionContainer, 0, len(i.Spec.Containers))
+	for _, c := range i.Spec.Containers {
+		typ := v1.ContainerTypeContainer
+		if IsInitContainer(c) {
+			typ = v1.ContainerTypeInitContainer
+		}
+		containers = append(containers, v1.RevisionContainer{Name: c.Name, Type: typ, From: from[c.Name], To: c.Image})
+	}
+	revision := &v1.InplaceUpdateRevision{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: i.Namespace,
+			Name:      fmt.Sprintf("%s-%d", d.Name, number),
+			Labels: map[string]string{
+				v1.LabelTarget:           d.Name,
+				v1.LabelInplaceUpdateUID: string(i.UID),
+			},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(d, appsv1.SchemeGroupVersion.WithKind("Deployment"))},
+		},
+		Spec: v1.InplaceUpdateRevisionSpec{
+			TargetReference: *i.Spec.TargetReference,
+			Revision:        number,
+			InplaceUpdate:   i.Name,
+			Containers:      containers,
+			Initiator:       initiator(i),
+			InitiatorGroups: initiatorGroups(i),
+			Reason:          i.Spec.Reason,
+			ChangeRef:       i.Spec.ChangeRef,
+			Result:          status.Phase,
+			Replicas:        status.Replicas,
+			UpdatedReplicas: status.UpdatedReplicas,
+			StartTime:       status.StartTime,
+			CompletionTime:  status.CompletionTime,
+		},
+	}
+	if status.Phase == v1.InplaceUpdatePhaseFailed {
+		if degraded := meta.FindStatusCondition(status.Conditions, v1.InplaceUpdateConditionDegraded); degraded != nil {
+			revision.Spec.Message = degraded.Message
+		}
+	}
+	if status.Rollback != nil {
+		rollbackOf := status.Rollback.Revision
+		revision.Spec.RollbackOf = &rollbackOf
+	}
+	if err := r.Client.Create(ctx, revision); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	}
+	revisionsRecorded.WithLabelValues(i.Namespace, d.Name, string(status.Phase), revision.Spec.Initiator).Inc()
+	r.recorder.AnnotatedEventf(i, auditAnnotations(i), corev1.EventTypeNormal, "RevisionRecorded", "recorded as revision %d of %s", number, d.Name)
+	return nil
+}
+
+// updatedTargetPods returns the live pods of the deployment updated by the waves
+func (r *RealDeploymentControl) updatedTargetPods(d *appsv1.Deployment, status *v1.InplaceUpdateStatus) ([]*corev1.Pod, error) {
+	replicaSets, err := r.getReplicaSetsForDeployment(d)
+	if err != nil {
+		return nil, err
+	}
+	curRs := deploymentutil.FindNewReplicaSet(d, replicaSets)
+	if curRs == nil {
+		return nil, nil
+	}
+	pods, err := r.getPodsForReplicaSet(curRs)
+	if err != nil {
+		return nil, err
+	}
+	return updatedPods(status.Waves, pods), nil
+}
+
+// initiator returns who started the update, the initiator annotation set by the webhook or else
+// the field manager that created it, which is the first to own fields of the spec
+func initiator(i *v1.InplaceUpdate) string {
+	if value := i.Annotations[v1.AnnotationInitiator]; value != "" {
+		return value
+	}
+	for _, entry := range i.ManagedFields {
+		if entry.Subresource == "" {
+			return entry.Manager
+		}
+	}
+	return ""
+}
+
+func initiatorGroups(i *v1.InplaceUpdate) []string {
+	if value := i.Annotations[v1.AnnotationInitiatorGroups]; value != "" {
+		return strings.Split(value, ",")
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package inplaceupdate
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+func TestRecordRevisionAndRollback(t *testing.T) {
+	// the update has already set nginx:1.25, the pod keeps the image it ran before in its state
+	f := newFixture(1, corev1.Container{Name: "nginx", Image: "nginx:1.25"})
+	state, _ := json.Marshal(UpdateState{
+		UpdateTimestamp:       metav1.NewTime(time.Now()),
+		LastContainerStatuses: map[string]*corev1.ContainerStatus{"nginx": {Name: "nginx", Image: "nginx:1.24"}},
+	})
+	pod := f.pod("nginx-a")
+	pod.Annotations = map[string]string{AnnotationStateKey: string(state)}
+	update, d := f.update, f.d
+	update.Annotations = map[string]string{v1.AnnotationInitiator: "alice", v1.AnnotationInitiatorGroups: "sre,system:authenticated"}
+	update.Spec.Reason = "fix CVE-2024-1234"
+	update.Spec.ChangeRef = "CHG-1234"
+	c := f.client(pod)
+	recorder := record.NewFakeRecorder(10)
+	r := NewRealDeploymentControl(c, recorder, nil)
+	ctx := context.Background()
+
+	status := &v1.InplaceUpdateStatus{Phase: v1.InplaceUpdatePhaseFinished, Replicas: 1, UpdatedReplicas: 1,
+		Waves: []v1.InplaceUpdateWave{{Number: 1, Pods: []string{"nginx-a"}}}}
+	// recording again after a failed status update keeps the first revision
+	for n := 0; n < 2; n++ {
+		if err := r.recordRevision(ctx, update, d, status); err != nil {
+			t.Fatal(err)
+		}
+	}
+	revisions, err := ListRevisions(ctx, c, "default", "nginx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 1 {
+		t.Fatalf("expected the update to be recorded once, got %d revisions", len(revisions))
+	}
+	revision := revisions[0]
+	expected := v1.RevisionContainer{Name: "nginx", Type: v1.ContainerTypeContainer, From: "nginx:1.24", To: "nginx:1.25"}
+	if revision.Name != "nginx-1" || revision.Spec.Revision != 1 || revision.Spec.Initiator != "alice" ||
+		len(revision.Spec.InitiatorGroups) != 2 || revision.Spec.Reason != "fix CVE-2024-1234" || revision.Spec.ChangeRef != "CHG-1234" ||
+		revision.Spec.Result != v1.InplaceUpdatePhaseFinished || len(revision.Spec.Containers) != 1 || revision.Spec.Containers[0] != expected {
+		t.Errorf("unexpected revision %+v", revision)
+	}
+	if owner := metav1.GetControllerOf(&revision); owner == nil || owner.UID != d.UID {
+		t.Errorf("expected the revision to be owned by the deployment, got %v", owner)
+	}
+	annotations := " map[demo.cyisme.top/change-ref:CHG-1234 demo.cyisme.top/initiator:alice demo.cyisme.top/initiator-groups:sre,system:authenticated]"
+	if event := <-recorder.Events; event != "Normal RevisionRecorded recorded as revision 1 of nginx"+annotations {
+		t.Errorf("unexpected event %q", event)
+	}
+	if n := testutil.ToFloat64(revisionsRecorded.WithLabelValues("default", "nginx", "Finished", "alice")); n != 1 {
+		t.Errorf("expected the revision to be counted once, got %v", n)
+	}
+	r.completedEvent(update, status)
+	if event := <-recorder.Events; event != "Normal Finished 1/1 pods updated (initiator alice, change CHG-1234, reason: fix CVE-2024-1234)"+annotations {
+		t.Errorf("unexpected event %q", event)
+	}
+
+	rollback := &v1.InplaceUpdate{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "nginx-undo", UID: "undo-uid"},
+		Spec: v1.InplaceUpdateSpec{
+			TargetReference: update.Spec.TargetReference,
+			RollbackTo:      ptr.To[int64](0),
+		},
+	}
+	resolved, err := r.resolveRollback(ctx, rollback)
+	if err != nil || !resolved {
+		t.Fatalf("expected the rollback to be resolved, got %v %v", resolved, err)
+	}
+	if len(rollback.Spec.Containers) != 1 || rollback.Spec.Containers[0].Image != "nginx:1.24" ||
+		rollback.Status.Rollback == nil || rollback.Status.Rollback.Revision != 1 {
+		t.Errorf("expected the rollback to restore nginx:1.24 from revision 1, got %+v %+v", rollback.Spec.Containers, rollback.Status.Rollback)
+	}
+	status.Rollback = rollback.Status.Rollback
+	if err := r.recordRevision(ctx, rollback, d, status); err != nil {
+		t.Fatal(err)
+	}
+	latest := &v1.InplaceUpdateRevision{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "nginx-2"}, latest); err != nil {
+		t.Fatal(err)
+	}
+	if latest.Spec.RollbackOf == nil || *latest.Spec.RollbackOf != 1 || latest.Spec.InplaceUpdate != "nginx-undo" {
+		t.Errorf("expected revision 2 to record the rollback of revision 1, got %+v", latest.Spec)
+	}
+}
@@ -0,0 +1,111 @@
+package inplaceupdate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+// a window opens at least once a week, so looking one week and one day ahead is enough
+const scheduleHorizon = 8 * 24 * time.Hour
+
+// NextScheduleTime returns the next time spec.schedule allows the update to start, or to start a wave
+// once it has started. The zero time is returned if it is allowed now.
+// The start time and the cron expression only hold the update back before it starts.
+func NextScheduleTime(obj *v1.InplaceUpdate, now time.Time) (time.Time, error) {
+	schedule := obj.Spec.Schedule
+	if schedule == nil {
+		return time.Time{}, nil
+	}
+	location := time.UTC
+	if schedule.TimeZone != "" {
+		var err error
+		if location, err = time.LoadLocation(schedule.TimeZone); err != nil {
+			return time.Time{}, err
+		}
+	}
+	next := now
+	if obj.Status.StartTime == nil {
+		earliest := obj.CreationTimestamp.Time
+		if schedule.StartTime != nil && schedule.StartTime.After(earliest) {
+			earliest = schedule.StartTime.Time
+		}
+		if schedule.Cron != "" {
+			cronSchedule, err := cron.ParseStandard(schedule.Cron)
+			if err != nil {
+				return time.Time{}, err
+			}
+			// the first time matching at or after earliest
+			earliest = cronSchedule.Next(earliest.In(location).Add(-time.Second))
+			if earliest.IsZero() {
+				return time.Time{}, fmt.Errorf("cron expression %q never matches", schedule.Cron)
+			}
+		}
+		if earliest.After(next) {
+			next = earliest
+		}
+	}
+	if len(schedule.Windows) != 0 {
+		open, found := nextWindowOpen(schedule.Windows, next.In(location))
+		if !found {
+			return time.Time{}, fmt.Errorf("no schedule window opens within %s", scheduleHorizon)
+		}
+		next = open
+	}
+	if !next.After(now) {
+		return time.Time{}, nil
+	}
+	return next, nil
+}
+
+// nextWindowOpen returns t if it is inside a window, or the time the next window opens
+func nextWindowOpen(windows []v1.ScheduleWindow, t time.Time) (time.Time, bool) {
+	if inWindows(windows, t) {
+		return t, true
+	}
+	for hour := 1; hour <= int(scheduleHorizon/time.Hour); hour++ {
+		// hours are counted on the wall clock of the location, the date is normalized
+		candidate := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+hour, 0, 0, 0, t.Location())
+		if inWindows(windows, candidate) {
+			return candidate, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func inWindows(windows []v1.ScheduleWindow, t time.Time) bool {
+	for _, window := range windows {
+		if inWindow(window, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// inWindow reports whether t is inside the window. A window ending at or before its start hour
+// runs past midnight, the hours after midnight belong to the day it opened on.
+func inWindow(window v1.ScheduleWindow, t time.Time) bool {
+	hour := int32(t.Hour())
+	if window.StartHour < window.EndHour {
+		return onDay(window.Days, t.Weekday()) && hour >= window.StartHour && hour < window.EndHour
+	}
+	if hour >= window.StartHour {
+		return onDay(window.Days, t.Weekday())
+	}
+	return hour < window.EndHour && onDay(window.Days, (t.Weekday()+6)%7)
+}
+
+func onDay(days []v1.Weekday, day time.Weekday) bool {
+	if len(days) == 0 {
+		return true
+	}
+	for _, d := range days {
+		if d == v1.Weekdays[day] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package inplaceupdate
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+func TestInWindow(t *testing.T) {
+	// 2024-05-04 is a Saturday
+	at := func(day, hour int) time.Time { return time.Date(2024, 5, day, hour, 30, 0, 0, time.UTC) }
+	overnight := v1.ScheduleWindow{Days: []v1.Weekday{v1.Saturday}, StartHour: 22, EndHour: 4}
+	office := v1.ScheduleWindow{StartHour: 9, EndHour: 17}
+	tests := []struct {
+		name   string
+		window v1.ScheduleWindow
+		t      time.Time
+		want   bool
+	}{
+		{name: "inside", window: office, t: at(1, 9), want: true},
+		{name: "before", window: office, t: at(1, 8)},
+		{name: "end hour is excluded", window: office, t: at(1, 17)},
+		{name: "overnight before midnight", window: overnight, t: at(4, 23), want: true},
+		{name: "overnight after midnight belongs to the previous day", window: overnight, t: at(5, 3), want: true},
+		{name: "overnight closed", window: overnight, t: at(5, 4)},
+		{name: "overnight on another day", window: overnight, t: at(5, 23)},
+		{name: "overnight after midnight of another day", window: overnight, t: at(4, 3)},
+		{name: "overnight before it opens", window: overnight, t: at(4, 21)},
+		{name: "whole day", window: v1.ScheduleWindow{Days: []v1.Weekday{v1.Saturday}, StartHour: 0, EndHour: 24}, t: at(4, 0), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := inWindow(tt.window, tt.t); got != tt.want {
+				t.Errorf("expected %v at %s, got %v", tt.want, tt.t.Format(time.RFC1123), got)
+			}
+		})
+	}
+}
+
+func TestNextScheduleTime(t *testing.T) {
+	// a Wednesday
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	utc := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2024, month, day, hour, 0, 0, 0, time.UTC)
+	}
+	mt := func(t time.Time) *metav1.Time {
+		m := metav1.NewTime(t)
+		return &m
+	}
+	saturdayNight := []v1.ScheduleWindow{{Days: []v1.Weekday{v1.Saturday}, StartHour: 22, EndHour: 4}}
+	tests := []struct {
+		name     string
+		schedule *v1.UpdateSchedule
+		started  bool
+		want     time.Time
+		wantErr  bool
+	}{
+		{name: "no schedule"},
+		{name: "start time ahead", schedule: &v1.UpdateSchedule{StartTime: mt(utc(5, 1, 12))}, want: utc(5, 1, 12)},
+		{name: "start time passed", schedule: &v1.UpdateSchedule{StartTime: mt(utc(5, 1, 8))}},
+		{name: "cron", schedule: &v1.UpdateSchedule{Cron: "0 1 * * *"}, want: utc(5, 2, 1)},
+		{name: "cron in a time zone", schedule: &v1.UpdateSchedule{Cron: "0 1 * * *", TimeZone: "Asia/Shanghai"}, want: utc(5, 1, 17)},
+		{name: "cron after the start time", schedule: &v1.UpdateSchedule{Cron: "0 1 * * *", StartTime: mt(utc(5, 3, 12))}, want: utc(5, 4, 1)},
+		{name: "cron matching the start time", schedule: &v1.UpdateSchedule{Cron: "0 12 * * *", StartTime: mt(utc(5, 3, 12))}, want: utc(5, 3, 12)},
+		{name: "window", schedule: &v1.UpdateSchedule{Windows: saturdayNight}, want: utc(5, 4, 22)},
+		{name: "window in a time zone", schedule: &v1.UpdateSchedule{TimeZone: "Asia/Shanghai",
+			Windows: []v1.ScheduleWindow{{StartHour: 22, EndHour: 4}}}, want: utc(5, 1, 14)},
+		{name: "window open", schedule: &v1.UpdateSchedule{Windows: []v1.ScheduleWindow{{StartHour: 9, EndHour: 17}}}},
+		{name: "window after the cron", schedule: &v1.UpdateSchedule{Cron: "0 23 * * Sun", Windows: saturdayNight}, want: utc(5, 11, 22)},
+		{name: "started updates ignore the cron", schedule: &v1.UpdateSchedule{Cron: "0 1 * * *"}, started: true},
+		{name: "started updates wait for the window", schedule: &v1.UpdateSchedule{Cron: "0 1 * * *", Windows: saturdayNight},
+			started: true, want: utc(5, 4, 22)},
+		{name: "invalid cron", schedule: &v1.UpdateSchedule{Cron: "every day"}, wantErr: true},
+		{name: "invalid time zone", schedule: &v1.UpdateSchedule{TimeZone: "Mars/Olympus"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &v1.InplaceUpdate{
+				ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(now.Add(-time.Hour))},
+				Spec:       v1.InplaceUpdateSpec{Schedule: tt.schedule},
+			}
+			if tt.started {
+				obj.Status.StartTime = mt(now.Add(-time.Minute))
+			}
+			got, err := NextScheduleTime(obj, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
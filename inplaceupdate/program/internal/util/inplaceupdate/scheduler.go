@@ -0,0 +1,139 @@
+package inplaceupdate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+// TargetScheduler runs the updates of the same target one at a time. Updates already started go first,
+// the others follow in creation order. An update that has not started is superseded when an update
+// of the same target created after it sets spec.supersede.
+type TargetScheduler struct {
+	Client        client.Client
+	statusUpdater StatusUpdater
+}
+
+func NewTargetScheduler(client client.Client) *TargetScheduler {
+	return &TargetScheduler{
+		Client:        client,
+		statusUpdater: newStatusUpdater(client, nil),
+	}
+}
+
+// Schedule reports whether the update may run now. An update waiting in the queue has its position
+// and the update it waits for recorded in the status, a superseded update is completed.
+func (s *TargetScheduler) Schedule(ctx context.Context, inplaceUpdate types.NamespacedName) (admitted bool, result ctrl.Result, err error) {
+	i := &v1.InplaceUpdate{}
+	if err := s.Client.Get(ctx, inplaceUpdate, i); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, ctrl.Result{}, nil
+		}
+		return false, ctrl.Result{}, err
+	}
+	// a dry run never touches the target, it is planned right away, and a deleted or aborted update gives its target back
+	if IsCompleted(i) || i.DeletionTimestamp != nil || i.Spec.Abort || i.Spec.DryRun || i.Spec.TargetReference == nil {
+		return true, ctrl.Result{}, nil
+	}
+	queue, err := TargetQueue(ctx, s.Client, i.Namespace, i.Spec.TargetReference.Name)
+	if err != nil {
+		return false, ctrl.Result{}, err
+	}
+	if by := supersededBy(i, queue); by != nil {
+		now := metav1.Now()
+		status := newStatusFrom(i)
+		status.Phase = v1.InplaceUpdatePhaseSuperseded
+		status.CompletionTime = &now
+		message := fmt.Sprintf("superseded by InplaceUpdate %s", by.Name)
+		SetCondition(&status.Conditions, i.Generation, v1.InplaceUpdateConditionProgressing, metav1.ConditionFalse, "Superseded", message)
+		SetCondition(&status.Conditions, i.Generation, v1.InplaceUpdateConditionBlocked, metav1.ConditionFalse, "Superseded", message)
+		return false, ctrl.Result{}, s.statusUpdater.Update(i, status)
+	}
+
+	var active []*v1.InplaceUpdate
+	for _, u := range queue {
+		if supersededBy(u, queue) == nil {
+			active = append(active, u)
+		}
+	}
+	position := 0
+	for idx, u := range active {
+		if u.Name == i.Name {
+			position = idx
+			break
+		}
+	}
+	if position == 0 {
+		if i.Status.QueuePosition == 0 && i.Status.BlockedBy == "" {
+			return true, ctrl.Result{}, nil
+		}
+		status := i.Status.DeepCopy()
+		status.QueuePosition = 0
+		status.BlockedBy = ""
+		SetCondition(&status.Conditions, i.Generation, v1.InplaceUpdateConditionBlocked, metav1.ConditionFalse, "Dequeued", "")
+		return true, ctrl.Result{}, s.statusUpdater.Update(i, status)
+	}
+	status := i.Status.DeepCopy()
+	status.ObservedGeneration = i.Generation
+	status.Phase = v1.InplaceUpdatePhasePending
+	status.QueuePosition = int32(position)
+	status.BlockedBy = active[0].Name
+	SetCondition(&status.Conditions, i.Generation, v1.InplaceUpdateConditionBlocked, metav1.ConditionTrue, "Queued",
+		fmt.Sprintf("waiting for InplaceUpdate %s, position %d in the queue of the target", active[0].Name, position))
+	return false, ctrl.Result{RequeueAfter: defaultRequeueAfter}, s.statusUpdater.Update(i, status)
+}
+
+// TargetQueue returns the updates of the target that are not completed, in the order they run.
+// Dry runs are not queued.
+func TargetQueue(ctx context.Context, c client.Client, namespace, target string) ([]*v1.InplaceUpdate, error) {
+	list := &v1.InplaceUpdateList{}
+	if err := c.List(ctx, list, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	var queue []*v1.InplaceUpdate
+	for idx := range list.Items {
+		u := &list.Items[idx]
+		if u.Spec.TargetReference == nil || u.Spec.TargetReference.Name != target || u.Spec.DryRun || IsCompleted(u) {
+			continue
+		}
+		queue = append(queue, u)
+	}
+	sort.SliceStable(queue, func(a, b int) bool {
+		ua, ub := queue[a], queue[b]
+		if startedA, startedB := ua.Status.StartTime != nil, ub.Status.StartTime != nil; startedA != startedB {
+			return startedA
+		}
+		if !ua.CreationTimestamp.Equal(&ub.CreationTimestamp) {
+			return ua.CreationTimestamp.Before(&ub.CreationTimestamp)
+		}
+		return ua.Name < ub.Name
+	})
+	return queue, nil
+}
+
+// supersededBy returns the update superseding u, i.e. a later update in the queue setting spec.supersede.
+// An update that has started is never superseded.
+func supersededBy(u *v1.InplaceUpdate, queue []*v1.InplaceUpdate) *v1.InplaceUpdate {
+	if u.Status.StartTime != nil {
+		return nil
+	}
+	after := false
+	for _, other := range queue {
+		if other.Name == u.Name {
+			after = true
+			continue
+		}
+		if after && other.Spec.Supersede {
+			return other
+		}
+	}
+	return nil
+}
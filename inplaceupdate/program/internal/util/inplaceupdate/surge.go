@@ -0,0 +1,178 @@
+package inplaceupdate
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/util/retry"
+	podutil "k8s.io/kubernetes/pkg/api/v1/pod"
+	deploymentutil "k8s.io/kubernetes/pkg/controller/deployment/util"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+// LabelSurgeKey is set on the surge pods, together with v1.LabelInplaceUpdateUID naming the update that created them
+const LabelSurgeKey = "demo.cyisme.top/surge"
+
+// AnnotationPausedByKey is set on the target workload to the name of the InplaceUpdate that paused it,
+// so the workload is only resumed by the update that paused it and a workload paused by others is left paused
+const AnnotationPausedByKey = "demo.cyisme.top/paused-by"
+
+// MaxSurge returns the absolute number of surge pods of the update, rounded up. It is 0 unless spec.maxSurge is set.
+func MaxSurge(spec v1.InplaceUpdateSpec, replicas int32) int32 {
+	if spec.MaxSurge == nil {
+		return 0
+	}
+	value, err := intstr.GetScaledValueFromIntOrPercent(spec.MaxSurge, int(replicas), true)
+	if err != nil || value < 0 {
+		return 0
+	}
+	return int32(value)
+}
+
+// getSurgePods returns the live surge pods of the update
+func (r *RealDeploymentControl) getSurgePods(i *v1.InplaceUpdate) ([]*corev1.Pod, error) {
+	podList := corev1.PodList{}
+	if err := r.Client.List(context.TODO(), &podList, client.InNamespace(i.Namespace),
+		client.MatchingLabels{LabelSurgeKey: "true", v1.LabelInplaceUpdateUID: string(i.UID)}); err != nil {
+		return nil, err
+	}
+	var pods []*corev1.Pod
+	for idx := range podList.Items {
+		pod := &podList.Items[idx]
+		if controllerRef := metav1.GetControllerOf(pod); controllerRef != nil && controllerRef.UID == i.UID && pod.DeletionTimestamp == nil {
+			pods = append(pods, pod)
+		}
+	}
+	return pods, nil
+}
+
+// scaleSurge creates the missing surge pods of the update and reports whether all of them are ready,
+// a wave only starts once they are
+func (r *RealDeploymentControl) scaleSurge(i *v1.InplaceUpdate, rs *appsv1.ReplicaSet, pods []*corev1.Pod, status *v1.InplaceUpdateStatus) (ready bool, err error) {
+	surge := MaxSurge(i.Spec, status.Replicas)
+	for n := int32(len(pods)); n < surge; n++ {
+		if err := r.Client.Create(context.TODO(), newSurgePod(i, rs)); err != nil {
+			return false, fmt.Errorf("failed to create a surge pod: %w", err)
+		}
+		status.SurgeReplicas++
+	}
+	var readyPods int32
+	for _, pod := range pods {
+		if podutil.IsPodReady(pod) {
+			readyPods++
+		}
+	}
+	if readyPods < surge {
+		SetCondition(&status.Conditions, i.Generation, v1.InplaceUpdateConditionBlocked, metav1.ConditionTrue, "SurgeNotReady",
+			fmt.Sprintf("waiting for %d/%d surge pods to be ready", surge-readyPods, surge))
+		return false, nil
+	}
+	return true, nil
+}
+
+// removeSurge deletes the surge pods of the update
+func (r *RealDeploymentControl) removeSurge(i *v1.InplaceUpdate, status *v1.InplaceUpdateStatus) error {
+	pods, err := r.getSurgePods(i)
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods {
+		if err := r.Client.Delete(context.TODO(), pod); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete the surge pod %s: %v", pod.Name, err)
+		}
+	}
+	status.SurgeReplicas = 0
+	return nil
+}
+
+// newSurgePod returns a pod of the template of the replicaset running the new images. The pod is controlled
+// by the update rather than the replicaset, so neither the replicaset nor the deployment, which still scales
+// while paused, adopts and deletes it. It is garbage collected with the update.
+func newSurgePod(i *v1.InplaceUpdate, rs *appsv1.ReplicaSet) *corev1.Pod {
+	template := rs.Spec.Template.DeepCopy()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       rs.Namespace,
+			GenerateName:    rs.Name + "-surge-",
+			Labels:          template.Labels,
+			Annotations:     template.Annotations,
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(i, v1.GroupVersion.WithKind("InplaceUpdate"))},
+		},
+		Spec: template.Spec,
+	}
+	if pod.Labels == nil {
+		pod.Labels = make(map[string]string)
+	}
+	pod.Labels[LabelSurgeKey] = "true"
+	pod.Labels[v1.LabelInplaceUpdateUID] = string(i.UID)
+	for _, target := range i.Spec.Containers {
+		if container, err := FindTargetContainer(target, pod.Spec); err == nil {
+			container.Image = target.Image
+		}
+	}
+	return pod
+}
+
+// ownsDeploymentPause reports whether the update may pause and resume the deployment: the deployment is not
+// paused, or paused by the update, or paused by an update that is completed or deleted
+func (r *RealDeploymentControl) ownsDeploymentPause(i *v1.InplaceUpdate, d *appsv1.Deployment) (bool, error) {
+	if !d.Spec.Paused {
+		return true, nil
+	}
+	pausedBy := d.Annotations[AnnotationPausedByKey]
+	if pausedBy == "" {
+		return false, nil
+	}
+	if pausedBy == i.Name {
+		return true, nil
+	}
+	held, err := r.isLockHeld(i.Namespace, pausedBy)
+	return !held, err
+}
+
+// setDeploymentPaused pauses the deployment for the update, or resumes it if it was paused by the update.
+// Conflicts are retried with a fresh read.
+func (r *RealDeploymentControl) setDeploymentPaused(d *appsv1.Deployment, i *v1.InplaceUpdate, paused bool) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		latest := &appsv1.Deployment{}
+		if err := r.Client.Get(context.Background(), client.ObjectKeyFromObject(d), latest); err != nil {
+			if !paused && apierrors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		patch := client.MergeFromWithOptions(latest.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		if paused {
+			if latest.Spec.Paused && latest.Annotations[AnnotationPausedByKey] == i.Name {
+				return nil
+			}
+			if latest.Annotations == nil {
+				latest.Annotations = make(map[string]string)
+			}
+			latest.Annotations[AnnotationPausedByKey] = i.Name
+		} else {
+			if latest.Annotations[AnnotationPausedByKey] != i.Name {
+				return nil
+			}
+			delete(latest.Annotations, AnnotationPausedByKey)
+			// the images may be set in the template of the replicaset but not yet in the one of the deployment,
+			// they are set along with the resume so the deployment does not roll out a new replicaset
+			replicaSets, err := r.getReplicaSetsForDeployment(latest)
+			if err != nil {
+				return err
+			}
+			if deploymentutil.FindNewReplicaSet(latest, replicaSets) == nil && findNewReplicaSet(i, latest, replicaSets) != nil {
+				setTemplateImages(i.Spec, &latest.Spec.Template)
+			}
+		}
+		latest.Spec.Paused = paused
+		return r.Client.Patch(context.Background(), latest, patch)
+	})
+}
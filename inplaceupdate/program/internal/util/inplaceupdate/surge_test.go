@@ -0,0 +1,133 @@
+package inplaceupdate
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+func TestMaxSurge(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxUnavailable *intstr.IntOrString
+		maxSurge       *intstr.IntOrString
+		replicas       int32
+		unavailable    int32
+		surge          int32
+	}{
+		{name: "no surge", replicas: 2, unavailable: 1, surge: 0},
+		{name: "surge rounds maxUnavailable down", maxSurge: ptr.To(intstr.FromInt32(1)), replicas: 2, unavailable: 0, surge: 1},
+		{name: "percent surge rounds up", maxSurge: ptr.To(intstr.FromString("10%")), replicas: 11, unavailable: 2, surge: 2},
+		{name: "zero maxUnavailable", maxUnavailable: ptr.To(intstr.FromInt32(0)), maxSurge: ptr.To(intstr.FromString("50%")), replicas: 3, unavailable: 0, surge: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := v1.InplaceUpdateSpec{MaxUnavailable: tt.maxUnavailable, MaxSurge: tt.maxSurge}
+			if got := MaxUnavailable(spec, tt.replicas); got != tt.unavailable {
+				t.Errorf("expected maxUnavailable %d, got %d", tt.unavailable, got)
+			}
+			if got := MaxSurge(spec, tt.replicas); got != tt.surge {
+				t.Errorf("expected maxSurge %d, got %d", tt.surge, got)
+			}
+		})
+	}
+}
+
+func TestSurge(t *testing.T) {
+	f := newFixture(1)
+	f.update.Spec.MaxSurge = ptr.To(intstr.FromInt32(1))
+	c := f.client(f.pod("nginx-a"))
+	r := NewRealDeploymentControl(c, &record.FakeRecorder{}, nil)
+	ctx := context.Background()
+	update := f.update
+	deployment := func() *appsv1.Deployment { return f.deployment(t, c) }
+
+	// the surge pod is created and the wave waits for it to be ready
+	status := newStatusFrom(update)
+	wave, _, err := r.ownerRefPatchedPods(ctx, update, deployment(), status)
+	if err != nil || len(wave) != 0 {
+		t.Fatalf("expected no wave before the surge pod is ready, got %d pods, %v", len(wave), err)
+	}
+	surgePods, err := r.getSurgePods(update)
+	if err != nil || len(surgePods) != 1 {
+		t.Fatalf("expected a surge pod, got %d, %v", len(surgePods), err)
+	}
+	surgePod := surgePods[0]
+	if surgePod.Spec.Containers[0].Image != "nginx:1.25" || surgePod.Labels["app"] != "nginx" || metav1.GetControllerOf(surgePod).UID != update.UID {
+		t.Errorf("unexpected surge pod %+v", surgePod)
+	}
+	if blocked := meta.FindStatusCondition(status.Conditions, v1.InplaceUpdateConditionBlocked); blocked == nil || blocked.Reason != "SurgeNotReady" {
+		t.Errorf("expected the update to wait for the surge pod, got %v", blocked)
+	}
+	if latest := deployment(); !latest.Spec.Paused || latest.Annotations[AnnotationPausedByKey] != update.Name || status.SurgeReplicas != 1 {
+		t.Errorf("expected the deployment to stay paused while the surge pod runs, got %v %v", latest.Spec.Paused, latest.Annotations)
+	}
+
+	// once it is ready the pod is restarted in place, maxUnavailable being rounded down to 0
+	surgePod.Status = f.pod("nginx-a").Status
+	if err := c.Status().Update(ctx, surgePod); err != nil {
+		t.Fatal(err)
+	}
+	status = newStatusFrom(update)
+	wave, _, err = r.ownerRefPatchedPods(ctx, update, deployment(), status)
+	if err != nil || len(wave) != 1 || wave[0].Name != "nginx-a" {
+		t.Fatalf("expected nginx-a to be updated once the surge pod is ready, got %d pods, %v", len(wave), err)
+	}
+
+	// the surge pod is removed and the deployment resumed once the update completes
+	status.Phase = v1.InplaceUpdatePhaseFinished
+	if err := r.updateStatus(update, deployment(), status); err != nil {
+		t.Fatal(err)
+	}
+	if surgePods, err := r.getSurgePods(update); err != nil || len(surgePods) != 0 || status.SurgeReplicas != 0 {
+		t.Errorf("expected the surge pod to be removed, got %d, %v", len(surgePods), err)
+	}
+	if latest := deployment(); latest.Spec.Paused || latest.Annotations[AnnotationPausedByKey] != "" {
+		t.Errorf("expected the deployment to be resumed, got %v %v", latest.Spec.Paused, latest.Annotations)
+	}
+}
+
+func TestSurgeCreateFailure(t *testing.T) {
+	f := newFixture(1)
+	f.update.Spec.MaxSurge = ptr.To(intstr.FromInt32(1))
+	f.d.Status = appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+	quota := apierrors.NewForbidden(corev1.Resource("pods"), "", errors.New("exceeded quota: pods"))
+	c := f.builder(f.pod("nginx-a")).WithInterceptorFuncs(interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			if _, ok := obj.(*corev1.Pod); ok {
+				return quota
+			}
+			return c.Create(ctx, obj, opts...)
+		},
+	}).Build()
+	r := NewRealDeploymentControl(c, &record.FakeRecorder{}, nil)
+	ctx := context.Background()
+
+	// a surge pod refused by a quota is retried rather than failing the update
+	if _, err := r.doReconcile(ctx, client.ObjectKeyFromObject(f.update)); !apierrors.IsForbidden(err) {
+		t.Fatalf("expected the quota error to be returned, got %v", err)
+	}
+	latest := &v1.InplaceUpdate{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(f.update), latest); err != nil {
+		t.Fatal(err)
+	}
+	if latest.Status.Phase == v1.InplaceUpdatePhaseFailed {
+		t.Errorf("expected the update not to fail, got %+v", latest.Status.Conditions)
+	}
+	if d := f.deployment(t, c); !d.Spec.Paused || d.Annotations[AnnotationTargetLockKey] != f.update.Name {
+		t.Errorf("expected the deployment to stay paused and locked until the retry, got %v %v", d.Spec.Paused, d.Annotations)
+	}
+}
@@ -0,0 +1,84 @@
+package inplaceupdate
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+// FinalizerTeardown is set on an update once it holds the lock of its target, so that an update deleted
+// while it runs still resumes the target, releases its lock, restores its autoscalers and removes its surge pods
+const FinalizerTeardown = "demo.cyisme.top/teardown"
+
+// addTeardownFinalizer sets FinalizerTeardown on the update before it changes its target
+func (r *RealDeploymentControl) addTeardownFinalizer(ctx context.Context, i *v1.InplaceUpdate) error {
+	if controllerutil.ContainsFinalizer(i, FinalizerTeardown) {
+		return nil
+	}
+	patch := client.MergeFrom(i.DeepCopy())
+	controllerutil.AddFinalizer(i, FinalizerTeardown)
+	return r.Client.Patch(ctx, i, patch)
+}
+
+// teardown gives the target back once the update is completed or deleted: the surge pods are removed,
+// the images of the templates are put back unless the update finished with status, the target is resumed
+// if the update paused it, its lock is released and its autoscalers are restored, then the finalizer is removed.
+// Each step is a no-op if already done, so teardown is retried as a whole.
+func (r *RealDeploymentControl) teardown(ctx context.Context, i *v1.InplaceUpdate, status *v1.InplaceUpdateStatus) error {
+	if err := r.removeSurge(i, status); err != nil {
+		return err
+	}
+	d := &appsv1.Deployment{}
+	err := r.Client.Get(ctx, types.NamespacedName{Namespace: i.Namespace, Name: i.Spec.TargetReference.Name}, d)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if err == nil {
+		if err := r.restoreTemplateImages(i, d, status.Phase != v1.InplaceUpdatePhaseFinished); err != nil {
+			return err
+		}
+		if err := r.setDeploymentPaused(d, i, false); err != nil {
+			return err
+		}
+		if err := r.releaseTargetLock(i, d); err != nil {
+			return err
+		}
+	}
+	if err := r.releaseAutoscalers(ctx, i); err != nil {
+		return err
+	}
+	if !controllerutil.ContainsFinalizer(i, FinalizerTeardown) {
+		return nil
+	}
+	patch := client.MergeFrom(i.DeepCopy())
+	controllerutil.RemoveFinalizer(i, FinalizerTeardown)
+	return client.IgnoreNotFound(r.Client.Patch(ctx, i, patch))
+}
+
+// abort fails the update set with spec.abort, whether it runs, waits or is paused. Its target is given
+// back by updateStatus as for any failed update.
+func (r *RealDeploymentControl) abort(ctx context.Context, i *v1.InplaceUpdate) error {
+	status := i.Status.DeepCopy()
+	status.ObservedGeneration = i.Generation
+	status.QueuePosition = 0
+	status.BlockedBy = ""
+	markFailed(i, status, "Aborted", "aborted by spec.abort")
+	d := &appsv1.Deployment{}
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: i.Namespace, Name: i.Spec.TargetReference.Name}, d); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		if err := r.teardown(ctx, i, status); err != nil {
+			return err
+		}
+		r.completedEvent(i, status)
+		return r.statusUpdater.Update(i, status)
+	}
+	return r.updateStatus(i, d, status)
+}
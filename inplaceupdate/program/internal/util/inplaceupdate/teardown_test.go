@@ -0,0 +1,109 @@
+package inplaceupdate
+
+import (
+	"context"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+func TestTeardown(t *testing.T) {
+	f := newFixture(1)
+	f.update.Spec.MaxSurge = ptr.To(intstr.FromInt32(1))
+	c := f.client(f.pod("nginx-a"))
+	r := NewRealDeploymentControl(c, &record.FakeRecorder{}, nil)
+	ctx := context.Background()
+	update := f.update
+
+	// the update takes the target, pauses it and creates its surge pod
+	if holder, err := r.acquireTargetLock(update, f.deployment(t, c)); holder != "" || err != nil {
+		t.Fatalf("expected the lock to be acquired, got %q, %v", holder, err)
+	}
+	if err := r.addTeardownFinalizer(ctx, update); err != nil || !controllerutil.ContainsFinalizer(update, FinalizerTeardown) {
+		t.Fatalf("expected the teardown finalizer, got %v, %v", update.Finalizers, err)
+	}
+	if _, _, err := r.ownerRefPatchedPods(ctx, update, f.deployment(t, c), newStatusFrom(update)); err != nil {
+		t.Fatal(err)
+	}
+	if surgePods, err := r.getSurgePods(update); err != nil || len(surgePods) != 1 || !f.deployment(t, c).Spec.Paused {
+		t.Fatalf("expected a surge pod and the deployment paused, got %d, %v", len(surgePods), err)
+	}
+
+	// deleted while it runs, the update still gives the target back
+	if err := c.Delete(ctx, update); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.doReconcile(ctx, client.ObjectKeyFromObject(update)); err != nil {
+		t.Fatal(err)
+	}
+	if surgePods, err := r.getSurgePods(update); err != nil || len(surgePods) != 0 {
+		t.Errorf("expected the surge pod to be removed, got %d, %v", len(surgePods), err)
+	}
+	latest := f.deployment(t, c)
+	if latest.Spec.Paused || latest.Annotations[AnnotationPausedByKey] != "" || latest.Annotations[AnnotationTargetLockKey] != "" {
+		t.Errorf("expected the deployment to be resumed and unlocked, got %v %v", latest.Spec.Paused, latest.Annotations)
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(update), &v1.InplaceUpdate{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the update to be gone once its finalizer is removed, got %v", err)
+	}
+}
+
+func TestAbort(t *testing.T) {
+	f := newFixture(1)
+	f.update.Spec.MaxSurge = ptr.To(intstr.FromInt32(1))
+	c := f.client(f.pod("nginx-a"))
+	r := NewRealDeploymentControl(c, &record.FakeRecorder{}, nil)
+	ctx := context.Background()
+	update := f.update
+
+	// the update runs with its surge pod, then spec.abort is set while it waits for it
+	if holder, err := r.acquireTargetLock(update, f.deployment(t, c)); holder != "" || err != nil {
+		t.Fatalf("expected the lock to be acquired, got %q, %v", holder, err)
+	}
+	if err := r.addTeardownFinalizer(ctx, update); err != nil {
+		t.Fatal(err)
+	}
+	status := newStatusFrom(update)
+	if _, _, err := r.ownerRefPatchedPods(ctx, update, f.deployment(t, c), status); err != nil {
+		t.Fatal(err)
+	}
+	status.Phase = v1.InplaceUpdatePhaseRunning
+	if err := r.statusUpdater.Update(update, status); err != nil {
+		t.Fatal(err)
+	}
+	patch := client.MergeFrom(update.DeepCopy())
+	update.Spec.Abort = true
+	if err := c.Patch(ctx, update, patch); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.doReconcile(ctx, client.ObjectKeyFromObject(update)); err != nil {
+		t.Fatal(err)
+	}
+	latest := &v1.InplaceUpdate{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(update), latest); err != nil {
+		t.Fatal(err)
+	}
+	degraded := meta.FindStatusCondition(latest.Status.Conditions, v1.InplaceUpdateConditionDegraded)
+	if latest.Status.Phase != v1.InplaceUpdatePhaseFailed || degraded == nil || degraded.Reason != "Aborted" {
+		t.Errorf("expected the update to fail as aborted, got %s, %+v", latest.Status.Phase, degraded)
+	}
+	if controllerutil.ContainsFinalizer(latest, FinalizerTeardown) {
+		t.Error("expected the teardown finalizer to be removed")
+	}
+	if surgePods, err := r.getSurgePods(update); err != nil || len(surgePods) != 0 {
+		t.Errorf("expected the surge pod to be removed, got %d, %v", len(surgePods), err)
+	}
+	d := f.deployment(t, c)
+	if d.Spec.Paused || d.Annotations[AnnotationPausedByKey] != "" || d.Annotations[AnnotationTargetLockKey] != "" {
+		t.Errorf("expected the deployment to be resumed and unlocked, got %v %v", d.Spec.Paused, d.Annotations)
+	}
+}
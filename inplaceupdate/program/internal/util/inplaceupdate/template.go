@@ -0,0 +1,182 @@
+package inplaceupdate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/retry"
+	deploymentutil "k8s.io/kubernetes/pkg/controller/deployment/util"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+// AnnotationTemplateImagesKey is set on the target workload and its replicaset to the images their template had
+// before an update set its own, as JSON, so they are put back if the update does not finish
+const AnnotationTemplateImagesKey = "demo.cyisme.top/template-images"
+
+// templateImages are the images of the containers of a template before the update named Update set its own
+type templateImages struct {
+	Update string            `json:"update"`
+	Images map[string]string `json:"images"`
+}
+
+// setTemplateImages sets the images of the update in the template and reports whether it changed
+func setTemplateImages(spec v1.InplaceUpdateSpec, template *corev1.PodTemplateSpec) bool {
+	changed := false
+	containers := updateContainers(spec, template.Spec)
+	for _, target := range spec.Containers {
+		if container, exist := containers[target.Name]; exist && container.Image != target.Image {
+			container.Image = target.Image
+			changed = true
+		}
+	}
+	return changed
+}
+
+// findNewReplicaSet returns the new replicaset of the deployment. The images of the update may have been set
+// in the template of the replicaset but not yet in the one of the deployment.
+func findNewReplicaSet(i *v1.InplaceUpdate, d *appsv1.Deployment, replicaSets []*appsv1.ReplicaSet) *appsv1.ReplicaSet {
+	if rs := deploymentutil.FindNewReplicaSet(d, replicaSets); rs != nil {
+		return rs
+	}
+	propagated := d.DeepCopy()
+	setTemplateImages(i.Spec, &propagated.Spec.Template)
+	return deploymentutil.FindNewReplicaSet(propagated, replicaSets)
+}
+
+// propagateImages sets the images of the update in the template of the replicaset and then of the deployment,
+// so the pods the replicaset creates while and after the update, to scale or to replace deleted pods, run them. The deployment is paused meanwhile, and both templates
+// still only differ by the pod-template-hash label, so the replicaset stays the new replicaset of the
+// deployment and no rollout starts when the deployment is resumed.
+func (r *RealDeploymentControl) propagateImages(i *v1.InplaceUpdate, d *appsv1.Deployment, rs *appsv1.ReplicaSet) error {
+	latestRs := rs.DeepCopy()
+	if err := r.patchTemplateImages(i, latestRs, &latestRs.Spec.Template); err != nil {
+		return fmt.Errorf("failed to set the images of replicaset %s: %v", rs.Name, err)
+	}
+	latestDeployment := d.DeepCopy()
+	if err := r.patchTemplateImages(i, latestDeployment, &latestDeployment.Spec.Template); err != nil {
+		return fmt.Errorf("failed to set the images of deployment %s: %v", d.Name, err)
+	}
+	return nil
+}
+
+// patchTemplateImages sets the images of the update in the template of obj, which points into obj.
+// Conflicts are retried with a fresh read.
+func (r *RealDeploymentControl) patchTemplateImages(i *v1.InplaceUpdate, obj client.Object, template *corev1.PodTemplateSpec) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		if err := r.Client.Get(context.TODO(), client.ObjectKeyFromObject(obj), obj); err != nil {
+			return err
+		}
+		patch := client.MergeFromWithOptions(obj.DeepCopyObject().(client.Object), client.MergeFromWithOptimisticLock{})
+		previous := templateImages{Update: i.Name, Images: make(map[string]string)}
+		for name, container := range updateContainers(i.Spec, template.Spec) {
+			previous.Images[name] = container.Image
+		}
+		if !setTemplateImages(i.Spec, template) {
+			return nil
+		}
+		// the images of the first change are kept, they are the ones to put back
+		if saved := savedTemplateImages(obj); saved == nil || saved.Update != i.Name {
+			value, err := json.Marshal(previous)
+			if err != nil {
+				return err
+			}
+			annotations := obj.GetAnnotations()
+			if annotations == nil {
+				annotations = make(map[string]string)
+			}
+			annotations[AnnotationTemplateImagesKey] = string(value)
+			obj.SetAnnotations(annotations)
+		}
+		return r.Client.Patch(context.TODO(), obj, patch)
+	})
+}
+
+// restoreTemplateImages puts back the images the templates of the deployment and of its replicasets had before the
+// update set its own, the containers changed since are left as they are. With restore unset, once the update
+// finished, only the saved images are dropped. The deployment is paused meanwhile so it does not roll out a new
+// replicaset while the templates differ, the caller resumes it.
+func (r *RealDeploymentControl) restoreTemplateImages(i *v1.InplaceUpdate, d *appsv1.Deployment, restore bool) error {
+	replicaSets, err := r.getReplicaSetsForDeployment(d)
+	if err != nil {
+		return err
+	}
+	var saved []*appsv1.ReplicaSet
+	for _, rs := range replicaSets {
+		if _, exist := rs.Annotations[AnnotationTemplateImagesKey]; exist {
+			saved = append(saved, rs)
+		}
+	}
+	if _, exist := d.Annotations[AnnotationTemplateImagesKey]; !exist && len(saved) == 0 {
+		return nil
+	}
+	if restore {
+		owned, err := r.ownsDeploymentPause(i, d)
+		if err != nil {
+			return err
+		}
+		if owned {
+			if err := r.setDeploymentPaused(d, i, true); err != nil {
+				return err
+			}
+		}
+	}
+	latestDeployment := d.DeepCopy()
+	if err := r.restoreObjectTemplateImages(i, latestDeployment, &latestDeployment.Spec.Template, restore); err != nil {
+		return fmt.Errorf("failed to restore the images of deployment %s: %v", d.Name, err)
+	}
+	for _, rs := range saved {
+		latestRs := rs.DeepCopy()
+		if err := r.restoreObjectTemplateImages(i, latestRs, &latestRs.Spec.Template, restore); err != nil {
+			return fmt.Errorf("failed to restore the images of replicaset %s: %v", rs.Name, err)
+		}
+	}
+	return nil
+}
+
+// restoreObjectTemplateImages restores the template of obj, which points into obj, from the images saved by the update
+func (r *RealDeploymentControl) restoreObjectTemplateImages(i *v1.InplaceUpdate, obj client.Object, template *corev1.PodTemplateSpec, restore bool) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		if err := r.Client.Get(context.TODO(), client.ObjectKeyFromObject(obj), obj); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		if _, exist := obj.GetAnnotations()[AnnotationTemplateImagesKey]; !exist {
+			return nil
+		}
+		saved := savedTemplateImages(obj)
+		if saved != nil && saved.Update != i.Name {
+			return nil
+		}
+		patch := client.MergeFromWithOptions(obj.DeepCopyObject().(client.Object), client.MergeFromWithOptimisticLock{})
+		if restore && saved != nil {
+			containers := updateContainers(i.Spec, template.Spec)
+			for _, target := range i.Spec.Containers {
+				container, exist := containers[target.Name]
+				if image, saved := saved.Images[target.Name]; exist && saved && container.Image == target.Image {
+					container.Image = image
+				}
+			}
+		}
+		annotations := obj.GetAnnotations()
+		delete(annotations, AnnotationTemplateImagesKey)
+		obj.SetAnnotations(annotations)
+		return r.Client.Patch(context.TODO(), obj, patch)
+	})
+}
+
+// savedTemplateImages returns the images saved on obj, nil if there are none or they can not be read
+func savedTemplateImages(obj client.Object) *templateImages {
+	value, exist := obj.GetAnnotations()[AnnotationTemplateImagesKey]
+	if !exist {
+		return nil
+	}
+	saved := &templateImages{}
+	if err := json.Unmarshal([]byte(value), saved); err != nil {
+		return nil
+	}
+	return saved
+}
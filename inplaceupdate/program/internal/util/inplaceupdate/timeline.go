@@ -0,0 +1,107 @@
+package inplaceupdate
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+	"github.com/Forget-C/demo/inplaceupdate/program/internal/util"
+)
+
+// RemainingDelay returns how long the update still has to wait before it starts.
+// The delay is counted from the creation of the update and only applies before it has started.
+func RemainingDelay(obj *v1.InplaceUpdate, now time.Time) time.Duration {
+	if obj.Spec.Delay == nil || *obj.Spec.Delay <= 0 || obj.Status.StartTime != nil {
+		return 0
+	}
+	startAt := obj.CreationTimestamp.Add(time.Duration(*obj.Spec.Delay) * time.Second)
+	if remaining := startAt.Sub(now); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// WaveInProgress returns the latest wave if it has not completed yet
+func WaveInProgress(status *v1.InplaceUpdateStatus) *v1.InplaceUpdateWave {
+	if len(status.Waves) == 0 {
+		return nil
+	}
+	wave := &status.Waves[len(status.Waves)-1]
+	if wave.CompletionTime != nil {
+		return nil
+	}
+	return wave
+}
+
+// startWave records the pods updated in place and the pods recreated as a new wave
+func startWave(status *v1.InplaceUpdateStatus, pods, recreatedPods []*corev1.Pod) {
+	if len(pods) == 0 && len(recreatedPods) == 0 {
+		return
+	}
+	now := metav1.Now()
+	wave := v1.InplaceUpdateWave{
+		Number:    int32(len(status.Waves)) + 1,
+		Pods:      util.PodNameList(append(append([]*corev1.Pod(nil), pods...), recreatedPods...)),
+		StartTime: &now,
+	}
+	if len(recreatedPods) != 0 {
+		wave.RecreatedPods = util.PodNameList(recreatedPods)
+	}
+	status.Waves = append(status.Waves, wave)
+}
+
+// completeWave marks the wave in progress as completed once each of its pods is either
+// done or no longer part of the target
+func completeWave(status *v1.InplaceUpdateStatus, livePods, donePods sets.Set[string]) {
+	wave := WaveInProgress(status)
+	if wave == nil {
+		return
+	}
+	for _, name := range wave.Pods {
+		if livePods.Has(name) && !donePods.Has(name) {
+			return
+		}
+	}
+	now := metav1.Now()
+	wave.CompletionTime = &now
+}
+
+// lastProgressTime is the latest of the start time, the start or completion of a wave,
+// a successful analysis measurement and the last time the update was paused or resumed
+func lastProgressTime(status *v1.InplaceUpdateStatus) time.Time {
+	var last time.Time
+	latest := func(t *metav1.Time) {
+		if t != nil && t.Time.After(last) {
+			last = t.Time
+		}
+	}
+	latest(status.StartTime)
+	for i := range status.Waves {
+		latest(status.Waves[i].StartTime)
+		latest(status.Waves[i].CompletionTime)
+		if analysis := status.Waves[i].Analysis; analysis != nil {
+			for j := range analysis.Measurements {
+				if analysis.Measurements[j].Phase == v1.MeasurementPhaseSuccessful {
+					latest(&analysis.Measurements[j].Time)
+				}
+			}
+		}
+	}
+	if paused := meta.FindStatusCondition(status.Conditions, v1.InplaceUpdateConditionPaused); paused != nil {
+		latest(&paused.LastTransitionTime)
+	}
+	return last
+}
+
+// ProgressDeadlineExceeded reports whether the update has not made progress within spec.progressDeadlineSeconds
+func ProgressDeadlineExceeded(obj *v1.InplaceUpdate, status *v1.InplaceUpdateStatus, now time.Time) bool {
+	if obj.Spec.ProgressDeadlineSeconds == nil || status.StartTime == nil {
+		return false
+	}
+	deadline := time.Duration(*obj.Spec.ProgressDeadlineSeconds) * time.Second
+	return now.Sub(lastProgressTime(status)) > deadline
+}
@@ -0,0 +1,87 @@
+package inplaceupdate
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+func TestRemainingDelay(t *testing.T) {
+	created := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	started := metav1.NewTime(created.Add(time.Minute))
+	tests := []struct {
+		name      string
+		delay     *int32
+		startTime *metav1.Time
+		now       time.Time
+		want      time.Duration
+	}{
+		{name: "no delay", now: created},
+		{name: "zero delay", delay: ptr.To[int32](0), now: created},
+		{name: "waiting", delay: ptr.To[int32](60), now: created.Add(20 * time.Second), want: 40 * time.Second},
+		{name: "delay over", delay: ptr.To[int32](60), now: created.Add(2 * time.Minute)},
+		{name: "already started", delay: ptr.To[int32](600), startTime: &started, now: created.Add(2 * time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &v1.InplaceUpdate{
+				ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(created)},
+				Spec:       v1.InplaceUpdateSpec{Delay: tt.delay},
+				Status:     v1.InplaceUpdateStatus{StartTime: tt.startTime},
+			}
+			if got := RemainingDelay(obj, tt.now); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestProgressDeadlineExceeded(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *metav1.Time {
+		t := metav1.NewTime(start.Add(d))
+		return &t
+	}
+	tests := []struct {
+		name     string
+		deadline *int32
+		status   v1.InplaceUpdateStatus
+		now      time.Time
+		want     bool
+	}{
+		{name: "no deadline", status: v1.InplaceUpdateStatus{StartTime: at(0)}, now: start.Add(time.Hour)},
+		{name: "not started", deadline: ptr.To[int32](60), now: start.Add(time.Hour)},
+		{name: "within the deadline", deadline: ptr.To[int32](600), status: v1.InplaceUpdateStatus{StartTime: at(0)}, now: start.Add(5 * time.Minute)},
+		{name: "no progress since the start", deadline: ptr.To[int32](600), status: v1.InplaceUpdateStatus{StartTime: at(0)},
+			now: start.Add(11 * time.Minute), want: true},
+		{name: "wave completed lately", deadline: ptr.To[int32](600), status: v1.InplaceUpdateStatus{StartTime: at(0),
+			Waves: []v1.InplaceUpdateWave{{Number: 1, StartTime: at(time.Minute), CompletionTime: at(8 * time.Minute)}}},
+			now: start.Add(15 * time.Minute)},
+		{name: "successful measurement", deadline: ptr.To[int32](600), status: v1.InplaceUpdateStatus{StartTime: at(0),
+			Waves: []v1.InplaceUpdateWave{{Number: 1, StartTime: at(0), CompletionTime: at(time.Minute), Analysis: &v1.WaveAnalysis{
+				Measurements: []v1.MetricMeasurement{
+					{Phase: v1.MeasurementPhaseSuccessful, Time: *at(9 * time.Minute)},
+					{Phase: v1.MeasurementPhaseInconclusive, Time: *at(14 * time.Minute)},
+				}}}}},
+			now: start.Add(15 * time.Minute)},
+		{name: "inconclusive measurements are no progress", deadline: ptr.To[int32](600), status: v1.InplaceUpdateStatus{StartTime: at(0),
+			Waves: []v1.InplaceUpdateWave{{Number: 1, StartTime: at(0), CompletionTime: at(time.Minute), Analysis: &v1.WaveAnalysis{
+				Measurements: []v1.MetricMeasurement{{Phase: v1.MeasurementPhaseInconclusive, Time: *at(14 * time.Minute)}}}}}},
+			now: start.Add(15 * time.Minute), want: true},
+		{name: "resumed lately", deadline: ptr.To[int32](600), status: v1.InplaceUpdateStatus{StartTime: at(0),
+			Conditions: []metav1.Condition{{Type: v1.InplaceUpdateConditionPaused, Status: metav1.ConditionFalse, LastTransitionTime: *at(10 * time.Minute)}}},
+			now: start.Add(15 * time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &v1.InplaceUpdate{Spec: v1.InplaceUpdateSpec{ProgressDeadlineSeconds: tt.deadline}}
+			if got := ProgressDeadlineExceeded(obj, &tt.status, tt.now); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package inplaceupdate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+// podTopology is where a pod runs and how early it is updated
+type podTopology struct {
+	pod      *corev1.Pod
+	zone     string
+	host     string
+	cordoned bool
+	priority int64
+}
+
+// orderCandidates orders the pods to be updated into groups by spec.ordering. Pods with a higher priority
+// go first, then the pods on cordoned nodes, then the pods are interleaved across the zones and the nodes
+// with the Spread policy, or ordered by name. With the ZoneByZone policy each zone is a group, the zone of
+// the pod going first comes first. A wave only takes pods of the first group.
+func (r *RealDeploymentControl) orderCandidates(i *v1.InplaceUpdate, pods []*corev1.Pod) ([][]*corev1.Pod, error) {
+	if len(pods) == 0 {
+		return nil, nil
+	}
+	nodeList := &corev1.NodeList{}
+	if err := r.Client.List(context.TODO(), nodeList); err != nil {
+		return nil, err
+	}
+	nodes := make(map[string]*corev1.Node, len(nodeList.Items))
+	for idx := range nodeList.Items {
+		nodes[nodeList.Items[idx].Name] = &nodeList.Items[idx]
+	}
+	ordering := i.Spec.Ordering
+	if ordering == nil {
+		ordering = &v1.WaveOrdering{}
+	}
+	topologies := make([]podTopology, 0, len(pods))
+	for _, pod := range pods {
+		t := podTopology{pod: pod, host: pod.Spec.NodeName, priority: podPriority(ordering, pod)}
+		if node, exist := nodes[pod.Spec.NodeName]; exist {
+			t.zone = node.Labels[corev1.LabelTopologyZone]
+			if host := node.Labels[corev1.LabelHostname]; host != "" {
+				t.host = host
+			}
+			t.cordoned = node.Spec.Unschedulable
+		}
+		topologies = append(topologies, t)
+	}
+	sort.SliceStable(topologies, func(a, b int) bool {
+		if topologies[a].priority != topologies[b].priority {
+			return topologies[a].priority > topologies[b].priority
+		}
+		if topologies[a].cordoned != topologies[b].cordoned {
+			return topologies[a].cordoned
+		}
+		return topologies[a].pod.Name < topologies[b].pod.Name
+	})
+
+	switch ordering.Policy {
+	case v1.WaveOrderingZoneByZone:
+		var groups [][]*corev1.Pod
+		for _, zone := range groupTopologies(topologies, func(t podTopology) string { return t.zone }) {
+			groups = append(groups, spreadHosts(zone))
+		}
+		return groups, nil
+	case v1.WaveOrderingSpread:
+		// each rank of priority and cordoned is spread on its own, so the spread does not undo them
+		var ordered []*corev1.Pod
+		for _, rank := range groupTopologies(topologies, func(t podTopology) string { return fmt.Sprintf("%d/%t", t.priority, t.cordoned) }) {
+			var zones [][]*corev1.Pod
+			for _, zone := range groupTopologies(rank, func(t podTopology) string { return t.zone }) {
+				zones = append(zones, spreadHosts(zone))
+			}
+			ordered = append(ordered, interleave(zones)...)
+		}
+		return [][]*corev1.Pod{ordered}, nil
+	default:
+		ordered := make([]*corev1.Pod, 0, len(topologies))
+		for _, t := range topologies {
+			ordered = append(ordered, t.pod)
+		}
+		return [][]*corev1.Pod{ordered}, nil
+	}
+}
+
+// spreadHosts interleaves the pods of the nodes
+func spreadHosts(topologies []podTopology) []*corev1.Pod {
+	var hosts [][]*corev1.Pod
+	for _, host := range groupTopologies(topologies, func(t podTopology) string { return t.host }) {
+		pods := make([]*corev1.Pod, 0, len(host))
+		for _, t := range host {
+			pods = append(pods, t.pod)
+		}
+		hosts = append(hosts, pods)
+	}
+	return interleave(hosts)
+}
+
+// groupTopologies groups the pods by the key, the groups come in the order of their first pod
+func groupTopologies(topologies []podTopology, key func(podTopology) string) [][]podTopology {
+	var groups [][]podTopology
+	index := make(map[string]int)
+	for _, t := range topologies {
+		k := key(t)
+		idx, exist := index[k]
+		if !exist {
+			idx = len(groups)
+			index[k] = idx
+			groups = append(groups, nil)
+		}
+		groups[idx] = append(groups[idx], t)
+	}
+	return groups
+}
+
+// interleave takes a pod of each list in turn
+func interleave(lists [][]*corev1.Pod) []*corev1.Pod {
+	var pods []*corev1.Pod
+	for n := 0; ; n++ {
+		taken := false
+		for _, list := range lists {
+			if n < len(list) {
+				pods = append(pods, list[n])
+				taken = true
+			}
+		}
+		if !taken {
+			return pods
+		}
+	}
+}
+
+// podPriority is the integer value of the priority label or annotation of the pod, 0 if it is missing or invalid
+func podPriority(ordering *v1.WaveOrdering, pod *corev1.Pod) int64 {
+	var value string
+	switch {
+	case ordering.PriorityLabel != "":
+		value = pod.Labels[ordering.PriorityLabel]
+	case ordering.PriorityAnnotation != "":
+		value = pod.Annotations[ordering.PriorityAnnotation]
+	}
+	priority, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return priority
+}
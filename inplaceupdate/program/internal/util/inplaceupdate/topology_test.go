@@ -0,0 +1,76 @@
+package inplaceupdate
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+func TestOrderCandidates(t *testing.T) {
+	node := func(name, zone string, cordoned bool) client.Object {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{corev1.LabelTopologyZone: zone, corev1.LabelHostname: name}},
+			Spec:       corev1.NodeSpec{Unschedulable: cordoned},
+		}
+	}
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		node("a1", "zone-a", false), node("a2", "zone-a", false), node("b1", "zone-b", false), node("b2", "zone-b", true),
+	).Build()
+	r := NewRealDeploymentControl(c, &record.FakeRecorder{}, nil)
+
+	// pods are named after their node
+	var pods []*corev1.Pod
+	for _, name := range []string{"a1-x", "a1-y", "a1-z", "a2-x", "b1-x", "b1-y", "b2-x"} {
+		pods = append(pods, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{}},
+			Spec:       corev1.PodSpec{NodeName: name[:2]},
+		})
+	}
+	pods[4].Labels["example.com/priority"] = "10"
+
+	tests := []struct {
+		name     string
+		ordering *v1.WaveOrdering
+		// groups are the names of the pods of each group, separated by spaces
+		groups []string
+	}{
+		{name: "default", groups: []string{"b2-x a1-x a1-y a1-z a2-x b1-x b1-y"}},
+		{name: "spread", ordering: &v1.WaveOrdering{Policy: v1.WaveOrderingSpread},
+			groups: []string{"b2-x a1-x b1-x a2-x b1-y a1-y a1-z"}},
+		{name: "zone by zone", ordering: &v1.WaveOrdering{Policy: v1.WaveOrderingZoneByZone},
+			groups: []string{"b2-x b1-x b1-y", "a1-x a2-x a1-y a1-z"}},
+		{name: "priority label", ordering: &v1.WaveOrdering{Policy: v1.WaveOrderingSpread, PriorityLabel: "example.com/priority"},
+			groups: []string{"b1-x b2-x a1-x b1-y a2-x a1-y a1-z"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &v1.InplaceUpdate{Spec: v1.InplaceUpdateSpec{Ordering: tt.ordering}}
+			groups, err := r.orderCandidates(i, pods)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, group := range groups {
+				var names []string
+				for _, pod := range group {
+					names = append(names, pod.Name)
+				}
+				got = append(got, strings.Join(names, " "))
+			}
+			if strings.Join(got, ", ") != strings.Join(tt.groups, ", ") {
+				t.Errorf("expected groups %q, got %q", tt.groups, got)
+			}
+		})
+	}
+}
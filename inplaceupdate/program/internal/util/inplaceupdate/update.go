@@ -7,6 +7,7 @@ import (
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/util/retry"
 	"k8s.io/client-go/util/workqueue"
@@ -29,6 +30,9 @@ type podUpdater struct {
 }
 
 func (p *podUpdater) Update(pods []*corev1.Pod) (finishedPods []*corev1.Pod, failedPods []*corev1.Pod, err error) {
+	if len(pods) == 0 {
+		return
+	}
 	q := workqueue.NewRateLimitingQueue(&podSyncQueueRateLimit{})
 	podTotal := int32(len(pods))
 	finishedTotal := atomic.Int32{}
@@ -48,7 +52,10 @@ func (p *podUpdater) Update(pods []*corev1.Pod) (finishedPods []*corev1.Pod, fai
 				break
 			}
 			pod := obj.(*corev1.Pod)
-			if err := p.refreshPod(pod); err != nil {
+			if err := p.refreshPod(pod); apierrors.IsNotFound(err) {
+				// the pod was deleted meanwhile, it leaves the update
+				complete(pod)
+			} else if err != nil {
 				if q.NumRequeues(pod) >= podRetryLimit {
 					complete(pod)
 					failedPods = append(failedPods, pod)
@@ -69,8 +76,21 @@ func (p *podUpdater) Update(pods []*corev1.Pod) (finishedPods []*corev1.Pod, fai
 	return
 }
 
+// refreshPod patches the images and the state annotation of pod onto the live object,
+// other fields of the live object are left untouched.
+// Conflicts are retried with a fresh read and do not count toward podRetryLimit.
 func (p *podUpdater) refreshPod(pod *corev1.Pod) error {
-	return p.Client.Update(context.TODO(), pod)
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		live := &corev1.Pod{}
+		if err := p.Client.Get(context.TODO(), client.ObjectKeyFromObject(pod), live); err != nil {
+			return err
+		}
+		patch, err := newPodImagePatch(live, pod)
+		if err != nil {
+			return err
+		}
+		return p.Client.Patch(context.TODO(), live, patch)
+	})
 }
 
 type podSyncQueueRateLimit struct {
@@ -80,8 +100,11 @@ type podSyncQueueRateLimit struct {
 
 // When anyway returns the requeue delay
 func (p *podSyncQueueRateLimit) When(item interface{}) time.Duration {
-	p.failuresLock.Unlock()
+	p.failuresLock.Lock()
 	defer p.failuresLock.Unlock()
+	if p.failures == nil {
+		p.failures = make(map[interface{}]int)
+	}
 	p.failures[item] = p.failures[item] + 1
 	return podReAddDelay
 }
@@ -100,22 +123,34 @@ func (p *podSyncQueueRateLimit) NumRequeues(item interface{}) int {
 	return p.failures[item]
 }
 
-func newStatusUpdater(c client.Client) StatusUpdater {
-	return &statusUpdater{Client: c}
+func newStatusUpdater(c client.Client, notifier *Notifier) StatusUpdater {
+	return &statusUpdater{Client: c, notifier: notifier}
 }
 
 type statusUpdater struct {
-	Client client.Client
+	Client   client.Client
+	notifier *Notifier
 }
 
+// Update writes the status, the transitions from the status it replaced are notified once written
 func (s *statusUpdater) Update(obj *v1.InplaceUpdate, status *v1.InplaceUpdateStatus) error {
-	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
-		clone := &v1.InplaceUpdate{}
+	var previous v1.InplaceUpdateStatus
+	clone := &v1.InplaceUpdate{}
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
 		err := s.Client.Get(context.Background(), types.NamespacedName{Namespace: obj.Namespace, Name: obj.Name}, clone)
 		if err != nil {
 			return err
 		}
+		patch := client.MergeFromWithOptions(clone.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		previous = clone.Status
 		clone.Status = *status
-		return s.Client.Status().Update(context.Background(), clone)
+		return s.Client.Status().Patch(context.Background(), clone, patch)
 	})
+	if err != nil {
+		return err
+	}
+	if s.notifier != nil {
+		s.notifier.Notify(context.Background(), clone, notifications(clone, &previous))
+	}
+	return nil
 }
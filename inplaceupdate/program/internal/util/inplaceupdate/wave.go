@@ -0,0 +1,130 @@
+package inplaceupdate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+var defaultMaxUnavailable = intstr.FromString("20%")
+
+// MaxUnavailable returns the absolute number of pods that can be unavailable during the update,
+// rounded up and at least 1. With surge pods it is rounded down and can be 0, the surge pods
+// make up for the pods restarted.
+func MaxUnavailable(spec v1.InplaceUpdateSpec, replicas int32) int32 {
+	maxUnavailable := &defaultMaxUnavailable
+	if spec.MaxUnavailable != nil {
+		maxUnavailable = spec.MaxUnavailable
+	}
+	surge := MaxSurge(spec, replicas) > 0
+	value, err := intstr.GetScaledValueFromIntOrPercent(maxUnavailable, int(replicas), !surge)
+	if surge && err == nil && value >= 0 {
+		return int32(value)
+	}
+	if err != nil || value < 1 {
+		return 1
+	}
+	return int32(value)
+}
+
+// NeedsUpdate reports whether any of the containers still runs an image other than the target one
+func NeedsUpdate(targets []v1.InplaceUpdateArgs, containers map[string]*corev1.Container) bool {
+	for _, target := range targets {
+		if container, exist := containers[target.Name]; exist && container.Image != target.Image {
+			return true
+		}
+	}
+	return false
+}
+
+// selectWave picks the pods to be updated in this round. The wave is bounded by maxUnavailable
+// plus the ready surge pods minus the pods already unavailable, and by the disruptions allowed by
+// the PodDisruptionBudgets covering the pods. A pod is skipped if any budget covering it is exhausted.
+func (r *RealDeploymentControl) selectWave(i *v1.InplaceUpdate, candidates []*corev1.Pod, status *v1.InplaceUpdateStatus, surge int32) ([]*corev1.Pod, error) {
+	SetCondition(&status.Conditions, i.Generation, v1.InplaceUpdateConditionBlocked, metav1.ConditionFalse, "NotBlocked", "")
+	budget := MaxUnavailable(i.Spec, status.Replicas) + surge - status.UnavailableReplicas
+	if budget <= 0 || len(candidates) == 0 {
+		return nil, nil
+	}
+	pdbs, err := r.getPodDisruptionBudgets(i.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	allowed := make(map[string]int32, len(pdbs))
+	for _, pdb := range pdbs {
+		allowed[pdb.Name] = pdb.Status.DisruptionsAllowed
+	}
+	blockedBy := make(map[string]struct{})
+	var wave []*corev1.Pod
+	for _, pod := range candidates {
+		if int32(len(wave)) >= budget {
+			break
+		}
+		covering := podDisruptionBudgetsForPod(pdbs, pod)
+		blocked := false
+		for _, pdb := range covering {
+			if allowed[pdb.Name] <= 0 {
+				blockedBy[pdb.Name] = struct{}{}
+				blocked = true
+			}
+		}
+		if blocked {
+			continue
+		}
+		for _, pdb := range covering {
+			allowed[pdb.Name]--
+		}
+		wave = append(wave, pod)
+	}
+	if len(wave) == 0 && len(blockedBy) != 0 {
+		names := make([]string, 0, len(blockedBy))
+		for name := range blockedBy {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		SetCondition(&status.Conditions, i.Generation, v1.InplaceUpdateConditionBlocked, metav1.ConditionTrue, "PodDisruptionBudget",
+			fmt.Sprintf("no disruptions allowed by PodDisruptionBudget %s", strings.Join(names, ",")))
+	}
+	return wave, nil
+}
+
+func (r *RealDeploymentControl) getPodDisruptionBudgets(namespace string) ([]*policyv1.PodDisruptionBudget, error) {
+	pdbList := policyv1.PodDisruptionBudgetList{}
+	if err := r.Client.List(context.TODO(), &pdbList, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	pdbs := make([]*policyv1.PodDisruptionBudget, 0, len(pdbList.Items))
+	for i := range pdbList.Items {
+		pdbs = append(pdbs, &pdbList.Items[i])
+	}
+	return pdbs, nil
+}
+
+func podDisruptionBudgetsForPod(pdbs []*policyv1.PodDisruptionBudget, pod *corev1.Pod) []*policyv1.PodDisruptionBudget {
+	var matched []*policyv1.PodDisruptionBudget
+	for _, pdb := range pdbs {
+		// a missing selector matches nothing while an empty one matches every pod of the namespace,
+		// the same as the disruption controller for policy/v1
+		if pdb.Spec.Selector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil {
+			continue
+		}
+		if selector.Matches(labels.Set(pod.Labels)) {
+			matched = append(matched, pdb)
+		}
+	}
+	return matched
+}
@@ -0,0 +1,82 @@
+package inplaceupdate
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+func TestSelectWave(t *testing.T) {
+	pdb := func(name string, selector *metav1.LabelSelector, allowed int32) client.Object {
+		return &policyv1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Spec:       policyv1.PodDisruptionBudgetSpec{Selector: selector},
+			Status:     policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: allowed},
+		}
+	}
+	var candidates []*corev1.Pod
+	for _, name := range []string{"web-a", "web-b", "web-c", "worker-a"} {
+		candidates = append(candidates, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Labels: map[string]string{"app": strings.Split(name, "-")[0]}},
+		})
+	}
+	web := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+
+	tests := []struct {
+		name           string
+		maxUnavailable int32
+		unavailable    int32
+		pdbs           []client.Object
+		// wave is the names of the pods of the wave, separated by spaces
+		wave    string
+		blocked bool
+	}{
+		{name: "max unavailable", maxUnavailable: 2, wave: "web-a web-b"},
+		{name: "pods already unavailable", maxUnavailable: 2, unavailable: 1, wave: "web-a"},
+		{name: "budget allows one disruption", maxUnavailable: 4, pdbs: []client.Object{pdb("web", web, 1)}, wave: "web-a worker-a"},
+		{name: "exhausted budget", maxUnavailable: 4, pdbs: []client.Object{pdb("web", web, 0)}, wave: "worker-a"},
+		{name: "exhausted budget blocks the wave", maxUnavailable: 4, pdbs: []client.Object{pdb("all", &metav1.LabelSelector{}, 0)}, blocked: true},
+		{name: "empty selector covers the namespace", maxUnavailable: 4, pdbs: []client.Object{pdb("all", &metav1.LabelSelector{}, 2)}, wave: "web-a web-b"},
+		{name: "missing selector covers nothing", maxUnavailable: 4, pdbs: []client.Object{pdb("none", nil, 0)}, wave: "web-a web-b web-c worker-a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			_ = clientgoscheme.AddToScheme(scheme)
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(tt.pdbs...).Build()
+			r := NewRealDeploymentControl(c, &record.FakeRecorder{}, nil)
+			i := &v1.InplaceUpdate{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web-update"},
+				Spec:       v1.InplaceUpdateSpec{MaxUnavailable: ptr.To(intstr.FromInt32(tt.maxUnavailable))},
+			}
+			status := &v1.InplaceUpdateStatus{Replicas: int32(len(candidates)), UnavailableReplicas: tt.unavailable}
+			wave, err := r.selectWave(i, candidates, status, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, pod := range wave {
+				names = append(names, pod.Name)
+			}
+			if got := strings.Join(names, " "); got != tt.wave {
+				t.Errorf("expected wave %q, got %q", tt.wave, got)
+			}
+			if blocked := apimeta.IsStatusConditionTrue(status.Conditions, v1.InplaceUpdateConditionBlocked); blocked != tt.blocked {
+				t.Errorf("expected blocked %v, got %v", tt.blocked, blocked)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package inplaceupdate
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+// webResultPass is the result a verification service answers to let the update go on
+const webResultPass = "pass"
+
+// only the beginning of an unexpected answer is kept in the measurement
+const maxWebMessageBytes = 1024
+
+// webPayload is posted to the verification service of a web metric
+type webPayload struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Target    string `json:"target"`
+	Wave      int32  `json:"wave"`
+	// Pods are the pods updated so far
+	Pods []webPod `json:"pods"`
+}
+
+type webPod struct {
+	Name string `json:"name"`
+	IP   string `json:"ip"`
+	// Images are the images of the containers and init containers by name
+	Images map[string]string `json:"images"`
+}
+
+// webResult is the answer of a verification service
+type webResult struct {
+	Result  string `json:"result"`
+	Message string `json:"message,omitempty"`
+}
+
+func newWebPayload(obj *v1.InplaceUpdate, args analysisArgs) *webPayload {
+	payload := &webPayload{
+		Name:      obj.Name,
+		Namespace: obj.Namespace,
+		Target:    args.Target,
+		Wave:      args.Wave,
+		Pods:      []webPod{},
+	}
+	for _, pod := range args.updated {
+		images := make(map[string]string, len(pod.Spec.InitContainers)+len(pod.Spec.Containers))
+		for _, c := range pod.Spec.InitContainers {
+			images[c.Name] = c.Image
+		}
+		for _, c := range pod.Spec.Containers {
+			images[c.Name] = c.Image
+		}
+		payload.Pods = append(payload.Pods, webPod{Name: pod.Name, IP: pod.Status.PodIP, Images: images})
+	}
+	return payload
+}
+
+// verify posts the payload to the verification service. The measurement is successful on a 2xx answer
+// with the pass result and failed on any other answer, a request without an answer is an error.
+func (r *RealDeploymentControl) verify(ctx context.Context, namespace string, metric *v1.WebMetric, payload *webPayload) (v1.MeasurementPhase, string, string) {
+	httpClient, token, err := r.webClient(ctx, namespace, metric)
+	if err != nil {
+		return v1.MeasurementPhaseError, "", err.Error()
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return v1.MeasurementPhaseError, "", err.Error()
+	}
+	timeout := time.Duration(ptr.Deref(metric.TimeoutSeconds, v1.DefaultWebMetricTimeoutSeconds)) * time.Second
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metric.URL, bytes.NewReader(body))
+	if err != nil {
+		return v1.MeasurementPhaseError, "", err.Error()
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return v1.MeasurementPhaseError, "", err.Error()
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxWebMessageBytes))
+	if err != nil {
+		return v1.MeasurementPhaseError, "", err.Error()
+	}
+	result := &webResult{}
+	if err := json.Unmarshal(data, result); err != nil {
+		result.Message = strings.TrimSpace(string(data))
+	}
+	if resp.StatusCode/100 == 2 && result.Result == webResultPass {
+		return v1.MeasurementPhaseSuccessful, result.Result, result.Message
+	}
+	return v1.MeasurementPhaseFailed, result.Result, strings.TrimSpace(fmt.Sprintf("%s %s", resp.Status, result.Message))
+}
+
+// webClient builds the client of a web metric and reads its bearer token,
+// the secrets are read from the namespace of the update
+func (r *RealDeploymentControl) webClient(ctx context.Context, namespace string, metric *v1.WebMetric) (*http.Client, string, error) {
+	var token string
+	if ref := metric.BearerTokenSecretRef; ref != nil {
+		value, err := secretValue(ctx, r.Client, namespace, ref)
+		if err != nil {
+			return nil, "", err
+		}
+		token = strings.TrimSpace(string(value))
+	}
+	if metric.TLS == nil {
+		return r.httpClient, token, nil
+	}
+	config := &tls.Config{
+		ServerName:         metric.TLS.ServerName,
+		InsecureSkipVerify: metric.TLS.InsecureSkipVerify,
+	}
+	if ref := metric.TLS.CASecretRef; ref != nil {
+		value, err := secretValue(ctx, r.Client, namespace, ref)
+		if err != nil {
+			return nil, "", err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(value) {
+			return nil, "", fmt.Errorf("secret %s/%s key %s holds no PEM encoded certificate", namespace, ref.Name, ref.Key)
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	// the client only lives for one request
+	transport.DisableKeepAlives = true
+	return &http.Client{Transport: transport}, token, nil
+}
+
+// LabelAllowReferenceKey opts a secret in to be referenced by analysis metrics and notification sinks,
+// so that creating an InplaceUpdate does not give away every secret of the namespace
+const LabelAllowReferenceKey = "demo.cyisme.top/allow-reference"
+
+// secretValue reads the key of a secret of the namespace, the secret must be labeled with LabelAllowReferenceKey.
+// Secrets are not cached by the manager, so the secret is read from the API server.
+func secretValue(ctx context.Context, c client.Reader, namespace string, ref *corev1.SecretKeySelector) ([]byte, error) {
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, secret); err != nil {
+		return nil, err
+	}
+	if secret.Labels[LabelAllowReferenceKey] != "true" {
+		return nil, fmt.Errorf("secret %s/%s is not labeled %s=true", namespace, ref.Name, LabelAllowReferenceKey)
+	}
+	value, exist := secret.Data[ref.Key]
+	if !exist {
+		return nil, fmt.Errorf("secret %s/%s has no key %s", namespace, ref.Name, ref.Key)
+	}
+	return value, nil
+}
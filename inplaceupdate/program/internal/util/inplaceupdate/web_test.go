@@ -0,0 +1,129 @@
+package inplaceupdate
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	v1 "github.com/Forget-C/demo/inplaceupdate/program/api/v1"
+)
+
+func TestVerify(t *testing.T) {
+	var received *webPayload
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		received = &webPayload{}
+		if err := json.NewDecoder(req.Body).Decode(received); err != nil {
+			t.Error(err)
+		}
+		switch req.URL.Path {
+		case "/pass":
+			w.Write([]byte(`{"result":"pass"}`))
+		case "/fail":
+			w.Write([]byte(`{"result":"fail","message":"checkout smoke test failed"}`))
+		default:
+			http.Error(w, "boom", http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "smoke-tests", Labels: map[string]string{LabelAllowReferenceKey: "true"}},
+		Data:       map[string][]byte{"token": []byte("s3cr3t\n"), "ca.crt": ca},
+	}
+	// a secret not labeled to be referenced is never sent
+	private := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "private"},
+		Data:       map[string][]byte{"token": []byte("s3cr3t")},
+	}
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	r := NewRealDeploymentControl(fake.NewClientBuilder().WithScheme(scheme).WithObjects(secret, private).Build(), &record.FakeRecorder{}, nil)
+	selector := func(key string) *corev1.SecretKeySelector {
+		return &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "smoke-tests"}, Key: key}
+	}
+	obj := &v1.InplaceUpdate{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "nginx-update"}}
+	args := analysisArgs{Target: "nginx", Wave: 2, updated: []*corev1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx-a"},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "envoy", Image: "envoy:1.29"}},
+			Containers:     []corev1.Container{{Name: "nginx", Image: "nginx:1.25.4"}},
+		},
+		Status: corev1.PodStatus{PodIP: "10.0.0.7"},
+	}}}
+
+	tests := []struct {
+		name    string
+		path    string
+		token   string
+		secret  string
+		tls     *v1.WebTLSConfig
+		phase   v1.MeasurementPhase
+		message string
+	}{
+		{name: "pass", path: "/pass", token: "token", tls: &v1.WebTLSConfig{CASecretRef: selector("ca.crt")}, phase: v1.MeasurementPhaseSuccessful},
+		{name: "fail", path: "/fail", token: "token", tls: &v1.WebTLSConfig{InsecureSkipVerify: true},
+			phase: v1.MeasurementPhaseFailed, message: "200 OK checkout smoke test failed"},
+		{name: "server error", path: "/other", token: "token", tls: &v1.WebTLSConfig{CASecretRef: selector("ca.crt")},
+			phase: v1.MeasurementPhaseFailed, message: "500 Internal Server Error boom"},
+		{name: "unauthorized", path: "/pass", tls: &v1.WebTLSConfig{CASecretRef: selector("ca.crt")},
+			phase: v1.MeasurementPhaseFailed, message: "401 Unauthorized"},
+		{name: "untrusted certificate", path: "/pass", token: "token", phase: v1.MeasurementPhaseError},
+		{name: "missing key", path: "/pass", token: "password", phase: v1.MeasurementPhaseError,
+			message: "secret default/smoke-tests has no key password"},
+		{name: "secret not labeled", path: "/pass", token: "token", secret: "private", tls: &v1.WebTLSConfig{InsecureSkipVerify: true},
+			phase: v1.MeasurementPhaseError, message: "secret default/private is not labeled demo.cyisme.top/allow-reference=true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metric := &v1.WebMetric{URL: server.URL + tt.path, TLS: tt.tls}
+			if tt.token != "" {
+				metric.BearerTokenSecretRef = selector(tt.token)
+			}
+			if tt.secret != "" {
+				metric.BearerTokenSecretRef.Name = tt.secret
+			}
+			phase, _, message := r.verify(context.Background(), "default", metric, newWebPayload(obj, args))
+			if phase != tt.phase {
+				t.Errorf("expected %s, got %s: %s", tt.phase, phase, message)
+			}
+			if tt.message != "" && message != tt.message {
+				t.Errorf("expected message %q, got %q", tt.message, message)
+			}
+		})
+	}
+	if received == nil || received.Name != "nginx-update" || received.Target != "nginx" || received.Wave != 2 || len(received.Pods) != 1 ||
+		received.Pods[0].IP != "10.0.0.7" || received.Pods[0].Images["envoy"] != "envoy:1.29" || received.Pods[0].Images["nginx"] != "nginx:1.25.4" {
+		t.Errorf("unexpected payload %+v", received)
+	}
+}
+
+func TestRestartFailedAnalysis(t *testing.T) {
+	completed := metav1.NewTime(time.Now())
+	status := &v1.InplaceUpdateStatus{Waves: []v1.InplaceUpdateWave{{
+		Number:         1,
+		CompletionTime: &completed,
+		Analysis: &v1.WaveAnalysis{Phase: v1.AnalysisPhaseFailed, Failed: 1, Successful: 2,
+			Measurements: []v1.MetricMeasurement{{Metric: "smoke-tests", Phase: v1.MeasurementPhaseFailed}}},
+	}}}
+	restartFailedAnalysis(status)
+	analysis := status.Waves[0].Analysis
+	if analysis.Phase != v1.AnalysisPhaseRunning || analysis.Failed != 0 || analysis.Successful != 0 || len(analysis.Measurements) != 1 {
+		t.Errorf("expected the analysis to start over keeping its measurements, got %+v", analysis)
+	}
+}
@@ -16,6 +16,28 @@ func FindContainer(name string, spec corev1.PodSpec) *corev1.Container {
 	return nil
 }
 
+func FindInitContainer(name string, spec corev1.PodSpec) *corev1.Container {
+	for i := range spec.InitContainers {
+		v := &spec.InitContainers[i]
+		if v.Name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+// IsRestartableInitContainer reports whether the init container is a native sidecar
+func IsRestartableInitContainer(container *corev1.Container) bool {
+	return container.RestartPolicy != nil && *container.RestartPolicy == corev1.ContainerRestartPolicyAlways
+}
+
+// AllContainerStatuses returns the statuses of both the containers and the init containers
+func AllContainerStatuses(status corev1.PodStatus) []corev1.ContainerStatus {
+	all := make([]corev1.ContainerStatus, 0, len(status.ContainerStatuses)+len(status.InitContainerStatuses))
+	all = append(all, status.ContainerStatuses...)
+	return append(all, status.InitContainerStatuses...)
+}
+
 func FindContainerStatus(name string, status []corev1.ContainerStatus) *corev1.ContainerStatus {
 	for i := range status {
 		v := &status[i]
@@ -47,11 +69,16 @@ func GetLatestContainerStatusMap(status []corev1.ContainerStatus, names ...strin
 }
 
 func PodNames(pods []*corev1.Pod) string {
+	return strings.Join(PodNameList(pods), ",")
+}
+
+// PodNameList returns the names of the pods in order
+func PodNameList(pods []*corev1.Pod) []string {
 	names := make([]string, 0, len(pods))
 	for _, pod := range pods {
 		names = append(names, pod.Name)
 	}
-	return strings.Join(names, ",")
+	return names
 }
 
 func FindContainers(names []string, spec corev1.PodSpec) map[string]*corev1.Container {
@@ -64,20 +91,3 @@ func FindContainers(names []string, spec corev1.PodSpec) map[string]*corev1.Cont
 	}
 	return containers
 }
-
-// ContainerMerge merge two container slices
-// Update a using data from b
-func ContainerMerge(a, b []corev1.Container) []corev1.Container {
-	m := make(map[string]corev1.Container)
-	for _, v := range a {
-		m[v.Name] = v
-	}
-	for _, v := range b {
-		m[v.Name] = v
-	}
-	result := make([]corev1.Container, 0, len(m))
-	for _, v := range m {
-		result = append(result, v)
-	}
-	return result
-}